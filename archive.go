@@ -0,0 +1,150 @@
+package concat
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrAlreadyArchived is returned for downloads that are already recorded in the Downloader.Archive.
+var ErrAlreadyArchived = errors.New("already in the download archive")
+
+// Kinds of downloads recorded in an Archive.
+const (
+	ArchiveKindVOD  string = "vod"
+	ArchiveKindClip string = "clip"
+)
+
+// ArchiveEntry is a finished download recorded in an Archive.
+type ArchiveEntry struct {
+	Kind    string `json:"kind"`
+	ID      string `json:"id"`
+	Start   string `json:"start,omitempty"`
+	End     string `json:"end,omitempty"`
+	Quality string `json:"quality"`
+
+	// File and Finished are informational and not compared by Archive.Contains.
+	File     string    `json:"file,omitempty"`
+	Finished time.Time `json:"finished"`
+}
+
+func (e ArchiveEntry) key() string {
+	return e.Kind + " " + e.ID + " " + e.Start + " " + e.End + " " + e.Quality
+}
+
+func vodArchiveEntry(vodID string, opts Options) ArchiveEntry {
+	end := "full"
+	if opts.End != 0 {
+		end = opts.End.String()
+	}
+	return ArchiveEntry{Kind: ArchiveKindVOD, ID: vodID, Start: opts.Start.String(), End: end, Quality: opts.Quality}
+}
+
+// Archive records finished downloads in a file with one JSON object per line, so they can be
+// skipped on later runs. It is safe for concurrent use.
+type Archive struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]ArchiveEntry
+}
+
+// OpenArchive reads the archive file at path. The file is created with the first recorded download
+// if it doesn't exist.
+func OpenArchive(path string) (*Archive, error) {
+	a := &Archive{path: path, entries: make(map[string]ArchiveEntry)}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e ArchiveEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		a.entries[e.key()] = e
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Contains reports whether a download with the same kind, id, range and quality as e was recorded.
+func (a *Archive) Contains(e ArchiveEntry) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.entries[e.key()]
+	return ok
+}
+
+// Record appends e to the archive file.
+func (a *Archive) Record(e ArchiveEntry) error {
+	if e.Finished.IsZero() {
+		e.Finished = time.Now().UTC()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	a.entries[e.key()] = e
+	return nil
+}
+
+/*
+Checks if the download is already archived. Returns ErrAlreadyArchived if it is
+*/
+func (d *Downloader) checkArchive(e ArchiveEntry) error {
+	if d.Archive != nil && d.Archive.Contains(e) {
+		d.printf("%s %s is already in the download archive, skipping\n", e.Kind, e.ID)
+		return ErrAlreadyArchived
+	}
+	return nil
+}
+
+/*
+Records a finished download if there is a download archive and one of the files was created
+*/
+func (d *Downloader) recordArchive(e ArchiveEntry, files ...string) error {
+	if d.Archive == nil {
+		return nil
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file); err == nil {
+			e.File = file
+			if err := d.Archive.Record(e); err != nil {
+				return fmt.Errorf("could not record download in archive: %v", err)
+			}
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package concat
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "concat_archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "archive.jsonl")
+	a, err := OpenArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.Start = 10 * time.Minute
+	full := vodArchiveEntry(vodString, DefaultOptions())
+	part := vodArchiveEntry(vodString, opts)
+
+	if a.Contains(full) {
+		t.Errorf("empty archive contains %+v", full)
+	}
+	if err := a.Record(full); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Record(ArchiveEntry{Kind: ArchiveKindClip, ID: "SwiftRage", Quality: SourceQuality}); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err = OpenArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full.File = "renamed.mp4"
+	if !a.Contains(full) {
+		t.Errorf("reopened archive doesn't contain %+v", full)
+	}
+	if a.Contains(part) {
+		t.Errorf("archive contains a different range %+v", part)
+	}
+	if !a.Contains(ArchiveEntry{Kind: ArchiveKindClip, ID: "SwiftRage", Quality: SourceQuality}) {
+		t.Errorf("archive doesn't contain the clip")
+	}
+
+	d := &Downloader{Archive: a}
+	opts = DefaultOptions()
+	opts.Filename = "renamed"
+	if err := d.Download(context.Background(), vodString, opts); err != ErrAlreadyArchived {
+		t.Errorf("Download of an archived vod: got %v, want ErrAlreadyArchived", err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{not json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenArchive(path); err == nil {
+		t.Errorf("expected error for invalid archive")
+	}
+}
@@ -0,0 +1,430 @@
+package concat
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Types of Asset.Type.
+const (
+	AssetEmote string = "emote"
+	AssetBadge string = "badge"
+)
+
+// ids and SHA-256 sums of chat files end up in paths, anything else could leave the directory
+var (
+	assetID   = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// Asset is an emote or badge image of an AssetProvider.
+type Asset struct {
+	// Provider is the name of the AssetProvider, like twitch or bttv.
+	Provider string `json:"provider"`
+	// Type is AssetEmote or AssetBadge.
+	Type string `json:"type"`
+	// ID of the emote at the provider, or the set and version of a badge like subscriber/12.
+	ID string `json:"id"`
+	// Name chatters type for emotes of providers other than twitch, twitch emotes are in
+	// ChatFragment.EmoteID.
+	Name string `json:"name,omitempty"`
+	// URL of the image at the provider.
+	URL string `json:"url"`
+
+	// SHA256 and ContentType of the image in the AssetCache, empty if it isn't cached.
+	SHA256      string `json:"sha256,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	// Data is the image as data URI if the assets are embedded.
+	Data string `json:"data,omitempty"`
+}
+
+// ChatAssets are the emotes and badges a Chat uses.
+type ChatAssets struct {
+	Emotes []Asset `json:"emotes"`
+	Badges []Asset `json:"badges"`
+}
+
+// AssetProvider lists the emotes and badges of a service, like TwitchAssets, BTTVAssets, FFZAssets
+// or SevenTVAssets.
+type AssetProvider interface {
+	// Name of the provider in Asset.Provider and the cache.
+	Name() string
+
+	// Assets returns the emotes and badges of the channel with the twitch user id channelID and
+	// the global ones, the ones of the channel first. Only global ones are returned if channelID
+	// is empty. Requests are sent with the HTTPClient of d.
+	Assets(ctx context.Context, d *Downloader, channelID string) ([]Asset, error)
+}
+
+// EmoteResolver is an AssetProvider that also finds emotes by the ChatFragment.EmoteID of twitch.
+type EmoteResolver interface {
+	AssetProvider
+	// EmoteURL returns the image of the emote with id.
+	EmoteURL(id string) string
+}
+
+// DefaultAssetProviders returns the providers AssetCache uses if it has none. Earlier providers
+// win if emotes of two providers have the same name.
+func DefaultAssetProviders() []AssetProvider {
+	return []AssetProvider{TwitchAssets{}, SevenTVAssets{}, BTTVAssets{}, FFZAssets{}}
+}
+
+// AssetCache keeps the images of emotes and badges in Dir, so they are downloaded once and can be
+// used offline. The images are stored by the SHA-256 of their content in objects/, index.json maps
+// their URLs to them and channels/ has the emotes and badges of every channel that was looked up.
+// It is safe for concurrent use.
+type AssetCache struct {
+	Dir string
+
+	// Providers of the emotes and badges, DefaultAssetProviders if nil.
+	Providers []AssetProvider
+
+	// Offline only uses what is in the cache, nothing is downloaded.
+	Offline bool
+
+	mu sync.Mutex
+	// index maps the URLs of images to their objects, read from index.json on first use
+	index map[string]cachedObject
+}
+
+// cachedObject is an entry of index.json
+type cachedObject struct {
+	SHA256      string `json:"sha256"`
+	ContentType string `json:"content_type"`
+}
+
+func (c *AssetCache) providers() []AssetProvider {
+	if c.Providers == nil {
+		return DefaultAssetProviders()
+	}
+	return c.Providers
+}
+
+func (c *AssetCache) objectPath(sum string) string {
+	return filepath.Join(c.Dir, "objects", sum[:2], sum)
+}
+
+func (c *AssetCache) channelPath(channelID string) string {
+	if channelID == "" {
+		channelID = "global"
+	}
+	return filepath.Join(c.Dir, "channels", channelID+".json")
+}
+
+/*
+Reads index.json the first time, the caller holds c.mu
+*/
+func (c *AssetCache) loadIndex() error {
+	if c.index != nil {
+		return nil
+	}
+	c.index = make(map[string]cachedObject)
+	data, err := ioutil.ReadFile(filepath.Join(c.Dir, "index.json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &c.index); err != nil {
+		return fmt.Errorf("could not read the asset index: %v", err)
+	}
+	return nil
+}
+
+/*
+Writes data to path in the cache, creating the directories of path
+*/
+func writeCacheFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// errNotCached is returned for images that aren't in an offline cache
+var errNotCached = errors.New("not in the asset cache")
+
+/*
+Fills the SHA256 and ContentType of a from the cache, the image is downloaded first if it isn't cached
+*/
+func (c *AssetCache) fetch(ctx context.Context, d *Downloader, a *Asset) error {
+	c.mu.Lock()
+	err := c.loadIndex()
+	object, ok := c.index[a.URL]
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if ok && sha256Hex.MatchString(object.SHA256) {
+		if _, err := os.Stat(c.objectPath(object.SHA256)); err == nil {
+			a.SHA256, a.ContentType = object.SHA256, object.ContentType
+			return nil
+		}
+	}
+	if c.Offline {
+		return errNotCached
+	}
+
+	data, err := d.get(ctx, a.URL)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	object = cachedObject{SHA256: hex.EncodeToString(sum[:]), ContentType: http.DetectContentType(data)}
+	if !strings.HasPrefix(object.ContentType, "image/") {
+		return fmt.Errorf("%s is %s, not an image", a.URL, object.ContentType)
+	}
+	// the same image under another url is already stored
+	if _, err := os.Stat(c.objectPath(object.SHA256)); err != nil {
+		if err := writeCacheFile(c.objectPath(object.SHA256), data); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index[a.URL] = object
+	index, err := json.MarshalIndent(c.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeCacheFile(filepath.Join(c.Dir, "index.json"), index); err != nil {
+		return err
+	}
+	a.SHA256, a.ContentType = object.SHA256, object.ContentType
+	return nil
+}
+
+// Read returns the cached image of a.
+func (c *AssetCache) Read(a Asset) ([]byte, error) {
+	if a.SHA256 == "" {
+		return nil, fmt.Errorf("%s %s of %s isn't cached", a.Type, a.ID, a.Provider)
+	}
+	if !sha256Hex.MatchString(a.SHA256) {
+		return nil, fmt.Errorf("%s %s of %s has the invalid SHA-256 %q", a.Type, a.ID, a.Provider, a.SHA256)
+	}
+	return ioutil.ReadFile(c.objectPath(a.SHA256))
+}
+
+/*
+Returns the emotes and badges of the providers for the channel. The lists are saved in the cache,
+offline or if a provider fails its saved list is used
+*/
+func (c *AssetCache) channelAssets(ctx context.Context, d *Downloader, channelID string) (map[string][]Asset, error) {
+	if channelID != "" && !assetID.MatchString(channelID) {
+		return nil, fmt.Errorf("invalid channel id %q", channelID)
+	}
+	saved := make(map[string][]Asset)
+	path := c.channelPath(channelID)
+	if data, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &saved); err != nil {
+			d.printf("Could not read the saved emotes of channel %s: %v\n", channelID, err)
+		}
+	}
+	if c.Offline {
+		return saved, nil
+	}
+
+	lists := make(map[string][]Asset)
+	for _, p := range c.providers() {
+		assets, err := p.Assets(ctx, d, channelID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			d.printf("Could not get the emotes of %s, using the cached ones: %v\n", p.Name(), err)
+			assets = saved[p.Name()]
+		}
+		lists[p.Name()] = assets
+	}
+	data, err := json.MarshalIndent(lists, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCacheFile(path, data); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+// ChatAssets finds the emotes and badges chat uses and caches their images. Assets that
+// can't be downloaded, or aren't cached in offline mode, are left out.
+func (d *Downloader) ChatAssets(ctx context.Context, cache *AssetCache, chat *Chat) (*ChatAssets, error) {
+	lists, err := cache.channelAssets(ctx, d, chat.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+
+	var resolver EmoteResolver
+	named := make(map[string]Asset)
+	badges := make(map[string]Asset)
+	for _, p := range cache.providers() {
+		if r, ok := p.(EmoteResolver); ok && resolver == nil {
+			resolver = r
+		}
+		for _, a := range lists[p.Name()] {
+			switch {
+			case a.Type == AssetBadge && badges[a.ID].URL == "":
+				badges[a.ID] = a
+			case a.Type == AssetEmote && a.Name != "" && named[a.Name].URL == "":
+				named[a.Name] = a
+			}
+		}
+	}
+
+	used := make(map[string]Asset)
+	for _, comment := range chat.Comments {
+		for _, b := range comment.Badges {
+			if a, ok := badges[b.SetID+"/"+b.Version]; ok {
+				used[a.URL] = a
+			}
+		}
+		for _, f := range comment.Fragments {
+			if f.EmoteID != "" {
+				if resolver != nil {
+					link := resolver.EmoteURL(f.EmoteID)
+					used[link] = Asset{Provider: resolver.Name(), Type: AssetEmote, ID: f.EmoteID, URL: link}
+				}
+				continue
+			}
+			for _, word := range strings.Fields(f.Text) {
+				if a, ok := named[word]; ok {
+					used[a.URL] = a
+				}
+			}
+		}
+	}
+
+	assets := &ChatAssets{Emotes: []Asset{}, Badges: []Asset{}}
+	done := 0
+	for _, a := range used {
+		done++
+		d.printf("\rCaching emotes and badges: %d/%d", done, len(used))
+		if err := cache.fetch(ctx, d, &a); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			d.printDebugf("\nCould not cache %s %s of %s: %v\n", a.Type, a.ID, a.Provider, err)
+			continue
+		}
+		if a.Type == AssetBadge {
+			assets.Badges = append(assets.Badges, a)
+		} else {
+			assets.Emotes = append(assets.Emotes, a)
+		}
+	}
+	if len(used) > 0 {
+		d.printf("\rCached %d of %d emotes and badges\n", len(assets.Emotes)+len(assets.Badges), len(used))
+	}
+	sortAssets(assets.Emotes)
+	sortAssets(assets.Badges)
+	return assets, nil
+}
+
+func sortAssets(assets []Asset) {
+	sort.Slice(assets, func(i, j int) bool {
+		if assets[i].Provider != assets[j].Provider {
+			return assets[i].Provider < assets[j].Provider
+		}
+		return assets[i].ID < assets[j].ID
+	})
+}
+
+// Embed returns a copy of assets with the cached images as data URIs in Asset.Data, for exports
+// that work without the cache and the network. Assets that are embedded already keep their Data,
+// the ones that aren't cached keep only their URL.
+func (c *AssetCache) Embed(assets *ChatAssets) *ChatAssets {
+	embed := func(list []Asset) []Asset {
+		embedded := make([]Asset, 0, len(list))
+		for _, a := range list {
+			if a.Data != "" {
+				embedded = append(embedded, a)
+				continue
+			}
+			if data, err := c.Read(a); err == nil {
+				a.Data = "data:" + a.ContentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+			}
+			embedded = append(embedded, a)
+		}
+		return embedded
+	}
+	return &ChatAssets{Emotes: embed(assets.Emotes), Badges: embed(assets.Badges)}
+}
+
+// Images returns the images of assets for the chat overlay, read from Asset.Data if they are
+// embedded or else from c. c may be nil for embedded assets.
+func (c *AssetCache) Images(assets *ChatAssets) ChatImages {
+	images := &assetImages{cache: c, emotes: make(map[string]Asset), named: make(map[string]Asset), badges: make(map[string]Asset)}
+	for _, a := range assets.Emotes {
+		if a.Name != "" {
+			images.named[a.Name] = a
+		} else {
+			images.emotes[a.ID] = a
+		}
+	}
+	for _, a := range assets.Badges {
+		images.badges[a.ID] = a
+	}
+	return images
+}
+
+// assetImages are ChatImages of an AssetCache
+type assetImages struct {
+	cache                 *AssetCache
+	emotes, named, badges map[string]Asset
+}
+
+func (i *assetImages) decode(a Asset, ok bool) image.Image {
+	if !ok {
+		return nil
+	}
+	var data []byte
+	var err error
+	if comma := strings.Index(a.Data, ";base64,"); comma >= 0 {
+		data, err = base64.StdEncoding.DecodeString(a.Data[comma+len(";base64,"):])
+	} else if i.cache != nil {
+		data, err = i.cache.Read(a)
+	} else {
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	// webp and avif images of 7TV can't be decoded and are drawn as text
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	return img
+}
+
+func (i *assetImages) Emote(id string) image.Image {
+	a, ok := i.emotes[id]
+	return i.decode(a, ok)
+}
+
+func (i *assetImages) NamedEmote(name string) image.Image {
+	a, ok := i.named[name]
+	return i.decode(a, ok)
+}
+
+func (i *assetImages) Badge(setID string, version string) image.Image {
+	a, ok := i.badges[setID+"/"+version]
+	return i.decode(a, ok)
+}
@@ -0,0 +1,315 @@
+package concat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testPNG(t *testing.T, c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// assetServer is a stand-in for the APIs and CDNs of the asset providers
+type assetServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	requested map[string]int
+}
+
+/*
+Returns an assetServer for the channel 12826 with the twitch emote 25, the 7TV emote catJAM, the
+BTTV emotes catJAM (hidden by 7TV), FeelsGoodMan and unused, and the FFZ emote ZrehplaR. The
+images of the 7TV catJAM, FeelsGoodMan and ZrehplaR are the same
+*/
+func newAssetServer(t *testing.T) *assetServer {
+	s := &assetServer{requested: make(map[string]int)}
+	red, green, blue := testPNG(t, color.RGBA{R: 255, A: 255}), testPNG(t, color.RGBA{G: 255, A: 255}), testPNG(t, color.RGBA{B: 255, A: 255})
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requested[r.URL.Path]++
+		s.mu.Unlock()
+
+		var body interface{}
+		switch r.URL.Path {
+		case "/twitch/25/default/dark/2.0":
+			w.Write(red)
+			return
+		case "/badges/subscriber/12", "/bttv/emote/b1/2x", "/bttv/emote/b3/2x":
+			w.Write(blue)
+			return
+		case "/bttv/emote/b2/2x", "/ffz/img/9", "/7tv/s1/2x.png":
+			w.Write(green)
+			return
+		case "/bttv/api/cached/users/twitch/12826":
+			body = map[string]interface{}{
+				"channelEmotes": []map[string]string{{"id": "b1", "code": "catJAM"}},
+				"sharedEmotes":  []map[string]string{{"id": "b3", "code": "unused"}},
+			}
+		case "/bttv/api/cached/emotes/global":
+			body = []map[string]string{{"id": "b2", "code": "FeelsGoodMan"}}
+		case "/ffz/api/set/global":
+			body = map[string]interface{}{
+				"default_sets": []int{3},
+				"sets": map[string]interface{}{
+					"3": map[string]interface{}{"emoticons": []map[string]interface{}{
+						{"id": 9, "name": "ZrehplaR", "urls": map[string]string{"1": s.URL + "/ffz/img/9"}},
+					}},
+				},
+			}
+		case "/7tv/api/users/twitch/12826":
+			body = map[string]interface{}{"emote_set": map[string]interface{}{"emotes": []map[string]interface{}{{
+				"id": "s1", "name": "catJAM",
+				"data": map[string]interface{}{"host": map[string]interface{}{
+					"url":   s.URL + "/7tv/s1",
+					"files": []map[string]string{{"name": "1x.png", "format": "PNG"}, {"name": "2x.webp", "format": "WEBP"}, {"name": "2x.png", "format": "PNG"}},
+				}},
+			}}}}
+		case "/7tv/api/emote-sets/global":
+			body = map[string]interface{}{"emotes": []interface{}{}}
+		default:
+			// the channel has no FFZ room
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(body)
+	}))
+	return s
+}
+
+func (s *assetServer) requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requested[path]
+}
+
+func (s *assetServer) providers() []AssetProvider {
+	return []AssetProvider{
+		TwitchAssets{EmoteCDN: s.URL + "/twitch"},
+		SevenTVAssets{APIURL: s.URL + "/7tv/api"},
+		BTTVAssets{APIURL: s.URL + "/bttv/api", CDNURL: s.URL + "/bttv"},
+		FFZAssets{APIURL: s.URL + "/ffz/api"},
+	}
+}
+
+/*
+Returns a stand-in for the ChatBadges query with the subscriber badges of the channel 12826
+*/
+func chatBadgesHandler(t *testing.T, assets *assetServer) func(req gqlRequest) (interface{}, error) {
+	return func(req gqlRequest) (interface{}, error) {
+		if req.OperationName != "ChatBadges" || req.Query != chatBadgesQuery {
+			t.Errorf("unexpected gql request: %+v", req)
+		}
+		data := map[string]interface{}{
+			"badges": []map[string]string{{"setID": "moderator", "version": "1", "imageURL": assets.URL + "/badges/moderator/1"}},
+			"user":   nil,
+		}
+		if req.Variables["channelID"] == "12826" {
+			data["user"] = map[string]interface{}{
+				"broadcastBadges": []map[string]string{{"setID": "subscriber", "version": "12", "imageURL": assets.URL + "/badges/subscriber/12"}},
+			}
+		}
+		return data, nil
+	}
+}
+
+func assetIDs(assets []Asset) string {
+	var ids []string
+	for _, a := range assets {
+		ids = append(ids, a.Provider+":"+a.ID)
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestChatAssets(t *testing.T) {
+	assets := newAssetServer(t)
+	defer assets.Close()
+	gql := newGQLServer(t, chatBadgesHandler(t, assets))
+	defer gql.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	comment := testComment(1, "a", "", "catJAM FeelsGoodMan lol ZrehplaR ")
+	comment.Badges = []ChatBadge{{SetID: "subscriber", Version: "12"}, {SetID: "vip", Version: "1"}}
+	comment.Fragments = append(comment.Fragments, ChatFragment{Text: "Kappa", EmoteID: "25"})
+	chat := &Chat{ChannelID: "12826", Comments: []ChatComment{comment}}
+
+	d := &Downloader{GQLEndpoint: gql.URL}
+	cache := &AssetCache{Dir: dir, Providers: assets.providers()}
+	got, err := d.ChatAssets(context.Background(), cache, chat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := assetIDs(got.Emotes); ids != "7tv:s1 bttv:b2 ffz:9 twitch:25" {
+		t.Errorf("got emotes %s", ids)
+	}
+	if ids := assetIDs(got.Badges); ids != "twitch:subscriber/12" {
+		t.Errorf("got badges %s", ids)
+	}
+	if got.Emotes[0].URL != assets.URL+"/7tv/s1/2x.png" || got.Emotes[0].Name != "catJAM" || got.Emotes[0].ContentType != "image/png" {
+		t.Errorf("unexpected 7TV emote %+v", got.Emotes[0])
+	}
+
+	// the images are stored by their content, catJAM, FeelsGoodMan and ZrehplaR are one file
+	var objects []string
+	filepath.Walk(filepath.Join(dir, "objects"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			objects = append(objects, path)
+		}
+		return err
+	})
+	if len(objects) != 3 {
+		t.Errorf("got %d cached images, want 3: %v", len(objects), objects)
+	}
+	for _, a := range append(got.Emotes, got.Badges...) {
+		if data, err := cache.Read(a); err != nil || len(data) == 0 {
+			t.Errorf("could not read %s %s: %v", a.Provider, a.ID, err)
+		}
+	}
+
+	// a second download only gets the lists again
+	if _, err := d.ChatAssets(context.Background(), &AssetCache{Dir: dir, Providers: assets.providers()}, chat); err != nil {
+		t.Fatal(err)
+	}
+	if n := assets.requests("/twitch/25/default/dark/2.0"); n != 1 {
+		t.Errorf("emote was downloaded %d times", n)
+	}
+	if n := assets.requests("/bttv/emote/b3/2x") + assets.requests("/bttv/emote/b1/2x"); n != 0 {
+		t.Errorf("unused emotes were downloaded %d times", n)
+	}
+
+	// offline the saved lists and images are used
+	assets.Close()
+	gql.Close()
+	offline, err := d.ChatAssets(context.Background(), &AssetCache{Dir: dir, Providers: assets.providers(), Offline: true}, chat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assetIDs(offline.Emotes) != assetIDs(got.Emotes) || assetIDs(offline.Badges) != assetIDs(got.Badges) {
+		t.Errorf("offline got %s and %s", assetIDs(offline.Emotes), assetIDs(offline.Badges))
+	}
+
+	embedded := cache.Embed(offline)
+	for _, a := range append(embedded.Emotes, embedded.Badges...) {
+		if !strings.HasPrefix(a.Data, "data:image/png;base64,") {
+			t.Errorf("%s %s isn't embedded: %.40q", a.Provider, a.ID, a.Data)
+		}
+	}
+	if offline.Emotes[0].Data != "" {
+		t.Error("Embed changed its argument")
+	}
+
+	// the sums and ids of chat files must not leave the cache
+	if _, err := cache.Read(Asset{Type: AssetEmote, ID: "1", SHA256: "../../index.json"}); err == nil {
+		t.Error("read a file outside of the objects")
+	}
+	if _, err := d.ChatAssets(context.Background(), &AssetCache{Dir: dir, Offline: true}, &Chat{ChannelID: "../../x"}); err == nil {
+		t.Error("expected error for an invalid channel id")
+	}
+
+	// embedded images don't need the cache
+	images := (*AssetCache)(nil).Images(embedded)
+	if images.NamedEmote("catJAM") == nil || images.Emote("25") == nil || images.Badge("subscriber", "12") == nil {
+		t.Error("embedded images can't be read")
+	}
+	if images.NamedEmote("lol") != nil || images.Badge("vip", "1") != nil {
+		t.Error("got images for unknown emotes or badges")
+	}
+}
+
+func TestDownloadChatAssets(t *testing.T) {
+	assets := newAssetServer(t)
+	defer assets.Close()
+	server := newVODServer(t, 3)
+	requests := 0
+	comments := videoCommentsHandler(t, &requests)
+	badges := chatBadgesHandler(t, assets)
+	server.gql = func(req gqlRequest) (interface{}, error) {
+		if req.OperationName == "ChatBadges" {
+			return badges(req)
+		}
+		return comments(req)
+	}
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d := newTestDownloader(t, server, dir)
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	opts.Chat = true
+	opts.Assets = &AssetCache{Dir: filepath.Join(dir, "cache"), Providers: assets.providers()}
+	opts.EmbedAssets = true
+
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+	chat, err := ReadChat(filepath.Join(dir, vodString+".chat.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat.Assets == nil || assetIDs(chat.Assets.Emotes) != "twitch:25" || assetIDs(chat.Assets.Badges) != "twitch:subscriber/12" {
+		t.Fatalf("unexpected assets %+v", chat.Assets)
+	}
+	if !strings.HasPrefix(chat.Assets.Emotes[0].Data, "data:image/png;base64,") {
+		t.Error("emote isn't embedded")
+	}
+
+	opts.Assets = nil
+	if err := d.Download(context.Background(), vodString, opts); err == nil {
+		t.Error("expected error for embedding without a cache")
+	}
+}
+
+func TestAssetCacheErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page" {
+			fmt.Fprint(w, "<html>not an image</html>")
+			return
+		}
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d := &Downloader{}
+	cache := &AssetCache{Dir: dir}
+	for _, link := range []string{server.URL + "/page", server.URL + "/down"} {
+		a := Asset{URL: link}
+		if err := cache.fetch(context.Background(), d, &a); err == nil {
+			t.Errorf("expected error for %s", link)
+		}
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("failed downloads left %d files", len(files))
+	}
+
+	a := Asset{URL: server.URL + "/emote"}
+	if err := (&AssetCache{Dir: dir, Offline: true}).fetch(context.Background(), d, &a); err != errNotCached {
+		t.Errorf("got %v offline, want errNotCached", err)
+	}
+}
@@ -0,0 +1,237 @@
+package concat
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abiosoft/semaphore"
+)
+
+// Job is a single vod download of a batch.
+type Job struct {
+	VODID string
+
+	// Start and End of the downloaded part. An End of 0 downloads till the end of the vod.
+	Start time.Duration
+	End   time.Duration
+
+	// Quality and Filename override the options of the batch if not empty.
+	Quality  string
+	Filename string
+}
+
+// JobResult is the outcome of a Job of a batch.
+type JobResult struct {
+	Job Job
+	// Err is nil for successful jobs and ErrAlreadyArchived for skipped jobs.
+	Err error
+}
+
+// jobLine is a job as written in a jobs file, start and end are timestamps like the -start and -end flags.
+type jobLine struct {
+	VODID    string `json:"vod"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Quality  string `json:"quality"`
+	Filename string `json:"filename"`
+}
+
+func (l jobLine) job() (Job, error) {
+	job := Job{VODID: strings.TrimSpace(l.VODID), Quality: strings.TrimSpace(l.Quality), Filename: strings.TrimSpace(l.Filename)}
+	if job.VODID == "" {
+		return job, fmt.Errorf("missing vod id")
+	}
+
+	var err error
+	if start := strings.TrimSpace(l.Start); start != "" {
+		job.Start, err = ParseTimestamp(start)
+		if err != nil {
+			return job, err
+		}
+	}
+	if end := strings.TrimSpace(l.End); end != "" && end != "full" {
+		job.End, err = ParseTimestamp(end)
+		if err != nil {
+			return job, err
+		}
+	}
+	return job, nil
+}
+
+// ReadJobs reads a jobs file. Files ending in .json contain a JSON array or one JSON object per line
+// with the fields vod, start, end, quality and filename. All other files are read as CSV with the
+// columns in that order. Only the vod column is required, a header row starting with "vod" is skipped.
+func ReadJobs(path string) ([]Job, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []jobLine
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		lines, err = readJSONJobs(data)
+	} else {
+		lines, err = readCSVJobs(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	var jobs []Job
+	for i, line := range lines {
+		job, err := line.job()
+		if err != nil {
+			return nil, fmt.Errorf("%s: job %d: %v", path, i+1, err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func readJSONJobs(data []byte) ([]jobLine, error) {
+	var lines []jobLine
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err := json.Unmarshal(data, &lines)
+		return lines, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var line jobLine
+		err := dec.Decode(&line)
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+}
+
+func readCSVJobs(data []byte) ([]jobLine, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []jobLine
+	for i, record := range records {
+		if i == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "vod") {
+			continue
+		}
+		fields := make([]string, 5)
+		copy(fields, record)
+		lines = append(lines, jobLine{VODID: fields[0], Start: fields[1], End: fields[2], Quality: fields[3], Filename: fields[4]})
+	}
+	return lines, nil
+}
+
+/*
+Applies the job to the options of the batch
+*/
+func (job Job) options(opts Options) Options {
+	opts.Start = job.Start
+	opts.End = job.End
+	if job.Quality != "" {
+		opts.Quality = job.Quality
+	}
+	opts.Filename = job.Filename
+	if opts.Filename == "" {
+		opts.Filename = job.VODID
+	}
+	return opts
+}
+
+// RunBatch runs the jobs with opts as the options for every job, opts.MaxConcurrentJobs of them at the
+// same time. The chunk downloads of all jobs share opts.MaxConcurrentDownloads. Prints a summary of all
+// jobs at the end and returns an error if a job failed.
+func (d *Downloader) RunBatch(ctx context.Context, jobs []Job, opts Options) ([]JobResult, error) {
+	if opts.MaxConcurrentDownloads <= 0 {
+		opts.MaxConcurrentDownloads = DefaultOptions().MaxConcurrentDownloads
+	}
+	if opts.MaxConcurrentJobs <= 0 {
+		opts.MaxConcurrentJobs = DefaultOptions().MaxConcurrentJobs
+	}
+
+	files := make(map[string]int)
+	for i, job := range jobs {
+		filename := job.options(opts).Filename
+		if j, ok := files[filename]; ok {
+			return nil, fmt.Errorf("job %d and %d are both saved as %s, set different filenames", j+1, i+1, filename)
+		}
+		files[filename] = i
+	}
+
+	// the jobs only print in debug mode, their progress bars would overlap
+	jobDownloader := *d
+	jobDownloader.sem = semaphore.New(opts.MaxConcurrentDownloads)
+	if !d.Debug {
+		jobDownloader.Output = nil
+	}
+
+	// besides the chunks, every job fetches playlists and runs ffmpeg
+	running := semaphore.New(opts.MaxConcurrentJobs)
+	results := make([]JobResult, len(jobs))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for i, job := range jobs {
+		wg.Add(1)
+		go func(i int, job Job) {
+			defer wg.Done()
+
+			var err error
+			if running.AcquireContext(ctx, 1) {
+				err = jobDownloader.Download(ctx, job.VODID, job.options(opts))
+				running.Release()
+			} else {
+				err = ctx.Err()
+			}
+			results[i] = JobResult{Job: job, Err: err}
+
+			mu.Lock()
+			defer mu.Unlock()
+			d.printf("[%d/%d] %s %s\n", i+1, len(jobs), job.VODID, resultStatus(err))
+		}(i, job)
+	}
+	wg.Wait()
+
+	failed := 0
+	d.print("\nSummary:")
+	for i, result := range results {
+		if result.Err != nil && result.Err != ErrAlreadyArchived {
+			failed++
+		}
+		d.printf("[%d/%d] %s -> %s: %s\n", i+1, len(jobs), result.Job.VODID, result.Job.options(opts).Filename, resultStatus(result.Err))
+	}
+
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d jobs failed", failed, len(jobs))
+	}
+	return results, nil
+}
+
+func resultStatus(err error) string {
+	switch err {
+	case nil:
+		return "done"
+	case ErrAlreadyArchived:
+		return "skipped, already archived"
+	default:
+		return "failed: " + err.Error()
+	}
+}
@@ -0,0 +1,125 @@
+package concat
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "concat_jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	want := []Job{
+		{VODID: "187938112", Start: 10 * time.Minute, End: time.Hour + 20*time.Minute + 30*time.Second, Quality: "720p30", Filename: "first"},
+		{VODID: "187938113"},
+	}
+
+	files := map[string]string{
+		"jobs.csv": "vod,start,end,quality,filename\n# comment\n187938112,0 10 0,1 20 30,720p30,first\n187938113\n",
+		"jobs.json": `[{"vod": "187938112", "start": "0 10 0", "end": "1 20 30", "quality": "720p30", "filename": "first"},
+			{"vod": "187938113", "end": "full"}]`,
+		"lines.json": "{\"vod\": \"187938112\", \"start\": \"0 10 0\", \"end\": \"1 20 30\", \"quality\": \"720p30\", \"filename\": \"first\"}\n{\"vod\": \"187938113\"}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		jobs, err := ReadJobs(path)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(jobs, want) {
+			t.Errorf("%s: got %+v, want %+v", name, jobs, want)
+		}
+	}
+
+	path := filepath.Join(dir, "missing.csv")
+	if err := ioutil.WriteFile(path, []byte("187938112\n,0 0 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadJobs(path); err == nil {
+		t.Errorf("expected error for job without vod id")
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "concat_batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive, err := OpenArchive(filepath.Join(dir, "archive.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	if err := archive.Record(vodArchiveEntry(vodString, opts)); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Downloader{Archive: archive}
+
+	_, err = d.RunBatch(context.Background(), []Job{{VODID: vodString}, {VODID: vodString, Start: time.Minute}}, opts)
+	if err == nil {
+		t.Errorf("expected error for jobs with the same filename")
+	}
+
+	results, err := d.RunBatch(context.Background(), []Job{{VODID: vodString}, {VODID: "not a vod"}}, opts)
+	if err == nil || len(results) != 2 {
+		t.Fatalf("expected one failed job, got %v, %v", results, err)
+	}
+	if results[0].Err != ErrAlreadyArchived || results[1].Err == nil {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+func TestRunBatchConcurrentJobs(t *testing.T) {
+	server := newVODServer(t, 2)
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// ffmpeg notes when it runs while another one is still running
+	d := newTestDownloader(t, server, dir)
+	slow := filepath.Join(dir, "slow-ffmpeg")
+	script := `#!/bin/sh
+if ! mkdir "$0.running" 2>/dev/null; then echo overlap >> "$0.overlap"; fi
+sleep 0.2
+rmdir "$0.running" 2>/dev/null
+exec ` + d.FFmpegCmd + ` "$@"
+`
+	if err := ioutil.WriteFile(slow, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	d.FFmpegCmd = slow
+
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	opts.MaxConcurrentJobs = 1
+	var jobs []Job
+	for i := 0; i < 4; i++ {
+		jobs = append(jobs, Job{VODID: vodString, Filename: fmt.Sprintf("job%d", i)})
+	}
+	if _, err := d.RunBatch(context.Background(), jobs, opts); err != nil {
+		t.Fatal(err)
+	}
+	if runs := ffmpegRuns(t, dir); len(runs) != len(jobs) {
+		t.Errorf("ffmpeg ran %d times, want %d", len(runs), len(jobs))
+	}
+	if _, err := os.Stat(slow + ".overlap"); err == nil {
+		t.Error("jobs ran ffmpeg at the same time")
+	}
+}
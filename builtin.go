@@ -0,0 +1,71 @@
+package concat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ArneVogel/concat/ts"
+)
+
+// BuiltinMuxer is a Muxer that joins the chunks with package ts, without external tools.
+// It writes transport streams and MP4 and can't re-encode, so smart cuts fall back to
+// cuts at keyframes, CombineOptions.Encode, Subtitles and Overlay fail and the audio can't be extracted.
+type BuiltinMuxer struct{}
+
+// Combine writes a transport stream if output ends in .ts and a MP4 if it ends in .mp4 or .m4a.
+func (BuiltinMuxer) Combine(ctx context.Context, inputs []string, output string, opts CombineOptions) error {
+	if len(inputs) == 0 {
+		return errors.New("nothing to combine")
+	}
+	if opts.Encode.enabled() {
+		return errors.New("re-encoding needs ffmpeg")
+	}
+	if opts.Subtitles != "" {
+		return errors.New("muxing subtitles needs ffmpeg")
+	}
+	if opts.Overlay != "" {
+		return errors.New("burning the chat in needs ffmpeg")
+	}
+	// m4a is a MP4 with only audio, like the chunks of the audio only quality
+	format := strings.TrimPrefix(filepath.Ext(output), ".")
+	if format != FormatTS && format != FormatMP4 && format != AudioFormatM4A {
+		return fmt.Errorf("the builtin muxer can't write %s files, only mp4, m4a and ts", format)
+	}
+	c := ts.Cut{Start: opts.Start, Duration: opts.Duration}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if format == FormatTS {
+		err = ts.Concat(f, inputs, c)
+	} else {
+		err = ts.RemuxMP4(f, inputs, c)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ExtractAudio always fails, converting the audio needs ffmpeg.
+func (BuiltinMuxer) ExtractAudio(ctx context.Context, input string, output string, opts AudioOptions) error {
+	return errors.New("extracting the audio needs ffmpeg")
+}
+
+// Probe reads the duration and the streams of a transport stream or MP4 written by Combine.
+func (BuiltinMuxer) Probe(ctx context.Context, path string) (*MediaInfo, error) {
+	info, err := ts.Probe(path)
+	if err != nil {
+		return nil, err
+	}
+	result := &MediaInfo{Duration: info.Duration}
+	for _, s := range info.Streams {
+		result.Streams = append(result.Streams, StreamInfo{Type: s.Type, Codec: s.Codec, Width: s.Width, Height: s.Height})
+	}
+	return result, nil
+}
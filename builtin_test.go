@@ -0,0 +1,85 @@
+package concat
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+Returns a chunk with a PAT, a PMT with a H.264 stream on pid 256 and a keyframe PES that starts at
+number*10 seconds. The continuity counters start at 0 in every chunk
+*/
+func tsChunk(number int) []byte {
+	packet := func(header ...byte) []byte {
+		p := make([]byte, tsPacketSize)
+		for i := range p {
+			p[i] = 0xff
+		}
+		copy(p, header)
+		return p
+	}
+	pat := packet(tsSyncByte, 0x40, 0x00, 0x10, 0,
+		0x00, 0xb0, 13, 0, 1, 0xc1, 0, 0, 0, 1, 0xf0, 0x00, 0, 0, 0, 0)
+	pmt := packet(tsSyncByte, 0x50, 0x00, 0x10, 0,
+		0x02, 0xb0, 18, 0, 1, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0, 0x1b, 0xe1, 0x00, 0xf0, 0, 0, 0, 0, 0)
+
+	pts := uint64(number)*10*90000 + 90000
+	video := packet(tsSyncByte, 0x41, 0x00, 0x30, 1, 0x40,
+		0, 0, 1, 0xe0, 0, 0, 0x80, 0x80, 5,
+		byte(0x21|pts>>29&0x0e), byte(pts>>22), byte(pts>>14|1), byte(pts>>7), byte(pts<<1|1),
+		0, 0, 0, 1, 0x65, 0x88)
+	return append(append(pat, pmt...), video...)
+}
+
+func TestDownloadBuiltin(t *testing.T) {
+	server := newVODServer(t, 3)
+	server.chunk = tsChunk
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "concat_builtin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := newTestDownloader(t, server, dir)
+	d.Muxer = BuiltinMuxer{}
+	d.FFmpegCmd = filepath.Join(dir, "missing-ffmpeg")
+
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	opts.Filename = "builtin"
+	opts.Format = FormatTS
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "builtin.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 9*tsPacketSize {
+		t.Fatalf("got %d bytes, want the 9 packets of the 3 chunks", len(data))
+	}
+	for i := 0; i < 3; i++ {
+		video := data[(3*i+2)*tsPacketSize:]
+		if cc := video[3] & 0x0f; cc != byte(i) {
+			t.Errorf("video packet of chunk %d has continuity counter %d", i, cc)
+		}
+	}
+}
+
+func TestAudioSavePath(t *testing.T) {
+	for path, want := range map[string]string{"vod.mp4": "vod.mp3", "dir/vod.ts": "dir/vod.mp3"} {
+		if got := (Options{}).audioSavePath(path); got != want {
+			t.Errorf("audioSavePath(%q) = %q, want %q", path, got, want)
+		}
+	}
+	opts := Options{AudioFormat: AudioFormatM4A}
+	if got := opts.audioSavePath("dir/vod.mkv"); got != "dir/vod.m4a" {
+		t.Errorf("audioSavePath with m4a = %q", got)
+	}
+}
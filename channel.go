@@ -0,0 +1,220 @@
+package concat
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const channelVideosHash string = "a937f1d22e269e39a03b509f65a7490f9fc247d7f83d6ac1421523e3b68042cb"
+const channelVideosPageSize int = 100
+
+// Types of the videos of a channel.
+const (
+	VideoTypeArchive   string = "ARCHIVE"
+	VideoTypeHighlight string = "HIGHLIGHT"
+	VideoTypeUpload    string = "UPLOAD"
+)
+
+// Video is a vod of a channel.
+type Video struct {
+	ID          string
+	Title       string
+	Type        string
+	Game        string
+	PublishedAt time.Time
+	Length      time.Duration
+}
+
+// VideoFilter selects videos of a channel. The zero value selects all videos.
+type VideoFilter struct {
+	// Types of the videos, see VideoTypeArchive, VideoTypeHighlight and VideoTypeUpload.
+	// Empty selects all types.
+	Types []string
+
+	// Game the video was categorized as, compared case insensitive. Empty selects all games.
+	Game string
+
+	// After and Before limit the publishing date of the videos. A zero time doesn't limit the date.
+	After  time.Time
+	Before time.Time
+}
+
+func (f VideoFilter) matches(v Video) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			found = found || strings.EqualFold(t, v.Type)
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Game != "" && !strings.EqualFold(f.Game, v.Game) {
+		return false
+	}
+	if !f.After.IsZero() && v.PublishedAt.Before(f.After) {
+		return false
+	}
+	if !f.Before.IsZero() && !v.PublishedAt.Before(f.Before) {
+		return false
+	}
+	return true
+}
+
+type channelVideosPage struct {
+	User *struct {
+		Videos struct {
+			Edges []struct {
+				Cursor string `json:"cursor"`
+				Node   struct {
+					ID            string    `json:"id"`
+					Title         string    `json:"title"`
+					PublishedAt   time.Time `json:"publishedAt"`
+					BroadcastType string    `json:"broadcastType"`
+					LengthSeconds int       `json:"lengthSeconds"`
+					Game          *struct {
+						Name string `json:"name"`
+					} `json:"game"`
+				} `json:"node"`
+			} `json:"edges"`
+			PageInfo struct {
+				HasNextPage bool `json:"hasNextPage"`
+			} `json:"pageInfo"`
+		} `json:"videos"`
+	} `json:"user"`
+}
+
+// ChannelVideos lists the videos of channel that match filter, newest first.
+func (d *Downloader) ChannelVideos(ctx context.Context, channel string, filter VideoFilter) ([]Video, error) {
+	var broadcastType interface{}
+	if len(filter.Types) == 1 {
+		broadcastType = strings.ToUpper(filter.Types[0])
+	}
+
+	var videos []Video
+	var cursor interface{}
+	for {
+		var page channelVideosPage
+		err := d.gql(ctx, persistedQuery("FilterableVideoTower_Videos", channelVideosHash, map[string]interface{}{
+			"broadcastType":     broadcastType,
+			"channelOwnerLogin": strings.ToLower(channel),
+			"limit":             channelVideosPageSize,
+			"videoSort":         "TIME",
+			"cursor":            cursor,
+		}), &page)
+		if err != nil {
+			return nil, err
+		}
+		if page.User == nil {
+			return nil, fmt.Errorf("channel %s not found", channel)
+		}
+
+		edges := page.User.Videos.Edges
+		for _, edge := range edges {
+			v := Video{
+				ID:          edge.Node.ID,
+				Title:       edge.Node.Title,
+				Type:        edge.Node.BroadcastType,
+				PublishedAt: edge.Node.PublishedAt,
+				Length:      time.Duration(edge.Node.LengthSeconds) * time.Second,
+			}
+			if edge.Node.Game != nil {
+				v.Game = edge.Node.Game.Name
+			}
+
+			// the videos are sorted by time, everything after this is older
+			if !filter.After.IsZero() && v.PublishedAt.Before(filter.After) {
+				return videos, nil
+			}
+
+			if filter.matches(v) {
+				videos = append(videos, v)
+			}
+		}
+
+		if !page.User.Videos.PageInfo.HasNextPage || len(edges) == 0 {
+			return videos, nil
+		}
+		cursor = edges[len(edges)-1].Cursor
+	}
+}
+
+// ArchiveChannel downloads every video of channel that matches filter. Each video is saved under its id,
+// videos that are already saved in opts.DownloadPath or recorded in the Downloader.Archive are skipped.
+// opts.Start, opts.End and opts.Filename are ignored. A failed download doesn't stop the archiving of
+// the other videos.
+func (d *Downloader) ArchiveChannel(ctx context.Context, channel string, filter VideoFilter, opts Options) error {
+	d.printf("Listing videos of %s\n", channel)
+
+	videos, err := d.ChannelVideos(ctx, channel, filter)
+	if err != nil {
+		return fmt.Errorf("could not list videos: %v", err)
+	}
+
+	return d.archiveVideos(ctx, videos, opts)
+}
+
+/*
+Reports whether the video of opts is already saved, audio only downloads are saved as the audio file.
+A file next to the manifest of an interrupted run may be cut off, Download resumes that run
+*/
+func (o Options) archived() bool {
+	if _, err := os.Stat(filepath.Join(o.DownloadPath, "_"+o.Filename, manifestFileName)); err == nil {
+		return false
+	}
+	path := o.savePath()
+	if _, err := os.Stat(path); err == nil {
+		return true
+	}
+	if o.AudioOnly {
+		if _, err := os.Stat(o.audioSavePath(path)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Downloads videos one after another under their ids, skipping the ones that are already saved in
+opts.DownloadPath or recorded in the archive
+*/
+func (d *Downloader) archiveVideos(ctx context.Context, videos []Video, opts Options) error {
+	d.printf("Found %d videos\n", len(videos))
+
+	opts.Start = 0
+	opts.End = 0
+
+	var failed []string
+	for i, v := range videos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		opts.Filename = v.ID
+
+		d.printf("\n[%d/%d] %s %s (%s, %s)\n", i+1, len(videos), v.ID, v.Title, strings.ToLower(v.Type), v.PublishedAt.Format("2006-01-02"))
+
+		if opts.archived() {
+			d.print("Already archived, skipping")
+			continue
+		}
+
+		err := d.Download(ctx, v.ID, opts)
+		if err == ErrAlreadyArchived {
+			continue
+		}
+		if err != nil {
+			d.printf("Could not download %s: %v\n", v.ID, err)
+			failed = append(failed, v.ID)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("could not download %d of %d videos: %s", len(failed), len(videos), strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package concat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func channelVideosHandler(t *testing.T, pages int) func(req gqlRequest) (interface{}, error) {
+	return func(req gqlRequest) (interface{}, error) {
+		if req.OperationName != "FilterableVideoTower_Videos" || req.Variables["channelOwnerLogin"] != "reckful" {
+			t.Errorf("unexpected gql request: %+v", req)
+		}
+		page := 0
+		if cursor, ok := req.Variables["cursor"].(string); ok {
+			fmt.Sscanf(cursor, "page%d", &page)
+		}
+
+		// two videos per page, one day apart, newest first
+		var edges []map[string]interface{}
+		for i := 0; i < 2; i++ {
+			n := page*2 + i
+			game := map[string]string{"name": "Hearthstone"}
+			if n%2 == 1 {
+				game = map[string]string{"name": "World of Warcraft"}
+			}
+			broadcastType := VideoTypeArchive
+			if n == 2 {
+				broadcastType = VideoTypeHighlight
+			}
+			edges = append(edges, map[string]interface{}{
+				"cursor": fmt.Sprintf("page%d", page+1),
+				"node": map[string]interface{}{
+					"id":            fmt.Sprint(1000 - n),
+					"title":         fmt.Sprintf("stream %d", n),
+					"publishedAt":   time.Date(2020, 1, 10-n, 12, 0, 0, 0, time.UTC).Format(time.RFC3339),
+					"broadcastType": broadcastType,
+					"lengthSeconds": 3600,
+					"game":          game,
+				},
+			})
+		}
+		return map[string]interface{}{
+			"user": map[string]interface{}{
+				"videos": map[string]interface{}{
+					"edges":    edges,
+					"pageInfo": map[string]bool{"hasNextPage": page+1 < pages},
+				},
+			},
+		}, nil
+	}
+}
+
+func TestChannelVideos(t *testing.T) {
+	server := newGQLServer(t, channelVideosHandler(t, 3))
+	defer server.Close()
+
+	d := &Downloader{GQLEndpoint: server.URL}
+
+	videos, err := d.ChannelVideos(context.Background(), "Reckful", VideoFilter{})
+	if err != nil || len(videos) != 6 {
+		t.Fatalf("ChannelVideos: got %d videos, %v", len(videos), err)
+	}
+	if videos[0].ID != "1000" || videos[0].Length != time.Hour || videos[0].Game != "Hearthstone" || videos[5].ID != "995" {
+		t.Errorf("ChannelVideos: unexpected videos %+v", videos)
+	}
+
+	filter := VideoFilter{
+		Types:  []string{"archive"},
+		Game:   "hearthstone",
+		After:  time.Date(2020, 1, 6, 0, 0, 0, 0, time.UTC),
+		Before: time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC),
+	}
+	videos, err = d.ChannelVideos(context.Background(), "reckful", filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, v := range videos {
+		ids = append(ids, v.ID)
+	}
+	// 1000 is published on the 10th, 998 is a highlight and 995 is older than the 6th
+	if fmt.Sprint(ids) != "[996]" {
+		t.Errorf("ChannelVideos with filter: got %v", ids)
+	}
+}
+
+func TestArchiveVideos(t *testing.T) {
+	server := newVODServer(t, 3)
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// the audio of the vod is already saved, the other videos don't exist
+	if err := ioutil.WriteFile(filepath.Join(dir, vodString+".m4a"), []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	d := newTestDownloader(t, server, dir)
+	d.Output = &out
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	opts.AudioOnly = true
+	opts.AudioFormat = AudioFormatM4A
+	videos := []Video{{ID: "1"}, {ID: vodString}, {ID: "2"}}
+
+	err := d.archiveVideos(context.Background(), videos, opts)
+	if err == nil || !strings.Contains(err.Error(), "2 of 3 videos: 1, 2") {
+		t.Errorf("expected error listing the failed videos, got %v", err)
+	}
+	if server.requests("/vod/"+vodString) != 0 || strings.Count(out.String(), "Already archived") != 1 {
+		t.Errorf("downloaded the archived audio again:\n%s", out.String())
+	}
+
+	// a run killed while combining left a cut off video and its manifest, the video is downloaded again
+	opts.AudioOnly = false
+	savePath := filepath.Join(dir, vodString+".mp4")
+	if err := ioutil.WriteFile(savePath, []byte("cut off"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	newpath := filepath.Join(dir, "_"+vodString)
+	if err := os.Mkdir(newpath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := newManifest(newpath, vodString, opts).save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.archiveVideos(context.Background(), []Video{{ID: vodString}}, opts); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(savePath)
+	if err != nil || !bytes.Equal(got, append(append(fakeChunk(0), fakeChunk(1)...), fakeChunk(2)...)) {
+		t.Errorf("the cut off video isn't replaced, got %q, %v", got, err)
+	}
+	if _, err := os.Stat(newpath); !os.IsNotExist(err) {
+		t.Errorf("temp directory of the interrupted run is left: %v", err)
+	}
+}
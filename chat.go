@@ -0,0 +1,273 @@
+package concat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const videoCommentsHash string = "b70a3591ff0f4e0313d126c6a1502d79a1c02baebb288227c582044aa76adf6a"
+
+// Chat is the chat replay of a downloaded vod, saved as <Filename>.chat.json next to the video.
+type Chat struct {
+	VODID string `json:"vod_id"`
+
+	// Start and End of the downloaded part of the vod in seconds. End is 0 if the vod was
+	// downloaded till the end.
+	Start float64 `json:"start"`
+	End   float64 `json:"end,omitempty"`
+
+	// ChannelID is the twitch user id of the streamer.
+	ChannelID string `json:"channel_id,omitempty"`
+
+	Comments []ChatComment `json:"comments"`
+
+	// Assets are the emotes and badges of the comments if Options.Assets was set.
+	Assets *ChatAssets `json:"assets,omitempty"`
+}
+
+// ChatComment is a chat message of a vod.
+type ChatComment struct {
+	ID string `json:"id"`
+
+	// Offset is the time of the comment in seconds since the start of the downloaded part,
+	// VODOffset since the start of the vod.
+	Offset    float64   `json:"offset"`
+	VODOffset float64   `json:"vod_offset"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Commenter ChatUser `json:"commenter"`
+	// Color of the name of the commenter like #FF4500, empty if the commenter never chose one.
+	Color  string      `json:"color,omitempty"`
+	Badges []ChatBadge `json:"badges,omitempty"`
+
+	// Fragments of the message, text and emotes in order.
+	Fragments []ChatFragment `json:"fragments"`
+}
+
+// ChatUser is the author of a ChatComment.
+type ChatUser struct {
+	ID          string `json:"id"`
+	Login       string `json:"login"`
+	DisplayName string `json:"display_name"`
+}
+
+// ChatBadge is a badge next to the name of a commenter, like subscriber/12.
+type ChatBadge struct {
+	SetID   string `json:"set_id"`
+	Version string `json:"version"`
+}
+
+// ChatFragment is a part of a message. Emotes have an EmoteID and their name as Text.
+type ChatFragment struct {
+	Text    string `json:"text"`
+	EmoteID string `json:"emote_id,omitempty"`
+}
+
+// Text returns the message of c as it was typed.
+func (c ChatComment) Text() string {
+	var text strings.Builder
+	for _, f := range c.Fragments {
+		text.WriteString(f.Text)
+	}
+	return text.String()
+}
+
+// Name returns the display name of the commenter, or the login if there is none.
+func (c ChatComment) Name() string {
+	if c.Commenter.DisplayName != "" {
+		return c.Commenter.DisplayName
+	}
+	return c.Commenter.Login
+}
+
+type videoCommentsPage struct {
+	Video *struct {
+		Creator *struct {
+			ID string `json:"id"`
+		} `json:"creator"`
+		Comments *struct {
+			Edges []struct {
+				Cursor string `json:"cursor"`
+				Node   struct {
+					ID                   string    `json:"id"`
+					ContentOffsetSeconds float64   `json:"contentOffsetSeconds"`
+					CreatedAt            time.Time `json:"createdAt"`
+					Commenter            *struct {
+						ID          string `json:"id"`
+						Login       string `json:"login"`
+						DisplayName string `json:"displayName"`
+					} `json:"commenter"`
+					Message struct {
+						Fragments []struct {
+							Text  string `json:"text"`
+							Emote *struct {
+								EmoteID string `json:"emoteID"`
+							} `json:"emote"`
+						} `json:"fragments"`
+						UserBadges []struct {
+							SetID   string `json:"setID"`
+							Version string `json:"version"`
+						} `json:"userBadges"`
+						UserColor string `json:"userColor"`
+					} `json:"message"`
+				} `json:"node"`
+			} `json:"edges"`
+			PageInfo struct {
+				HasNextPage bool `json:"hasNextPage"`
+			} `json:"pageInfo"`
+		} `json:"comments"`
+	} `json:"video"`
+}
+
+// ChatComments returns the comments of the vod between start and end, oldest first. An end of 0
+// returns everything after start. The offsets of the comments are relative to start.
+func (d *Downloader) ChatComments(ctx context.Context, vodID string, start time.Duration, end time.Duration) ([]ChatComment, error) {
+	comments, _, err := d.chatComments(ctx, vodID, start, end)
+	return comments, err
+}
+
+/*
+ChatComments that also returns the id of the channel of the vod
+*/
+func (d *Downloader) chatComments(ctx context.Context, vodID string, start time.Duration, end time.Duration) ([]ChatComment, string, error) {
+	startSeconds, endSeconds := start.Seconds(), end.Seconds()
+
+	var comments []ChatComment
+	channelID := ""
+	seen := make(map[string]bool)
+	// the first page starts at the offset, the following ones at the cursor of the last comment
+	variables := map[string]interface{}{"videoID": vodID, "contentOffsetSeconds": int(math.Floor(startSeconds))}
+	for {
+		var page videoCommentsPage
+		if err := d.gql(ctx, persistedQuery("VideoCommentsByOffsetOrCursor", videoCommentsHash, variables), &page); err != nil {
+			return nil, "", err
+		}
+		if page.Video == nil {
+			return nil, "", fmt.Errorf("vod %s not found", vodID)
+		}
+		if page.Video.Creator != nil {
+			channelID = page.Video.Creator.ID
+		}
+		if page.Video.Comments == nil {
+			return comments, channelID, nil
+		}
+
+		edges := page.Video.Comments.Edges
+		for _, edge := range edges {
+			node := edge.Node
+			if endSeconds > 0 && node.ContentOffsetSeconds >= endSeconds {
+				return comments, channelID, nil
+			}
+			if node.ContentOffsetSeconds < startSeconds || seen[node.ID] {
+				continue
+			}
+			seen[node.ID] = true
+
+			c := ChatComment{
+				ID:        node.ID,
+				Offset:    node.ContentOffsetSeconds - startSeconds,
+				VODOffset: node.ContentOffsetSeconds,
+				CreatedAt: node.CreatedAt,
+				Color:     node.Message.UserColor,
+			}
+			// deleted accounts have no commenter
+			if node.Commenter != nil {
+				c.Commenter = ChatUser{ID: node.Commenter.ID, Login: node.Commenter.Login, DisplayName: node.Commenter.DisplayName}
+			}
+			for _, b := range node.Message.UserBadges {
+				c.Badges = append(c.Badges, ChatBadge{SetID: b.SetID, Version: b.Version})
+			}
+			for _, f := range node.Message.Fragments {
+				fragment := ChatFragment{Text: f.Text}
+				if f.Emote != nil {
+					fragment.EmoteID = f.Emote.EmoteID
+				}
+				c.Fragments = append(c.Fragments, fragment)
+			}
+			comments = append(comments, c)
+		}
+
+		if !page.Video.Comments.PageInfo.HasNextPage || len(edges) == 0 {
+			return comments, channelID, nil
+		}
+		variables = map[string]interface{}{"videoID": vodID, "cursor": edges[len(edges)-1].Cursor}
+		d.printf("\rDownloading chat: %d messages", len(comments))
+	}
+}
+
+/*
+Returns the path of the chat replay next to the video at vodSavePath
+*/
+func chatSavePath(vodSavePath string) string {
+	return strings.TrimSuffix(vodSavePath, filepath.Ext(vodSavePath)) + ".chat.json"
+}
+
+/*
+Downloads the chat of the part of the vod in opts next to vodSavePath and returns its path. The
+chat is rendered as subtitles and html if opts asks for it. The chunks of m give the end of
+downloads that end relative to the end of the vod
+*/
+func (d *Downloader) saveChat(ctx context.Context, vodID string, vodSavePath string, opts Options, m *manifest) (string, error) {
+	end := opts.End
+	if end < 0 && len(m.Chunks) > 0 {
+		last := m.Chunks[len(m.Chunks)-1]
+		end = secondsToDuration(last.Offset + last.Duration)
+	}
+
+	d.print("Downloading chat")
+	comments, channelID, err := d.chatComments(ctx, vodID, opts.Start, end)
+	if err != nil {
+		return "", fmt.Errorf("could not download chat: %v", err)
+	}
+	d.printf("\rDownloaded %d chat messages\n", len(comments))
+
+	chat := Chat{VODID: vodID, Start: opts.Start.Seconds(), End: end.Seconds(), ChannelID: channelID, Comments: comments}
+	if chat.Comments == nil {
+		chat.Comments = []ChatComment{}
+	}
+	if opts.Assets != nil {
+		assets, err := d.ChatAssets(ctx, opts.Assets, &chat)
+		if err != nil {
+			return "", fmt.Errorf("could not cache emotes and badges: %v", err)
+		}
+		if opts.EmbedAssets {
+			assets = opts.Assets.Embed(assets)
+		}
+		chat.Assets = assets
+	}
+	data, err := json.MarshalIndent(chat, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := chatSavePath(vodSavePath)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+
+	if opts.Subtitles != "" {
+		subtitlePath, err := writeSubtitles(&chat, vodSavePath, opts)
+		if err != nil {
+			return "", fmt.Errorf("could not write chat subtitles: %v", err)
+		}
+		d.printf("Wrote the chat subtitles to %s\n", subtitlePath)
+	}
+	if opts.ChatHTML {
+		// the page is next to the video and links it by its name
+		video := filepath.Base(vodSavePath)
+		if opts.AudioOnly {
+			video = filepath.Base(opts.audioSavePath(vodSavePath))
+		}
+		htmlPath := htmlSavePath(vodSavePath)
+		if err := writeHTMLFile(htmlPath, &chat, HTMLOptions{Video: video, Assets: opts.Assets}); err != nil {
+			return "", fmt.Errorf("could not write the html chat replay: %v", err)
+		}
+		d.printf("Wrote the html chat replay to %s\n", htmlPath)
+	}
+	return path, nil
+}
@@ -0,0 +1,147 @@
+package concat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*
+Returns a stand-in for the VideoCommentsByOffsetOrCursor query of a vod with a comment every 5
+seconds up to 100 seconds, in pages of 4 comments. The cursor of a comment is its index
+*/
+func videoCommentsHandler(t *testing.T, requests *int) func(req gqlRequest) (interface{}, error) {
+	return func(req gqlRequest) (interface{}, error) {
+		*requests++
+		if req.OperationName != "VideoCommentsByOffsetOrCursor" || req.Extensions == nil || req.Extensions.PersistedQuery.SHA256Hash != videoCommentsHash {
+			t.Errorf("unexpected gql request: %+v", req)
+		}
+		if req.Variables["videoID"] != vodString {
+			return map[string]interface{}{"video": nil}, nil
+		}
+
+		first := 0
+		if cursor, ok := req.Variables["cursor"].(string); ok {
+			fmt.Sscanf(cursor, "%d", &first)
+			first++
+		} else if offset, ok := req.Variables["contentOffsetSeconds"].(float64); ok {
+			// twitch starts the page a bit before the offset
+			first = int(offset)/5 - 1
+			if first < 0 {
+				first = 0
+			}
+		} else {
+			t.Errorf("request without offset or cursor: %+v", req.Variables)
+		}
+
+		var edges []map[string]interface{}
+		for i := first; i < first+4 && i <= 20; i++ {
+			edges = append(edges, map[string]interface{}{
+				"cursor": fmt.Sprint(i),
+				"node": map[string]interface{}{
+					"id":                   fmt.Sprintf("comment%d", i),
+					"contentOffsetSeconds": i * 5,
+					"createdAt":            time.Date(2020, 6, 1, 10, 0, i*5, 0, time.UTC).Format(time.RFC3339),
+					"commenter":            map[string]string{"id": "1", "login": "viewer", "displayName": "Viewer"},
+					"message": map[string]interface{}{
+						"fragments": []map[string]interface{}{
+							{"text": fmt.Sprintf("message %d ", i), "emote": nil},
+							{"text": "Kappa", "emote": map[string]string{"emoteID": "25"}},
+						},
+						"userBadges": []map[string]string{{"setID": "subscriber", "version": "12"}},
+						"userColor":  "#FF4500",
+					},
+				},
+			})
+		}
+		return map[string]interface{}{
+			"video": map[string]interface{}{
+				"creator": map[string]string{"id": "12826"},
+				"comments": map[string]interface{}{
+					"edges":    edges,
+					"pageInfo": map[string]bool{"hasNextPage": first+4 <= 20},
+				},
+			},
+		}, nil
+	}
+}
+
+func TestChatComments(t *testing.T) {
+	requests := 0
+	server := newGQLServer(t, videoCommentsHandler(t, &requests))
+	defer server.Close()
+
+	d := &Downloader{GQLEndpoint: server.URL}
+	comments, err := d.ChatComments(context.Background(), vodString, 22*time.Second, 52*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the comments at 25 to 50 seconds
+	if len(comments) != 6 {
+		t.Fatalf("got %d comments, want 6: %+v", len(comments), comments)
+	}
+	c := comments[0]
+	if c.ID != "comment5" || c.Offset != 3 || c.VODOffset != 25 || c.Name() != "Viewer" || c.Color != "#FF4500" {
+		t.Errorf("unexpected first comment %+v", c)
+	}
+	if c.Text() != "message 5 Kappa" || c.Fragments[1].EmoteID != "25" || len(c.Badges) != 1 || c.Badges[0].Version != "12" {
+		t.Errorf("unexpected message of the first comment %+v", c)
+	}
+	if comments[5].ID != "comment10" {
+		t.Errorf("last comment is %s, want comment10", comments[5].ID)
+	}
+	// paging stops at the first comment after the end, the third page starts with it
+	if requests != 3 {
+		t.Errorf("got %d requests, want 3", requests)
+	}
+
+	comments, err = d.ChatComments(context.Background(), vodString, 0, 0)
+	if err != nil || len(comments) != 21 {
+		t.Errorf("got %d comments of the whole vod, %v", len(comments), err)
+	}
+
+	if _, err := d.ChatComments(context.Background(), "1", 0, 0); err == nil {
+		t.Error("expected error for a missing vod")
+	}
+}
+
+func TestDownloadChat(t *testing.T) {
+	server := newVODServer(t, 6)
+	requests := 0
+	server.gql = videoCommentsHandler(t, &requests)
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d := newTestDownloader(t, server, dir)
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	opts.Start = 40 * time.Second
+	opts.End = -10 * time.Second
+	opts.Chat = true
+
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, vodString+".chat.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chat Chat
+	if err := json.Unmarshal(data, &chat); err != nil {
+		t.Fatal(err)
+	}
+	// the vod is 60 seconds long, the download ends at 50 seconds
+	if chat.VODID != vodString || chat.ChannelID != "12826" || chat.Start != 40 || chat.End != 50 || len(chat.Comments) != 2 {
+		t.Fatalf("unexpected chat %+v", chat)
+	}
+	if c := chat.Comments[1]; c.Offset != 5 || c.VODOffset != 45 {
+		t.Errorf("second comment is at %v, %v, want 5 and 45", c.Offset, c.VODOffset)
+	}
+}
@@ -0,0 +1,305 @@
+package concat
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// HTMLOptions are the options of the HTML chat replay of WriteHTML.
+type HTMLOptions struct {
+	// Title of the page, "Chat of vod <id>" if empty.
+	Title string
+
+	// Video is the link of the video the chat is played along with, relative to the page. The
+	// page has no video if empty.
+	Video string
+
+	// Assets embeds the cached images of emotes and badges that aren't embedded in the chat yet,
+	// so the page works offline. Without it those are linked.
+	Assets *AssetCache
+}
+
+// htmlPage is the data of chatHTMLTemplate
+type htmlPage struct {
+	Title    string
+	Video    string
+	Comments []htmlComment
+	// Images are the data URIs or links of the emotes and badges, the comments refer to them by index
+	Images []string
+}
+
+type htmlComment struct {
+	ID      string
+	Offset  float64
+	Time    string
+	Created string
+	Name    string
+	Color   string
+	Badges  []htmlImage
+	Parts   []htmlPart
+}
+
+type htmlImage struct {
+	Image int
+	Title string
+}
+
+// htmlPart is text, or an emote if Emote is set
+type htmlPart struct {
+	Text  string
+	Emote bool
+	Image int
+}
+
+var chatHTMLTemplate = template.Must(template.New("chat").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { margin: 0; font: 14px/1.5 sans-serif; background: #18181b; color: #efeff1; display: flex; height: 100vh; }
+#player { flex: 2; display: flex; align-items: center; background: #000; }
+#player video { width: 100%; max-height: 100vh; }
+#side { flex: 1; min-width: 320px; display: flex; flex-direction: column; }
+#bar { padding: 8px; border-bottom: 1px solid #3a3a3d; display: flex; gap: 8px; align-items: center; flex-wrap: wrap; }
+#search { flex: 1; min-width: 120px; padding: 4px 8px; background: #0e0e10; color: inherit; border: 1px solid #3a3a3d; border-radius: 4px; }
+#chat { flex: 1; overflow-y: auto; padding: 4px 8px; }
+.msg { padding: 2px 4px; border-radius: 4px; word-wrap: break-word; }
+.msg:target { background: #45330a; }
+.msg.future { opacity: 0.35; }
+.ts { color: #adadb8; font-size: 12px; text-decoration: none; margin-right: 4px; }
+.name { font-weight: bold; }
+img.badge, img.emote { height: 1.5em; vertical-align: middle; }
+img.badge { margin-right: 2px; }
+</style>
+</head>
+<body>
+{{if .Video}}<div id="player"><video id="video" src="{{.Video}}" controls preload="metadata"></video></div>
+{{end}}<div id="side">
+<div id="bar">
+<input id="search" type="search" placeholder="Search names and messages" autocomplete="off">
+<span id="count">{{len .Comments}} messages</span>
+{{if .Video}}<label><input id="follow" type="checkbox" checked> Follow video</label>
+{{end}}</div>
+<div id="chat">
+{{range .Comments}}<div class="msg" id="c-{{.ID}}" data-t="{{printf "%.3f" .Offset}}"><a class="ts" href="#c-{{.ID}}" title="{{.Created}}">{{.Time}}</a>{{range .Badges}}<img class="badge" data-i="{{.Image}}" alt="" title="{{.Title}}">{{end}}<span class="name" style="color: {{.Color}}">{{.Name}}</span>: {{range .Parts}}{{if .Emote}}<img class="emote" data-i="{{.Image}}" alt="{{.Text}}" title="{{.Text}}">{{else}}{{.Text}}{{end}}{{end}}</div>
+{{end}}</div>
+</div>
+<script>
+const images = {{.Images}};
+const messages = Array.from(document.querySelectorAll(".msg"));
+for (const img of document.querySelectorAll("img[data-i]")) {
+	img.src = images[img.dataset.i];
+}
+for (const m of messages) {
+	m.search = (m.textContent + " " + Array.from(m.querySelectorAll("img.emote"), i => i.alt).join(" ")).toLowerCase();
+}
+
+const count = document.getElementById("count");
+document.getElementById("search").addEventListener("input", e => {
+	const query = e.target.value.trim().toLowerCase();
+	let shown = 0;
+	for (const m of messages) {
+		m.hidden = query !== "" && !m.search.includes(query);
+		if (!m.hidden) shown++;
+	}
+	count.textContent = query === "" ? messages.length + " messages" : shown + " of " + messages.length + " messages";
+});
+
+const video = document.getElementById("video");
+if (video) {
+	const follow = document.getElementById("follow");
+	// messages before played are shown, the ones after it are dimmed
+	let played = messages.length;
+	const sync = () => {
+		const t = video.currentTime;
+		let lo = 0, hi = messages.length;
+		while (lo < hi) {
+			const mid = (lo + hi) >> 1;
+			if (parseFloat(messages[mid].dataset.t) <= t) lo = mid + 1; else hi = mid;
+		}
+		if (lo === played) return;
+		for (let i = Math.min(lo, played); i < Math.max(lo, played); i++) {
+			messages[i].classList.toggle("future", i >= lo);
+		}
+		played = lo;
+		if (follow.checked && lo > 0) messages[lo - 1].scrollIntoView({block: "end"});
+	};
+	video.addEventListener("timeupdate", sync);
+	video.addEventListener("seeked", sync);
+	video.addEventListener("loadedmetadata", sync);
+	document.getElementById("chat").addEventListener("click", e => {
+		const ts = e.target.closest(".ts");
+		if (ts) {
+			video.currentTime = parseFloat(ts.parentElement.dataset.t);
+		}
+	});
+}
+</script>
+</body>
+</html>
+`))
+
+/*
+Formats seconds as H:MM:SS like the timestamps of twitch
+*/
+func clockTime(seconds float64) string {
+	s := int64(seconds)
+	return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+}
+
+// WriteHTML writes the chat as a web page with the emotes and badges of Chat.Assets, a search
+// box and the timestamps of the vod. The page plays the chat along with opts.Video.
+func WriteHTML(w io.Writer, chat *Chat, opts HTMLOptions) error {
+	page := htmlPage{Title: opts.Title, Video: opts.Video, Comments: []htmlComment{}, Images: []string{}}
+	if page.Title == "" {
+		page.Title = "Chat of vod " + chat.VODID
+	}
+
+	emotes := make(map[string]int)
+	named := make(map[string]int)
+	badges := make(map[string]int)
+	if chat.Assets != nil {
+		assets := chat.Assets
+		if opts.Assets != nil {
+			assets = opts.Assets.Embed(assets)
+		}
+		add := func(a Asset, index map[string]int, key string) {
+			link := a.Data
+			if link == "" {
+				link = a.URL
+			}
+			index[key] = len(page.Images)
+			page.Images = append(page.Images, link)
+		}
+		for _, a := range assets.Emotes {
+			if a.Name != "" {
+				add(a, named, a.Name)
+			} else {
+				add(a, emotes, a.ID)
+			}
+		}
+		for _, a := range assets.Badges {
+			add(a, badges, a.ID)
+		}
+	}
+
+	for _, c := range chat.Comments {
+		hc := htmlComment{
+			ID:      c.ID,
+			Offset:  c.Offset,
+			Time:    clockTime(c.VODOffset),
+			Created: c.CreatedAt.UTC().Format(time.RFC3339),
+			Name:    c.Name(),
+			Color:   c.nameColor(),
+		}
+		for _, b := range c.Badges {
+			id := b.SetID + "/" + b.Version
+			if i, ok := badges[id]; ok {
+				hc.Badges = append(hc.Badges, htmlImage{Image: i, Title: id})
+			}
+		}
+		for _, f := range c.Fragments {
+			if i, ok := emotes[f.EmoteID]; ok && f.EmoteID != "" {
+				hc.Parts = append(hc.Parts, htmlPart{Text: f.Text, Emote: true, Image: i})
+				continue
+			}
+			hc.Parts = append(hc.Parts, namedEmoteParts(f.Text, named)...)
+		}
+		page.Comments = append(page.Comments, hc)
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := chatHTMLTemplate.Execute(bw, page); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+/*
+Splits text into text and the emotes of named, keeping the spaces between words
+*/
+func namedEmoteParts(text string, named map[string]int) []htmlPart {
+	var parts []htmlPart
+	var plain strings.Builder
+	for len(text) > 0 {
+		// a word and the spaces after it
+		end := strings.IndexAny(text, " \t\n")
+		if end < 0 {
+			end = len(text)
+		}
+		word := text[:end]
+		rest := strings.TrimLeft(text[end:], " \t\n")
+		if i, ok := named[word]; ok && word != "" {
+			if plain.Len() > 0 {
+				parts = append(parts, htmlPart{Text: plain.String()})
+				plain.Reset()
+			}
+			parts = append(parts, htmlPart{Text: word, Emote: true, Image: i})
+			plain.WriteString(text[end : len(text)-len(rest)])
+		} else {
+			plain.WriteString(text[:len(text)-len(rest)])
+		}
+		text = rest
+	}
+	if plain.Len() > 0 {
+		parts = append(parts, htmlPart{Text: plain.String()})
+	}
+	return parts
+}
+
+/*
+Returns the path of the HTML chat replay next to the video at vodSavePath
+*/
+func htmlSavePath(vodSavePath string) string {
+	return strings.TrimSuffix(chatSavePath(vodSavePath), ".json") + ".html"
+}
+
+/*
+Writes the chat as HTML to path, removing the file if that fails
+*/
+func writeHTMLFile(path string, chat *Chat, opts HTMLOptions) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = WriteHTML(f, chat, opts)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// ExportChatHTML writes the chat replay at chatPath as a web page next to it, for example
+// vod.chat.json becomes vod.chat.html. If opts.Video is empty the page plays the video or audio
+// file of the vod next to the chat if there is one. Returns the path of the page.
+func ExportChatHTML(chatPath string, opts HTMLOptions) (string, error) {
+	chat, err := ReadChat(chatPath)
+	if err != nil {
+		return "", err
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(chatPath, ".json"), ".chat")
+	if opts.Video == "" {
+		for _, ext := range []string{FormatMP4, FormatMKV, FormatMOV, FormatTS, AudioFormatM4A, AudioFormatMP3, AudioFormatOpus, AudioFormatFLAC} {
+			if _, err := os.Stat(base + "." + ext); err == nil {
+				opts.Video = filepath.Base(base + "." + ext)
+				break
+			}
+		}
+	}
+	path := base + ".chat.html"
+	if err := writeHTMLFile(path, chat, opts); err != nil {
+		return "", err
+	}
+	return path, nil
+}
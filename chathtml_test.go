@@ -0,0 +1,145 @@
+package concat
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteHTML(t *testing.T) {
+	first := testComment(5, "mod", "#00ff00", "hi <script>alert(1)</script> catJAM")
+	first.ID, first.VODOffset = "5", 3665
+	first.CreatedAt = time.Date(2020, 6, 1, 10, 0, 5, 0, time.FixedZone("CEST", 2*3600))
+	first.Badges = []ChatBadge{{SetID: "moderator", Version: "1"}, {SetID: "vip", Version: "1"}}
+	first.Fragments = append(first.Fragments, ChatFragment{Text: "Kappa", EmoteID: "25"})
+	chat := &Chat{
+		VODID:    "123",
+		Comments: []ChatComment{first, testComment(7.5, "viewer", "", "catJAMs are not emotes")},
+		Assets: &ChatAssets{
+			Emotes: []Asset{
+				{Provider: "twitch", Type: AssetEmote, ID: "25", URL: "https://cdn/25", Data: "data:image/png;base64,AAAA"},
+				{Provider: "bttv", Type: AssetEmote, ID: "b1", Name: "catJAM", URL: "https://cdn/b1"},
+			},
+			Badges: []Asset{{Provider: "twitch", Type: AssetBadge, ID: "moderator/1", URL: "https://cdn/mod"}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteHTML(&buf, chat, HTMLOptions{Video: "my vod.mp4"}); err != nil {
+		t.Fatal(err)
+	}
+	page := buf.String()
+	for _, want := range []string{
+		"<title>Chat of vod 123</title>",
+		`<video id="video" src="my%20vod.mp4"`,
+		`<div class="msg" id="c-5" data-t="5.000"><a class="ts" href="#c-5" title="2020-06-01T08:00:05Z">1:01:05</a>`,
+		`<img class="badge" data-i="2" alt="" title="moderator/1"><span class="name" style="color: #00FF00">Mod</span>: `,
+		`hi &lt;script&gt;alert(1)&lt;/script&gt; <img class="emote" data-i="1" alt="catJAM" title="catJAM"><img class="emote" data-i="0" alt="Kappa" title="Kappa"></div>`,
+		"catJAMs are not emotes</div>",
+		`const images = ["data:image/png;base64,AAAA","https://cdn/b1","https://cdn/mod"];`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("page doesn't contain %s", want)
+		}
+	}
+	if strings.Contains(page, "vip") {
+		t.Error("page has a badge without an image")
+	}
+
+	buf.Reset()
+	if err := WriteHTML(&buf, &Chat{VODID: "123", Comments: []ChatComment{}}, HTMLOptions{Title: "Incident"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<title>Incident</title>") || strings.Contains(buf.String(), "<video") {
+		t.Errorf("unexpected page without video:\n%s", buf.String())
+	}
+}
+
+func TestNamedEmoteParts(t *testing.T) {
+	named := map[string]int{"catJAM": 3}
+	tests := []struct {
+		text string
+		want []htmlPart
+	}{
+		{"", nil},
+		{"no emotes here ", []htmlPart{{Text: "no emotes here "}}},
+		{"catJAM", []htmlPart{{Text: "catJAM", Emote: true, Image: 3}}},
+		{" a  catJAM b catJAM", []htmlPart{
+			{Text: " a  "}, {Text: "catJAM", Emote: true, Image: 3}, {Text: " b "}, {Text: "catJAM", Emote: true, Image: 3},
+		}},
+		{"catJAM\tcatJAMs", []htmlPart{{Text: "catJAM", Emote: true, Image: 3}, {Text: "\tcatJAMs"}}},
+	}
+	for _, tt := range tests {
+		if got := namedEmoteParts(tt.text, named); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("namedEmoteParts(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadChatHTML(t *testing.T) {
+	assets := newAssetServer(t)
+	defer assets.Close()
+	server := newVODServer(t, 3)
+	requests := 0
+	comments := videoCommentsHandler(t, &requests)
+	badges := chatBadgesHandler(t, assets)
+	server.gql = func(req gqlRequest) (interface{}, error) {
+		if req.OperationName == "ChatBadges" {
+			return badges(req)
+		}
+		return comments(req)
+	}
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d := newTestDownloader(t, server, dir)
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	opts.ChatHTML = true
+	if err := d.Download(context.Background(), vodString, opts); err == nil {
+		t.Error("expected error for the html chat without the chat")
+	}
+
+	opts.Chat = true
+	if err := d.Download(context.Background(), vodString, opts); err == nil {
+		t.Error("expected error for the html chat without emotes")
+	}
+
+	// the images are linked in the chat file and embedded in the page
+	opts.Assets = &AssetCache{Dir: filepath.Join(dir, "cache"), Providers: assets.providers()}
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, vodString+".chat.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(data)
+	if !strings.Contains(page, `src="`+vodString+`.mp4"`) || strings.Count(page, "data:image/png;base64,") != 2 || strings.Count(page, `class="msg"`) != 21 {
+		t.Errorf("unexpected page:\n%.2000s", page)
+	}
+
+	// the export finds the video next to the chat and links the images without a cache
+	os.Remove(filepath.Join(dir, vodString+".chat.html"))
+	path, err := ExportChatHTML(filepath.Join(dir, vodString+".chat.json"), HTMLOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, vodString+".chat.html") {
+		t.Errorf("exported to %s", path)
+	}
+	data, err = ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page := string(data); !strings.Contains(page, `src="`+vodString+`.mp4"`) || !strings.Contains(page, assets.URL+"/twitch/25/default/dark/2.0") {
+		t.Errorf("unexpected exported page:\n%.2000s", page)
+	}
+}
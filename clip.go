@@ -0,0 +1,152 @@
+package concat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+const clipAccessTokenHash string = "36b89d2507fce29e5ca551df756d27c1cfe079e2609642b4390aa4c35796eb11"
+
+// matches clips.twitch.tv/{slug} and twitch.tv/{channel}/clip/{slug} links
+var clipURLRegex = regexp.MustCompile(`^(?:https?://)?(?:(?:www\.|m\.)?twitch\.tv/[^/]+/clip|clips\.twitch\.tv)/([A-Za-z0-9_-]+)`)
+
+type clipQuality struct {
+	FrameRate float64 `json:"frameRate"`
+	Quality   string  `json:"quality"`
+	SourceURL string  `json:"sourceURL"`
+}
+
+type clipInfo struct {
+	ID                  string               `json:"id"`
+	PlaybackAccessToken *playbackAccessToken `json:"playbackAccessToken"`
+	VideoQualities      []clipQuality        `json:"videoQualities"`
+}
+
+/*
+Returns the slug of a clip from either the slug itself or a clip link
+*/
+func clipSlug(clip string) string {
+	if match := clipURLRegex.FindStringSubmatch(clip); match != nil {
+		return match[1]
+	}
+	return clip
+}
+
+func (d *Downloader) clipInfo(ctx context.Context, slug string) (clipInfo, error) {
+	var data struct {
+		Clip *clipInfo `json:"clip"`
+	}
+	err := d.gql(ctx, persistedQuery("VideoAccessToken_Clip", clipAccessTokenHash, map[string]interface{}{
+		"slug": slug,
+	}), &data)
+	if err != nil {
+		return clipInfo{}, err
+	}
+	if data.Clip == nil {
+		return clipInfo{}, fmt.Errorf("clip %s not found", slug)
+	}
+	if data.Clip.PlaybackAccessToken == nil || len(data.Clip.VideoQualities) == 0 {
+		return clipInfo{}, fmt.Errorf("clip %s has no playable qualities", slug)
+	}
+	return *data.Clip, nil
+}
+
+/*
+Names the clip qualities like the vod qualities, e.g. 1080p60 or 720p30. The first, highest quality
+is also available as source quality
+*/
+func clipQualities(info clipInfo) []Quality {
+	query := url.Values{}
+	query.Set("sig", info.PlaybackAccessToken.Signature)
+	query.Set("token", info.PlaybackAccessToken.Value)
+
+	var qualities []Quality
+	for i, q := range info.VideoQualities {
+		name := q.Quality + "p" + strconv.Itoa(int(math.Round(q.FrameRate)))
+		height, _ := strconv.Atoi(q.Quality)
+		quality := Quality{GroupID: name, Name: name, Height: height, FrameRate: q.FrameRate, URL: q.SourceURL + "?" + query.Encode()}
+		if i == 0 {
+			quality.GroupID = SourceQuality
+		}
+		qualities = append(qualities, quality)
+	}
+	return qualities
+}
+
+// DownloadClip downloads the clip with the given slug, or clip link, into a mp4. Clips are served
+// as mp4, so other formats are rejected. Only the quality, retry, path and audio options are used.
+func (d *Downloader) DownloadClip(ctx context.Context, clip string, opts Options) error {
+	slug := clipSlug(clip)
+	if slug == "" {
+		return errors.New("no clip given")
+	}
+	if err := opts.checkFormat(); err != nil {
+		return err
+	}
+	if opts.format() != FormatMP4 {
+		return fmt.Errorf("clips can only be saved as mp4, not %s", opts.format())
+	}
+	if opts.Filename == "" {
+		opts.Filename = slug
+	}
+
+	archiveEntry := ArchiveEntry{Kind: ArchiveKindClip, ID: slug, Quality: opts.Quality}
+	if err := d.checkArchive(archiveEntry); err != nil {
+		return err
+	}
+
+	clipSavePath := filepath.Join(opts.DownloadPath, opts.Filename+"."+FormatMP4)
+
+	_, err := os.Stat(clipSavePath)
+
+	if err == nil || !os.IsNotExist(err) {
+		return fmt.Errorf("destination file %s already exists", clipSavePath)
+	}
+
+	d.print("Contacting Twitch Server")
+
+	info, err := d.clipInfo(ctx, slug)
+	if err != nil {
+		return fmt.Errorf("could not get clip info: %v", err)
+	}
+
+	qualities := clipQualities(info)
+
+	d.printDebug(qualities)
+
+	quality, err := d.selectQuality(qualities, opts.Quality)
+	if err != nil {
+		return err
+	}
+	clipLink := quality.URL
+
+	d.print("Starting Download")
+
+	body, err := d.fetchWithRetry(ctx, clipLink, opts.TryCount)
+	if err != nil {
+		return fmt.Errorf("could not download clip: %v", err)
+	}
+
+	// a partly written clip would block the next try as an existing file
+	if err := writeFileAtomic(clipSavePath, body); err != nil {
+		return err
+	}
+
+	if err := d.extractAudio(ctx, clipSavePath, opts); err != nil {
+		return err
+	}
+
+	if err := d.recordArchive(archiveEntry, clipSavePath, opts.audioSavePath(clipSavePath)); err != nil {
+		return err
+	}
+
+	d.print("All done!")
+	return nil
+}
@@ -0,0 +1,104 @@
+package concat
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClipSlug(t *testing.T) {
+	tests := map[string]string{
+		"AwkwardHelplessSalamanderSwiftRage":                                    "AwkwardHelplessSalamanderSwiftRage",
+		"https://clips.twitch.tv/AwkwardHelplessSalamanderSwiftRage":            "AwkwardHelplessSalamanderSwiftRage",
+		"https://www.twitch.tv/reckful/clip/AwkwardHelplessSalamanderSwiftRage": "AwkwardHelplessSalamanderSwiftRage",
+		"twitch.tv/reckful/clip/Awkward-Helpless_Salamander?filter=clips":       "Awkward-Helpless_Salamander",
+	}
+	for input, want := range tests {
+		if got := clipSlug(input); got != want {
+			t.Errorf("clipSlug(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestDownloadClip(t *testing.T) {
+	var requested string
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sig") != "clipsig" || r.URL.Query().Get("token") != `{"clip_uri":"x"}` {
+			http.Error(w, "bad token", http.StatusForbidden)
+			return
+		}
+		requested = r.URL.Path
+		w.Write([]byte("clip " + r.URL.Path))
+	}))
+	defer files.Close()
+
+	gqlServer := newGQLServer(t, func(req gqlRequest) (interface{}, error) {
+		if req.OperationName != "VideoAccessToken_Clip" || req.Variables["slug"] != "SwiftRage" {
+			t.Errorf("unexpected gql request: %+v", req)
+		}
+		return map[string]interface{}{
+			"clip": map[string]interface{}{
+				"id":                  "1",
+				"playbackAccessToken": map[string]string{"signature": "clipsig", "value": `{"clip_uri":"x"}`},
+				"videoQualities": []map[string]interface{}{
+					{"frameRate": 60, "quality": "1080", "sourceURL": files.URL + "/1080.mp4"},
+					{"frameRate": 30.000001, "quality": "720", "sourceURL": files.URL + "/720.mp4"},
+				},
+			},
+		}, nil
+	})
+	defer gqlServer.Close()
+
+	dir, err := ioutil.TempDir("", "concat_clip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := &Downloader{GQLEndpoint: gqlServer.URL}
+
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	opts.Quality = "720p30"
+	if err := d.DownloadClip(context.Background(), "https://clips.twitch.tv/SwiftRage", opts); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dir, "SwiftRage.mp4"))
+	if err != nil || string(got) != "clip /720.mp4" {
+		t.Errorf("got %q, %v", got, err)
+	}
+
+	opts.Quality = "160p30"
+	opts.Filename = "source"
+	if err := d.DownloadClip(context.Background(), "SwiftRage", opts); err != nil || requested != "/1080.mp4" {
+		t.Errorf("expected fallback to source quality, got %s, %v", requested, err)
+	}
+
+	if err := d.DownloadClip(context.Background(), "SwiftRage", opts); err == nil {
+		t.Errorf("expected error for existing destination file")
+	}
+
+	// only the clips are left, no temporary files
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if strings.Join(names, " ") != "SwiftRage.mp4 source.mp4" {
+		t.Errorf("download directory has %q", names)
+	}
+
+	opts.Filename = "other"
+	opts.Format = FormatMKV
+	if err := d.DownloadClip(context.Background(), "SwiftRage", opts); err == nil || !strings.Contains(err.Error(), "mp4") {
+		t.Errorf("expected error for a clip as mkv, got %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func printDebug(msg ...interface{}) {
+	if debug {
+		fmt.Println(msg...)
+	}
+}
+
+func printDebugf(format string, args ...interface{}) {
+	if debug {
+		fmt.Printf(format, args...)
+	}
+}
+
+func printFatal(err error, msg ...interface{}) {
+	if len(msg) > 0 {
+		fmt.Println(msg...)
+	}
+	printDebug(err)
+	os.Exit(1)
+}
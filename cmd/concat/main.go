@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ArneVogel/concat"
+)
+
+const currentReleaseLink string = "https://github.com/ArneVogel/concat/releases/latest"
+const currentReleaseStart string = `<a href="/ArneVogel/concat/releases/download/`
+const currentReleaseEnd string = `/concat"`
+const versionNumber string = "v0.3.1"
+
+var debug bool
+
+func wrongInputNotification() {
+	fmt.Println("Call the program with -help for information on how to use it :^)")
+}
+
+/*
+Parses the -start, -end and -duration flags. end is "full" if it isn't set, duration is empty
+*/
+func parseRange(start string, end string, duration string) (time.Duration, time.Duration, error) {
+	s, err := concat.ParseTimestamp(start)
+	if err != nil {
+		return 0, 0, err
+	}
+	if s < 0 {
+		return 0, 0, fmt.Errorf("-start %s can't be relative to the end of the vod", start)
+	}
+
+	if duration != "" {
+		if end != "full" {
+			return 0, 0, errors.New("use either -end or -duration")
+		}
+		d, err := parseDuration(duration)
+		if err != nil {
+			return 0, 0, err
+		}
+		return s, s + d, nil
+	}
+
+	if end == "full" {
+		return s, 0, nil
+	}
+	e, err := concat.ParseTimestamp(end)
+	if err != nil {
+		return 0, 0, err
+	}
+	if e >= 0 && s > e {
+		return 0, 0, fmt.Errorf("-start %s is after -end %s", start, end)
+	}
+	return s, e, nil
+}
+
+/*
+Parses the -duration flag, an empty duration is 0
+*/
+func parseDuration(duration string) (time.Duration, error) {
+	if duration == "" {
+		return 0, nil
+	}
+	d, err := concat.ParseTimestamp(duration)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("-duration %s must be positive", duration)
+	}
+	return d, nil
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
+/*
+Parses dates like 2020-01-31, an empty date is the zero time
+*/
+func parseDate(date string) (time.Time, error) {
+	if date == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006-01-02", date)
+}
+
+func rightVersion() bool {
+	resp, err := http.Get(currentReleaseLink)
+	if err != nil {
+		printFatal(err, "Could not access github while checking for most recent release.")
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	respString := string(body)
+
+	cs := strings.Index(respString, currentReleaseStart) + len(currentReleaseStart)
+	ce := cs + len(versionNumber)
+	if ce > len(respString) {
+		return true
+	}
+	return respString[cs:ce] == versionNumber
+}
+
+func main() {
+
+	qualityInfo := flag.Bool("qualityinfo", false, "if you want to see the avaliable quality options")
+	jsonOutput := flag.Bool("json", false, "with -qualityinfo: print the quality options as json, the same as -format json")
+	format := flag.String("format", "", "format of the output file: mp4, ts, mkv or mov, mp4 by default. With -qualityinfo: json prints the quality options as json")
+	codec := flag.String("codec", "", "re-encode the video with this ffmpeg encoder, for example libx264, libx265 or libsvtav1. The video is copied by default")
+	crf := flag.Int("crf", 0, "constant rate factor of the re-encoded video, lower is better quality. Re-encodes with libx264 if -codec isn't set")
+	preset := flag.String("preset", "", "encoder preset of the re-encoded video, for example veryfast or slow. Re-encodes with libx264 if -codec isn't set")
+	scale := flag.Int("scale", 0, "scale the video to this height, for example -scale=720. Re-encodes with libx264 if -codec isn't set")
+	muxer := flag.String("muxer", "auto", "combine the chunks with ffmpeg or the builtin muxer, auto uses ffmpeg if it is installed")
+
+	standardVOD := "123456789"
+	vodID := flag.String("vod", standardVOD, "the vod id https://www.twitch.tv/videos/123456789")
+	start := flag.String("start", "0 0 0", "For example: 1:20:00, 1h20m, 4800 or 1 20 0 for starting at 1 hour and 20 minutes")
+	end := flag.String("end", "full", "For example: 1:20:00, 1h20m, 4800 or 1 20 0 for ending the vod at 1 hour and 20 minutes, -10m for ending 10 minutes before the end of the vod")
+	quality := flag.String("quality", concat.SourceQuality, "quality name or selector like best, worst, <=1080p, >=30fps or 720p60,720p,best; chunked for source quality is automatically used if -quality isn't set")
+	myClientID := flag.String("client-id", concat.DefaultClientID, "Use your own client id")
+	debugFlag := flag.Bool("debug", false, "debug output")
+	semaphoreLimit := flag.Int("max-concurrent-downloads", 5, "change maximum number of concurrent downloads")
+	jobLimit := flag.Int("max-concurrent-jobs", 2, "with -jobs: the number of jobs that run at the same time")
+	downloadPath := flag.String("download-path", ".", "path where the file will be saved")
+	filename := flag.String("filename", "", "name of the output file (without extension)")
+	audio := flag.Bool("audio", false, "extract audio from the video file")
+	audioOnly := flag.Bool("audio-only", false, "end up only with a audio file, vods are downloaded in the audio only quality if there is one")
+	audioFormat := flag.String("audio-format", concat.AudioFormatMP3, "format of -audio and -audio-only: m4a copies the audio without quality loss, mp3, opus or flac")
+	audioBitrate := flag.Int("audio-bitrate", 0, "bitrate of mp3 and opus audio in kbit/s, for example 192. The default of the encoder by default")
+	maxTryCount := flag.Int("try-count", 3, "amount of times concat should try fetching chunks. Set to 0 for infinite retries")
+	smartCut := flag.Bool("smart-cut", false, "re-encode the first and last few seconds so the video starts and ends exactly at -start and -end. Needs ffprobe")
+	chat := flag.Bool("chat", false, "download the chat of the downloaded part of a vod into <filename>.chat.json")
+	subtitles := flag.String("subtitles", "", "render the chat as srt or ass subtitles into <filename>.chat.srt or .chat.ass, implies -chat")
+	muxSubtitles := flag.Bool("mux-subtitles", false, "add the chat subtitles as a track to the mp4, mov or mkv video")
+	chatDuration := flag.Duration("chat-duration", 8*time.Second, "how long a chat message stays on screen in the subtitles, for example 5s")
+	chatLines := flag.Int("chat-lines", 8, "number of chat messages shown at once in the subtitles")
+	chatPosition := flag.String("chat-position", concat.ChatBottomLeft, "position of the chat subtitles: bottom-left, bottom-right, top-left or top-right")
+	chatWidth := flag.Int("chat-width", 600, "width of the ass chat subtitles in pixels of a 1080p video")
+	chatFont := flag.String("chat-font", "Arial", "font of the ass chat subtitles")
+	chatFontSize := flag.Int("chat-font-size", 32, "font size of the ass chat subtitles in pixels of a 1080p video")
+	convertChat := flag.String("convert-chat", "", "render a chat saved with -chat as subtitles in the format of -subtitles, for example -convert-chat=123456789.chat.json")
+	chatHTML := flag.Bool("chat-html", false, "write the chat as a web page with emotes, timestamps and a search box that plays along with the video, implies -chat")
+	exportChatHTML := flag.String("export-chat-html", "", "write a chat saved with -chat as a web page, for example -export-chat-html=123456789.chat.json")
+	burnChat := flag.Bool("burn-chat", false, "draw the chat into the video, re-encodes the video in software, implies -chat")
+	overlayPosition := flag.String("overlay-position", concat.ChatBottomLeft, "position of the burned in chat: bottom-left, bottom-right, top-left or top-right")
+	overlayWidth := flag.Int("overlay-width", 400, "width of the burned in chat in pixels")
+	overlayHeight := flag.Int("overlay-height", 600, "height of the burned in chat in pixels")
+	overlayFont := flag.String("overlay-font", "", "TrueType font file of the burned in chat, by default Arial or DejaVu Sans of the system")
+	overlayFontSize := flag.Int("overlay-font-size", 20, "font size of the burned in chat in pixels")
+	overlayOpacity := flag.Float64("overlay-opacity", 0.5, "opacity of the black background of the burned in chat from 0 to 1")
+	overlayDuration := flag.Duration("overlay-duration", 0, "how long a message stays in the burned in chat, by default until newer messages push it out")
+	emoteDir := flag.String("emote-dir", "", "directory with the emotes and badges of the burned in chat as emotes/<id>.png and badges/<set>/<version>.png")
+	emoteCache := flag.String("emote-cache", "", "with -chat: download the twitch, BTTV, FFZ and 7TV emotes and the badges of the chat into this directory and list them in the chat file")
+	embedEmotes := flag.Bool("embed-emotes", false, "with -emote-cache: put the emote and badge images into the chat file instead of linking them")
+	offlineEmotes := flag.Bool("offline-emotes", false, "with -emote-cache: only use the emotes and badges that are already in the cache")
+	allowGaps := flag.Bool("allow-gaps", false, "combine the video even if some chunks couldn't be downloaded and list the missing parts in <filename>_gaps.txt")
+	live := flag.String("live", "", "record the live stream of a channel, for example -live=reckful")
+	clip := flag.String("clip", "", "download a clip by its slug or link, for example -clip=https://clips.twitch.tv/AwkwardHelplessSalamanderSwiftRage")
+	channel := flag.String("channel", "", "download all videos of a channel, for example -channel=reckful")
+	videoTypes := flag.String("types", "", "with -channel: comma separated video types to download: archive, highlight, upload. All types by default")
+	game := flag.String("game", "", "with -channel: only download videos of this game")
+	after := flag.String("after", "", "with -channel: only download videos published on or after this date, for example 2020-01-31")
+	before := flag.String("before", "", "with -channel: only download videos published before this date, for example 2020-12-31")
+	jobsFile := flag.String("jobs", "", "run all downloads of a jobs file (CSV or JSON) with the columns vod, start, end, quality and filename")
+	downloadArchive := flag.String("download-archive", "", "file that records finished downloads, downloads that are already recorded are skipped")
+	duration := flag.String("duration", "", "download this much of the vod after -start instead of using -end, or stop recording a live stream after this duration. For example 2h30m or 1:30:00")
+
+	flag.Parse()
+
+	debug = *debugFlag
+
+	if *jsonOutput && !*qualityInfo {
+		fmt.Println("-json needs -qualityinfo")
+		os.Exit(1)
+	}
+	if *qualityInfo {
+		// -qualityinfo doesn't write a file, so -format only chooses between text and json
+		switch *format {
+		case "":
+		case "json":
+			*jsonOutput = true
+		default:
+			fmt.Printf("Unknown -format %q, -qualityinfo supports json\n", *format)
+			os.Exit(1)
+		}
+	} else {
+		switch *format {
+		case "", concat.FormatMP4, concat.FormatTS, concat.FormatMKV, concat.FormatMOV:
+		default:
+			fmt.Printf("Unknown -format %q, use mp4, ts, mkv or mov\n", *format)
+			os.Exit(1)
+		}
+	}
+	switch *audioFormat {
+	case concat.AudioFormatM4A, concat.AudioFormatMP3, concat.AudioFormatOpus, concat.AudioFormatFLAC:
+	default:
+		fmt.Printf("Unknown -audio-format %q, use m4a, mp3, opus or flac\n", *audioFormat)
+		os.Exit(1)
+	}
+	subtitleOpts := concat.SubtitleOptions{
+		Duration: *chatDuration,
+		Lines:    *chatLines,
+		Position: *chatPosition,
+		Width:    *chatWidth,
+		Font:     *chatFont,
+		FontSize: *chatFontSize,
+	}
+	if *subtitles != "" || *burnChat || *chatHTML {
+		*chat = true
+	}
+	overlay := concat.OverlayOptions{
+		Position: *overlayPosition,
+		Width:    *overlayWidth,
+		Height:   *overlayHeight,
+		Font:     *overlayFont,
+		FontSize: *overlayFontSize,
+		Opacity:  *overlayOpacity,
+		Duration: *overlayDuration,
+	}
+	// 0 is the default opacity of OverlayOptions
+	if *overlayOpacity == 0 {
+		overlay.Opacity = -1
+	}
+	if *emoteDir != "" {
+		overlay.Images = concat.ImageDir(*emoteDir)
+	}
+	var assets *concat.AssetCache
+	if *emoteCache != "" {
+		assets = &concat.AssetCache{Dir: *emoteCache, Offline: *offlineEmotes}
+	}
+	if *chatHTML && assets == nil {
+		// the page has the emotes in it, so they are cached even without -emote-cache
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			printFatal(err, "Could not find a directory for the emotes of -chat-html, set one with -emote-cache")
+		}
+		assets = &concat.AssetCache{Dir: filepath.Join(cacheDir, "concat", "emotes")}
+	}
+	if (*embedEmotes || *offlineEmotes) && *emoteCache == "" {
+		fmt.Println("-embed-emotes and -offline-emotes need -emote-cache")
+		os.Exit(1)
+	}
+	if *muxSubtitles && *subtitles == "" {
+		fmt.Println("-mux-subtitles needs -subtitles=srt or -subtitles=ass")
+		os.Exit(1)
+	}
+
+	if *convertChat != "" {
+		if *subtitles == "" {
+			*subtitles = concat.SubtitlesSRT
+		}
+		path, err := concat.ConvertChat(*convertChat, *subtitles, subtitleOpts)
+		if err != nil {
+			printFatal(err, "Could not convert the chat:", err)
+		}
+		fmt.Printf("Wrote %s\n", path)
+		os.Exit(0)
+	}
+	if *exportChatHTML != "" {
+		path, err := concat.ExportChatHTML(*exportChatHTML, concat.HTMLOptions{Assets: assets})
+		if err != nil {
+			printFatal(err, "Could not export the chat:", err)
+		}
+		fmt.Printf("Wrote %s\n", path)
+		os.Exit(0)
+	}
+
+	encode := concat.EncodeOptions{Codec: *codec, CRF: *crf, Preset: *preset, Height: *scale}
+	reencode := *codec != "" || *crf > 0 || *preset != "" || *scale > 0
+
+	// every mode downloads with these options, the modes only change what differs for them
+	opts := concat.Options{
+		Quality:                *quality,
+		MaxConcurrentDownloads: *semaphoreLimit,
+		MaxConcurrentJobs:      *jobLimit,
+		TryCount:               *maxTryCount,
+		DownloadPath:           *downloadPath,
+		Filename:               *filename,
+		Audio:                  *audio,
+		AudioOnly:              *audioOnly,
+		AudioFormat:            *audioFormat,
+		AudioBitrate:           *audioBitrate,
+		Format:                 *format,
+		Encode:                 encode,
+		SmartCut:               *smartCut,
+		Chat:                   *chat,
+		Subtitles:              *subtitles,
+		SubtitleOptions:        subtitleOpts,
+		MuxSubtitles:           *muxSubtitles,
+		BurnChat:               *burnChat,
+		Overlay:                overlay,
+		Assets:                 assets,
+		EmbedAssets:            *embedEmotes,
+		ChatHTML:               *chatHTML,
+		AllowGaps:              *allowGaps,
+	}
+
+	// a twitch link as argument selects the mode
+	collection := ""
+	if flag.NArg() > 1 {
+		wrongInputNotification()
+		os.Exit(1)
+	}
+	if flag.NArg() == 1 {
+		link, err := concat.ParseLink(flag.Arg(0))
+		if err != nil {
+			wrongInputNotification()
+			printFatal(err, err)
+		}
+		printDebugf("\nlink: %+v\n", link)
+		switch link.Kind {
+		case concat.LinkVOD:
+			*vodID = link.ID
+			if link.Start > 0 && !isFlagSet("start") {
+				*start = link.Start.String()
+			}
+		case concat.LinkClip:
+			*clip = link.ID
+		case concat.LinkChannel:
+			*channel = link.ID
+		case concat.LinkCollection:
+			collection = link.ID
+		}
+	}
+
+	d := &concat.Downloader{
+		ClientID: *myClientID,
+		Output:   os.Stdout,
+		Debug:    debug,
+	}
+
+	if *downloadArchive != "" {
+		archive, err := concat.OpenArchive(*downloadArchive)
+		if err != nil {
+			printFatal(err, "Could not read download archive:", err)
+		}
+		d.Archive = archive
+	}
+
+	ffmpegInstalled := d.FFmpegIsInstalled()
+	// only ffmpeg writes mkv and mov and re-encodes
+	builtinSupported := *format != concat.FormatMKV && *format != concat.FormatMOV && !reencode && !*muxSubtitles && !*burnChat
+	builtin := false
+	switch *muxer {
+	case "auto":
+		if !ffmpegInstalled && !*qualityInfo && *clip == "" && builtinSupported {
+			fmt.Println("Could not find ffmpeg, using the builtin muxer.")
+			builtin = true
+		}
+	case "builtin":
+		builtin = true
+	case "ffmpeg":
+	default:
+		fmt.Printf("Unknown -muxer %q, use auto, builtin or ffmpeg\n", *muxer)
+		os.Exit(1)
+	}
+	if builtin {
+		if !builtinSupported {
+			fmt.Println("The builtin muxer only writes mp4 and ts files without re-encoding, subtitles or the burned in chat, use -muxer=ffmpeg.")
+			os.Exit(1)
+		}
+		d.Muxer = concat.BuiltinMuxer{}
+	}
+
+	// the builtin muxer writes the audio only quality of vods straight into a m4a
+	directAudio := *audioOnly && *audioFormat == concat.AudioFormatM4A && *clip == ""
+	needsFFmpeg := !*qualityInfo && (*clip == "" && !builtin || *audio || *audioOnly && !directAudio)
+	if needsFFmpeg && !ffmpegInstalled {
+		fmt.Println("Could not find ffmpeg, make sure to have ffmpeg avaliable on your system.")
+		os.Exit(1)
+	}
+
+	if strings.Compare(*myClientID, concat.DefaultClientID) == 0 {
+		fmt.Println("If you encounter errors looking like: \"Couldn't find quality: chunked\" you might have to use your own client-id. \nUse -client-id to pass it to concat. \nFind out how to get your own client id here: https://github.com/ArneVogel/concat/wiki/FAQ#how-to-get-a-client-id")
+		fmt.Println()
+	}
+	printDebugf("\ntwitchClientID: %s\n", *myClientID)
+
+	if !rightVersion() {
+		fmt.Printf("\nYou are using an old version of concat. Check out %s for the most recent version.\n\n", currentReleaseLink)
+	}
+
+	ctx := context.Background()
+
+	if *live != "" {
+		ctx, cancel := context.WithCancel(ctx)
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			fmt.Println("\nStopping recording")
+			cancel()
+		}()
+
+		maxDuration, err := parseDuration(*duration)
+		if err != nil {
+			wrongInputNotification()
+			printFatal(err, err)
+		}
+
+		liveOpts := concat.LiveOptions{Options: opts, MaxDuration: maxDuration}
+		if err := d.RecordLive(ctx, *live, liveOpts); err != nil {
+			printFatal(err, err)
+		}
+		os.Exit(0)
+	}
+
+	if *clip != "" {
+		if err := d.DownloadClip(ctx, *clip, opts); err != nil && err != concat.ErrAlreadyArchived {
+			printFatal(err, err)
+		}
+		os.Exit(0)
+	}
+
+	if *channel != "" {
+		filter := concat.VideoFilter{Game: *game}
+		if *videoTypes != "" {
+			filter.Types = strings.Split(*videoTypes, ",")
+		}
+		var err error
+		filter.After, err = parseDate(*after)
+		if err != nil {
+			wrongInputNotification()
+			printFatal(err, err)
+		}
+		filter.Before, err = parseDate(*before)
+		if err != nil {
+			wrongInputNotification()
+			printFatal(err, err)
+		}
+
+		if err := d.ArchiveChannel(ctx, *channel, filter, opts); err != nil {
+			printFatal(err, err)
+		}
+		os.Exit(0)
+	}
+
+	if collection != "" {
+		if err := d.ArchiveCollection(ctx, collection, opts); err != nil {
+			printFatal(err, err)
+		}
+		os.Exit(0)
+	}
+
+	if *jobsFile != "" {
+		jobs, err := concat.ReadJobs(*jobsFile)
+		if err != nil {
+			printFatal(err, "Could not read jobs file:", err)
+		}
+
+		if _, err := d.RunBatch(ctx, jobs, opts); err != nil {
+			printFatal(err, err)
+		}
+		os.Exit(0)
+	}
+
+	if *vodID == standardVOD {
+		wrongInputNotification()
+		os.Exit(1)
+	}
+
+	if *qualityInfo && *jsonOutput {
+		// progress messages would break the json
+		d.Output = os.Stderr
+		qualities, err := d.Qualities(ctx, *vodID)
+		if err != nil {
+			printFatal(err, "Could not get quality options")
+		}
+		out, err := json.MarshalIndent(qualities, "", "  ")
+		if err != nil {
+			printFatal(err, err)
+		}
+		fmt.Println(string(out))
+		os.Exit(0)
+	}
+
+	if *qualityInfo {
+		options, err := d.QualityOptions(ctx, *vodID)
+		if err != nil {
+			printFatal(err, "Could not get quality options")
+		}
+		for _, o := range options {
+			fmt.Printf("resolution: %s, download with -quality=\"%s\"\n", o.Resolution, o.Quality)
+		}
+		os.Exit(0)
+	}
+
+	var err error
+	opts.Start, opts.End, err = parseRange(*start, *end, *duration)
+	if err != nil {
+		wrongInputNotification()
+		printFatal(err, err)
+	}
+
+	if err := d.Download(ctx, *vodID, opts); err != nil && err != concat.ErrAlreadyArchived {
+		printFatal(err, err)
+	}
+}
@@ -0,0 +1,112 @@
+package concat
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const collectionPageSize int = 100
+
+// the twitch website has no persisted query that lists all videos of a collection
+const collectionVideosQuery string = `query CollectionVideos($id: ID!, $first: Int, $after: Cursor) {
+  collection(id: $id) {
+    title
+    items(first: $first, after: $after) {
+      edges {
+        cursor
+        node {
+          ... on Video {
+            id
+            title
+            publishedAt
+            broadcastType
+            lengthSeconds
+            game { name }
+          }
+        }
+      }
+      pageInfo { hasNextPage }
+    }
+  }
+}`
+
+type collectionPage struct {
+	Collection *struct {
+		Title string `json:"title"`
+		Items struct {
+			Edges []struct {
+				Cursor string `json:"cursor"`
+				Node   struct {
+					ID            string    `json:"id"`
+					Title         string    `json:"title"`
+					PublishedAt   time.Time `json:"publishedAt"`
+					BroadcastType string    `json:"broadcastType"`
+					LengthSeconds int       `json:"lengthSeconds"`
+					Game          *struct {
+						Name string `json:"name"`
+					} `json:"game"`
+				} `json:"node"`
+			} `json:"edges"`
+			PageInfo struct {
+				HasNextPage bool `json:"hasNextPage"`
+			} `json:"pageInfo"`
+		} `json:"items"`
+	} `json:"collection"`
+}
+
+// CollectionVideos lists the videos of the collection with id in the order of the collection.
+func (d *Downloader) CollectionVideos(ctx context.Context, id string) ([]Video, error) {
+	var videos []Video
+	var cursor interface{}
+	for {
+		var page collectionPage
+		err := d.gql(ctx, query("CollectionVideos", collectionVideosQuery, map[string]interface{}{
+			"id":    id,
+			"first": collectionPageSize,
+			"after": cursor,
+		}), &page)
+		if err != nil {
+			return nil, err
+		}
+		if page.Collection == nil {
+			return nil, fmt.Errorf("collection %s not found", id)
+		}
+
+		edges := page.Collection.Items.Edges
+		for _, edge := range edges {
+			// items that aren't videos have no id
+			if edge.Node.ID == "" {
+				continue
+			}
+			v := Video{
+				ID:          edge.Node.ID,
+				Title:       edge.Node.Title,
+				Type:        edge.Node.BroadcastType,
+				PublishedAt: edge.Node.PublishedAt,
+				Length:      time.Duration(edge.Node.LengthSeconds) * time.Second,
+			}
+			if edge.Node.Game != nil {
+				v.Game = edge.Node.Game.Name
+			}
+			videos = append(videos, v)
+		}
+
+		if !page.Collection.Items.PageInfo.HasNextPage || len(edges) == 0 {
+			return videos, nil
+		}
+		cursor = edges[len(edges)-1].Cursor
+	}
+}
+
+// ArchiveCollection downloads every video of the collection with id the same way ArchiveChannel does.
+func (d *Downloader) ArchiveCollection(ctx context.Context, id string, opts Options) error {
+	d.printf("Listing videos of collection %s\n", id)
+
+	videos, err := d.CollectionVideos(ctx, id)
+	if err != nil {
+		return fmt.Errorf("could not list videos: %v", err)
+	}
+
+	return d.archiveVideos(ctx, videos, opts)
+}
@@ -0,0 +1,39 @@
+package concat
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestCollectionVideos(t *testing.T) {
+	server := newGQLServer(t, func(req gqlRequest) (interface{}, error) {
+		if req.OperationName != "CollectionVideos" || req.Query != collectionVideosQuery || req.Variables["id"] != "abc" {
+			t.Errorf("unexpected gql request: %+v", req)
+		}
+		page := 0
+		if cursor, ok := req.Variables["after"].(string); ok {
+			fmt.Sscanf(cursor, "page%d", &page)
+		}
+		edges := []map[string]interface{}{
+			{"cursor": fmt.Sprintf("page%d", page+1), "node": map[string]interface{}{"id": fmt.Sprint(100 + page), "title": "stream", "lengthSeconds": 60}},
+			{"cursor": fmt.Sprintf("page%d", page+1), "node": map[string]interface{}{}},
+		}
+		return map[string]interface{}{
+			"collection": map[string]interface{}{
+				"title": "best of",
+				"items": map[string]interface{}{
+					"edges":    edges,
+					"pageInfo": map[string]bool{"hasNextPage": page == 0},
+				},
+			},
+		}, nil
+	})
+	defer server.Close()
+
+	d := &Downloader{GQLEndpoint: server.URL}
+	videos, err := d.CollectionVideos(context.Background(), "abc")
+	if err != nil || len(videos) != 2 || videos[0].ID != "100" || videos[1].ID != "101" {
+		t.Errorf("CollectionVideos: got %+v, %v", videos, err)
+	}
+}
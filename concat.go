@@ -0,0 +1,213 @@
+/*
+Package concat downloads twitch vods, or parts of them, and combines the
+downloaded chunks into a single file with ffmpeg or the builtin muxer of package ts.
+
+The concat command line tool in cmd/concat is a thin wrapper around this
+package.
+*/
+package concat
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/abiosoft/semaphore"
+)
+
+//new style of edgecast links: https://vod089-ttvnw.akamaized.net/1059582120fbff1a392a_reinierboortman_26420932624_719978480/chunked/highlight-180380104.m3u8
+//old style of edgecast links: https://vod164-ttvnw.akamaized.net/7a16586e4b7ef40300ba_zizaran_27258736688_772341213/chunked/index-dvr.m3u8
+
+const edgecastLinkBegin string = "https://"
+const edgecastLinkBaseEndOld string = "index"
+const edgecastLinkBaseEnd string = "highlight"
+const edgecastLinkM3U8End string = ".m3u8"
+const chunkFileExtension string = ".ts"
+const unmutedChunkSuffix string = "-unmuted.ts"
+const mutedChunkSuffix string = "-muted.ts"
+const chunkTimeout = 30 * time.Second
+
+// SourceQuality is the name twitch uses for the source quality playlist.
+const SourceQuality string = "chunked"
+
+// DefaultClientID is the client id sent to twitch if Downloader.ClientID is empty.
+const DefaultClientID string = "aokchnui2n8q38g0vezl9hq6htzy4c"
+
+// DefaultUsherBaseURL is the usher api used if Downloader.UsherBaseURL is empty.
+const DefaultUsherBaseURL string = "https://usher.twitch.tv"
+
+// Downloader downloads twitch vods. The zero value is ready to use.
+type Downloader struct {
+	// HTTPClient is used for all requests to twitch. http.DefaultClient is used if nil.
+	HTTPClient *http.Client
+
+	// ClientID is sent to the twitch api. DefaultClientID is used if empty.
+	ClientID string
+
+	// TokenProvider returns the access tokens for the usher api. If nil the GQL api is used, with the
+	// old api.twitch.tv token api as fallback.
+	TokenProvider TokenProvider
+
+	// GQLEndpoint and GQLClientID are used for requests to the twitch GQL api.
+	// DefaultGQLEndpoint and DefaultGQLClientID are used if empty.
+	GQLEndpoint string
+	GQLClientID string
+
+	// UsherBaseURL is the base of the usher api links. DefaultUsherBaseURL is used if empty.
+	UsherBaseURL string
+
+	// Archive records finished downloads. Downloads that are already recorded are skipped with
+	// ErrAlreadyArchived. Nothing is recorded if nil.
+	Archive *Archive
+
+	// Muxer combines the chunks. A FFmpegMuxer with FFmpegCmd and FFprobeCmd is used if nil.
+	Muxer Muxer
+
+	// FFmpegCmd is the ffmpeg binary used to combine the chunks.
+	// Defaults to ffmpeg (ffmpeg.exe on windows).
+	FFmpegCmd string
+
+	// FFprobeCmd is the ffprobe binary used to find the keyframes for Options.SmartCut.
+	// Defaults to ffprobe (ffprobe.exe on windows).
+	FFprobeCmd string
+
+	// Retry controls the delay between the tries of chunk downloads.
+	Retry RetryPolicy
+
+	// Output receives progress messages. Nothing is printed if nil.
+	Output io.Writer
+
+	// Debug enables verbose output to Output.
+	Debug bool
+
+	// sem limits the concurrent chunk downloads of all jobs of a batch.
+	sem *semaphore.Semaphore
+}
+
+// Options describe which part of a vod is downloaded and where it is saved.
+type Options struct {
+	// Quality of the vod, see the -qualityinfo flag of the command line tool. Besides names like 720p60
+	// it takes selectors like best, worst, <=1080p, >=30fps and audio_only, alternatives separated by
+	// commas like 720p60,720p,best are tried in order. Falls back to the highest available quality.
+	Quality string
+
+	// Start and End of the downloaded part. An End of 0 downloads till the end of the vod.
+	Start time.Duration
+	End   time.Duration
+
+	// MaxConcurrentDownloads is the number of chunks downloaded simultaneously.
+	MaxConcurrentDownloads int
+
+	// MaxConcurrentJobs is the number of jobs RunBatch runs simultaneously.
+	MaxConcurrentJobs int
+
+	// TryCount is the amount of times a chunk is fetched before giving up.
+	// Set to 0 for infinite retries.
+	TryCount int
+
+	// DownloadPath is the directory for the chunks and the final file.
+	DownloadPath string
+
+	// Filename of the final file without extension. Defaults to the vod id.
+	Filename string
+
+	// Audio extracts the audio into a file next to the video, see AudioFormat.
+	Audio bool
+
+	// AudioOnly is the same as Audio but doesn't keep the video file. Vods are downloaded in the
+	// audio only quality if there is one.
+	AudioOnly bool
+
+	// AudioFormat of Audio and AudioOnly: AudioFormatM4A, AudioFormatMP3, AudioFormatOpus or
+	// AudioFormatFLAC. AudioFormatMP3 is used if empty.
+	AudioFormat string
+
+	// AudioBitrate of mp3 and opus audio in kbit/s. The default of the encoder is used if 0.
+	AudioBitrate int
+
+	// SmartCut re-encodes the first and last GOP of the output, so it starts and ends exactly at
+	// Start and End. Without it the output starts at the keyframe before Start. Needs ffprobe.
+	SmartCut bool
+
+	// Format of the output file, FormatMP4, FormatTS, FormatMKV or FormatMOV. FormatMP4 is used if empty.
+	Format string
+
+	// Encode re-encodes the video with ffmpeg instead of copying it. The zero value copies.
+	Encode EncodeOptions
+
+	// Chat downloads the chat of the downloaded part of a vod into <Filename>.chat.json, see Chat.
+	Chat bool
+
+	// Subtitles renders the chat into <Filename>.chat.srt or <Filename>.chat.ass, SubtitlesSRT or
+	// SubtitlesASS. Nothing is rendered if empty. Needs Chat.
+	Subtitles       string
+	SubtitleOptions SubtitleOptions
+
+	// MuxSubtitles adds the chat subtitles as a track to mp4, mov and mkv videos. Needs ffmpeg.
+	MuxSubtitles bool
+
+	// BurnChat draws the chat into the video, see OverlayOptions. The video is re-encoded in
+	// software with Encode. Needs Chat and ffmpeg.
+	BurnChat bool
+	Overlay  OverlayOptions
+
+	// Assets caches the emotes and badges of the chat and lists them in Chat.Assets. The burned
+	// in chat draws them. Needs Chat.
+	Assets *AssetCache
+	// EmbedAssets puts the images into the chat file as data URIs instead of linking them.
+	// Needs Assets.
+	EmbedAssets bool
+
+	// ChatHTML writes the chat as a web page into <Filename>.chat.html that plays along with the
+	// video, see WriteHTML. The cached emotes and badges of Assets are embedded. Needs Chat and Assets.
+	ChatHTML bool
+
+	// AllowGaps combines the chunks even if some couldn't be downloaded and writes the
+	// missing parts into <Filename>_gaps.txt. Without it Download returns a *ChunksFailedError.
+	AllowGaps bool
+}
+
+func (o Options) format() string {
+	if o.Format == "" {
+		return FormatMP4
+	}
+	return o.Format
+}
+
+/*
+Returns the path of the output file for opts.Filename in opts.DownloadPath
+*/
+func (o Options) savePath() string {
+	return filepath.Join(o.DownloadPath, o.Filename+"."+o.format())
+}
+
+// DefaultOptions returns the options the command line tool uses if no flags are set.
+func DefaultOptions() Options {
+	return Options{
+		Quality:                SourceQuality,
+		MaxConcurrentDownloads: 5,
+		MaxConcurrentJobs:      2,
+		TryCount:               3,
+		DownloadPath:           ".",
+	}
+}
+
+func (d *Downloader) httpClient() *http.Client {
+	if d.HTTPClient != nil {
+		return d.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (d *Downloader) clientID() string {
+	if d.ClientID != "" {
+		return d.ClientID
+	}
+	return DefaultClientID
+}
+
+func (d *Downloader) ffmpegCmd() string {
+	m := FFmpegMuxer{FFmpegCmd: d.FFmpegCmd}
+	return m.ffmpegCmd()
+}
@@ -0,0 +1,607 @@
+package concat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ArneVogel/concat/hls"
+	"github.com/abiosoft/semaphore"
+)
+
+// chunkJob holds the state shared by all chunk downloads of one vod.
+type chunkJob struct {
+	newpath         string
+	edgecastBaseURL string
+	vodID           string
+	tryCount        int
+	sem             *semaphore.Semaphore
+	progress        chan int
+
+	// manifest is nil for live recordings
+	manifest *manifest
+}
+
+/*
+Fetches link, retrying up to tryCount times on network errors, if reading the body fails or if
+the server responds with a temporary error. The tries are spaced out by the retry policy of the
+Downloader. Set tryCount to 0 for infinite retries
+*/
+func (d *Downloader) fetchWithRetry(ctx context.Context, link string, tryCount int) ([]byte, error) {
+	policy := d.retryPolicy()
+	var lastErr error
+	var wait time.Duration
+
+	for retryCount := 0; retryCount < tryCount || tryCount == 0; retryCount++ {
+		if retryCount > 0 {
+			delay := policy.delay(retryCount-1, wait)
+			d.printDebugf("%d. retry in %v: '%s'\n", retryCount, delay, link)
+			if err := sleep(ctx, delay); err != nil {
+				return nil, err
+			}
+		}
+
+		body, err := d.fetch(ctx, link)
+		if err == nil {
+			return body, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		wait = 0
+		var se *statusError
+		if errors.As(err, &se) {
+			if !isTemporaryStatus(se.statusCode) {
+				return nil, err
+			}
+			wait = se.retryAfter
+		}
+
+		lastErr = err
+		d.printDebug("Could not download", link)
+		d.printDebug(err)
+	}
+
+	return nil, fmt.Errorf("could not download %s after %d tries: %v", link, tryCount, lastErr)
+}
+
+/*
+Fetches link once with chunkTimeout
+*/
+func (d *Downloader) fetch(ctx context.Context, link string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, chunkTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{url: link, statusCode: resp.StatusCode, body: string(body), retryAfter: retryAfter(resp)}
+	}
+	return body, nil
+}
+
+/*
+Server errors, timeouts and rate limits are worth retrying, other status codes won't change
+*/
+func isTemporaryStatus(statusCode int) bool {
+	return statusCode >= 500 || statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests
+}
+
+func chunkPath(newpath string, vodID string, chunkNumber int) string {
+	return newpath + "/" + vodID + "_" + strconv.Itoa(chunkNumber) + chunkFileExtension
+}
+
+/*
+Downloads a chunk into the temp dir. Returns a *FailedChunk if the chunk couldn't be downloaded,
+other errors stop the whole download
+*/
+func (d *Downloader) downloadChunk(ctx context.Context, job *chunkJob, chunkNumber int, chunkName string) error {
+	job.sem.Acquire()
+	defer job.sem.Release()
+
+	if job.progress != nil {
+		defer func() { job.progress <- 1 }()
+	}
+
+	chunkURL := job.edgecastBaseURL + chunkName
+
+	downloadPath := chunkPath(job.newpath, job.vodID, chunkNumber)
+
+	if job.manifest != nil && job.manifest.isDone(chunkNumber) {
+		d.printDebugf("Skipping %s thats already downloaded\n", chunkURL)
+		return nil
+	} else if _, err := os.Stat(downloadPath); job.manifest == nil && !os.IsNotExist(err) {
+		d.printDebugf("Skipping %s thats already downloaded\n", chunkURL)
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d.printDebugf("Downloading: %s\n", chunkURL)
+
+	body, err := d.fetchWithRetry(ctx, chunkURL, job.tryCount)
+
+	// muted chunks are sometimes listed as unmuted but only available muted
+	if isStatus(err, http.StatusForbidden) && strings.HasSuffix(chunkURL, unmutedChunkSuffix) {
+		mutedURL := strings.TrimSuffix(chunkURL, unmutedChunkSuffix) + mutedChunkSuffix
+		d.printDebugf("Trying muted chunk %s\n", mutedURL)
+		body, err = d.fetchWithRetry(ctx, mutedURL, job.tryCount)
+	}
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		d.printDebugf("Could not download chunk '%s': %v\n", chunkURL, err)
+		failed := &FailedChunk{Number: chunkNumber, URI: chunkName, Err: err}
+		if job.manifest != nil {
+			if err := job.manifest.fail(failed); err != nil {
+				return fmt.Errorf("could not save manifest: %v", err)
+			}
+		}
+		return failed
+	}
+
+	if err := writeFileAtomic(downloadPath, body); err != nil {
+		return err
+	}
+	if job.manifest != nil {
+		if err := job.manifest.complete(chunkNumber, body); err != nil {
+			return fmt.Errorf("could not save manifest: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func edgecastBaseURL(m3u8Link string) string {
+	if strings.Contains(m3u8Link, edgecastLinkBaseEndOld) {
+		return m3u8Link[0:strings.Index(m3u8Link, edgecastLinkBaseEndOld)]
+	}
+	if strings.Contains(m3u8Link, edgecastLinkBaseEnd) {
+		return m3u8Link[0:strings.Index(m3u8Link, edgecastLinkBaseEnd)]
+	}
+	return m3u8Link[0 : strings.LastIndex(m3u8Link, "/")+1]
+}
+
+// Download downloads the part of the vod described by opts and combines it into a single mp4.
+func (d *Downloader) Download(ctx context.Context, vodID string, opts Options) error {
+	if _, err := strconv.Atoi(vodID); err != nil {
+		return fmt.Errorf("invalid vod id %q", vodID)
+	}
+	if opts.Start < 0 {
+		return fmt.Errorf("start %v is negative", opts.Start)
+	}
+	if opts.End > 0 && opts.Start > opts.End {
+		return fmt.Errorf("start %v is after end %v", opts.Start, opts.End)
+	}
+	if err := opts.checkFormat(); err != nil {
+		return err
+	}
+	if opts.Filename == "" {
+		opts.Filename = vodID
+	}
+	if opts.MaxConcurrentDownloads <= 0 {
+		opts.MaxConcurrentDownloads = DefaultOptions().MaxConcurrentDownloads
+	}
+
+	archiveEntry := vodArchiveEntry(vodID, opts)
+	if err := d.checkArchive(archiveEntry); err != nil {
+		return err
+	}
+
+	vodSavePath := opts.savePath()
+	newpath := filepath.Join(opts.DownloadPath, "_"+opts.Filename)
+
+	// the manifest of an interrupted run of the same download
+	previous := loadManifest(newpath, vodID, opts)
+
+	_, err := os.Stat(vodSavePath)
+
+	audioPath := opts.audioSavePath(vodSavePath)
+	_, audioErr := os.Stat(audioPath)
+	if previous != nil && previous.Combined && (err == nil || opts.AudioOnly && audioErr == nil) {
+		d.print("Resuming after the chunks were combined")
+		return d.finishVOD(ctx, previous, newpath, vodID, vodSavePath, opts, archiveEntry)
+	}
+
+	if previous != nil && err == nil {
+		d.printf("Removing %s from an interrupted run\n", vodSavePath)
+		if err := os.Remove(vodSavePath); err != nil {
+			return err
+		}
+	} else if err == nil || !os.IsNotExist(err) {
+		return fmt.Errorf("destination file %s already exists", vodSavePath)
+	}
+
+	d.print("Contacting Twitch Server")
+
+	token, err := d.tokenProvider().VODToken(ctx, vodID)
+	if err != nil {
+		return fmt.Errorf("could not get access token: %v", err)
+	}
+
+	d.printDebugf("\nSig: %s, Token: %s\n", token.Signature, token.Value)
+
+	usherAPILink := d.usherAPILink(vodID, token)
+
+	d.printDebugf("\nusherAPILink: %s\n", usherAPILink)
+
+	qualities, err := d.usherQualities(ctx, usherAPILink)
+	if err != nil {
+		return fmt.Errorf("couldn't access usher api: %v", err)
+	}
+
+	d.printDebug(qualities)
+
+	quality, err := d.selectQuality(qualities, opts.qualitySelector())
+	if err != nil {
+		return err
+	}
+	m3u8Link := quality.URL
+
+	baseURL := edgecastBaseURL(m3u8Link)
+
+	d.printDebugf("\nedgecastBaseURL: %s\nm3u8Link: %s\n", baseURL, m3u8Link)
+
+	d.print("Getting Video info")
+
+	m3u8List, err := d.getM3U8List(ctx, m3u8Link)
+	if err != nil {
+		return fmt.Errorf("couldn't download m3u8 list: %v", err)
+	}
+
+	d.printDebugf("\nm3u8List:\n%s\n", m3u8List)
+
+	playlist, err := hls.ParseMedia([]byte(m3u8List))
+	if err != nil {
+		return fmt.Errorf("couldn't read m3u8 list: %v", err)
+	}
+	if len(playlist.Segments) == 0 {
+		return errors.New("the vod has no chunks")
+	}
+
+	fileUris := make([]string, len(playlist.Segments))
+	fileDurations := make([]float64, len(playlist.Segments))
+	for i, segment := range playlist.Segments {
+		fileUris[i] = segment.URI
+		fileDurations[i] = segment.Duration
+	}
+
+	d.printDebugf("\nItems list: %v\n", fileUris)
+
+	vodLength := playlist.Duration()
+
+	// a negative end is relative to the end of the vod
+	if opts.End < 0 {
+		opts.End += secondsToDuration(vodLength)
+		if opts.End <= opts.Start {
+			return fmt.Errorf("end is before start %v, the vod is only %v long", opts.Start, secondsToDuration(vodLength))
+		}
+		d.printf("Downloading until %v\n", opts.End)
+	}
+
+	startSeconds := int(opts.Start / time.Second)
+	endSeconds := int(math.Ceil(opts.End.Seconds()))
+
+	clipDuration := 0
+	if opts.End == 0 {
+		clipDuration = int(vodLength - float64(startSeconds))
+	} else {
+		clipDuration = endSeconds - startSeconds
+	}
+
+	// startRemainder is the seconds between the start of the first chunk and opts.Start
+	startChunk, chunkCount, startRemainder := calcStartChunkAndChunkCount(fileDurations, startSeconds, clipDuration)
+
+	if startChunk+chunkCount > len(fileUris) {
+		chunkCount = len(fileUris) - startChunk
+	}
+	if chunkCount <= 0 {
+		return fmt.Errorf("start %v is after the end of the vod", opts.Start)
+	}
+
+	d.printDebugf("\nchunkCount: %v\nstartChunk: %v\n", chunkCount, startChunk)
+
+	err = os.MkdirAll(newpath, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("could not create directory: %v", err)
+	}
+	d.printf("Created temp dir: %s\n", newpath)
+
+	offset := 0.0
+	for i := 0; i < startChunk; i++ {
+		offset += fileDurations[i]
+	}
+
+	m := newManifest(newpath, vodID, opts)
+	for i := startChunk; i < (startChunk + chunkCount); i++ {
+		m.Chunks = append(m.Chunks, manifestChunk{Number: i, URI: fileUris[i], Offset: offset, Duration: fileDurations[i], State: chunkPending})
+		offset += fileDurations[i]
+	}
+	if resumed := m.resume(previous, func(number int) string { return chunkPath(newpath, vodID, number) }); resumed > 0 {
+		d.printf("Resuming download, %d of %d chunks are already downloaded\n", resumed, chunkCount)
+	}
+	if err := m.saveLocked(); err != nil {
+		return fmt.Errorf("could not save manifest: %v", err)
+	}
+
+	d.print("Starting Download")
+
+	// batches share one semaphore across all their jobs
+	sem := d.sem
+	if sem == nil {
+		sem = semaphore.New(opts.MaxConcurrentDownloads)
+	}
+
+	job := &chunkJob{
+		newpath:         newpath,
+		edgecastBaseURL: baseURL,
+		vodID:           vodID,
+		tryCount:        opts.TryCount,
+		sem:             sem,
+		progress:        make(chan int),
+		manifest:        m,
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(chunkCount)
+
+	errs := make(chan error, chunkCount)
+	var failedMu sync.Mutex
+	var failed []FailedChunk
+	for i := startChunk; i < (startChunk + chunkCount); i++ {
+		s := i
+		n := fileUris[i]
+		go func() {
+			defer wg.Done()
+			err := d.downloadChunk(ctx, job, s, n)
+			var f *FailedChunk
+			if errors.As(err, &f) {
+				failedMu.Lock()
+				failed = append(failed, *f)
+				failedMu.Unlock()
+			} else if err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	doneChunks := 0
+	loadingBarLength := 20.0
+	for waiting := true; waiting; {
+		select {
+		case n := <-job.progress:
+			doneChunks += n
+
+			progress := float64(doneChunks) / float64(chunkCount)
+			d.printf(
+				"\r[%s%s] %d/%d",
+				strings.Repeat("█", int(progress*loadingBarLength)),
+				strings.Repeat(" ", int(loadingBarLength-progress*loadingBarLength)),
+				doneChunks,
+				chunkCount,
+			)
+		case <-done:
+			waiting = false
+		}
+	}
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	chunks := make([]int, 0, chunkCount)
+	if len(failed) > 0 {
+		sort.Slice(failed, func(i, j int) bool { return failed[i].Number < failed[j].Number })
+		if !opts.AllowGaps {
+			return &ChunksFailedError{Chunks: failed, TempDir: newpath}
+		}
+		d.printf("\nSkipping %d chunks that couldn't be downloaded\n", len(failed))
+		for _, c := range m.Chunks {
+			if c.State != chunkFailed {
+				chunks = append(chunks, c.Number)
+			}
+		}
+		if len(chunks) == 0 {
+			return &ChunksFailedError{Chunks: failed, TempDir: newpath}
+		}
+	} else {
+		for _, c := range m.Chunks {
+			chunks = append(chunks, c.Number)
+		}
+	}
+
+	// the chat subtitles and the overlay go into the video
+	if opts.Chat {
+		d.print("")
+		if _, err := d.saveChat(ctx, vodID, vodSavePath, opts, m); err != nil {
+			return fmt.Errorf("%v, the chunks are kept in %s to resume later", err, newpath)
+		}
+	}
+
+	d.print("\nCombining parts")
+
+	// startSeconds drops the fraction of a second of opts.Start
+	trim := outputCut(m, startRemainder+opts.Start.Seconds()-float64(startSeconds), opts)
+	if err := d.combine(ctx, newpath, chunks, vodID, vodSavePath, opts, trim, opts.directAudio(quality)); err != nil {
+		return fmt.Errorf("could not combine the chunks, they are kept in %s to resume later: %v", newpath, err)
+	}
+	if !opts.AudioOnly {
+		d.checkOutput(ctx, vodSavePath, expectedDuration(m, trim))
+	}
+
+	if len(failed) > 0 {
+		reportPath := gapReportPath(vodSavePath)
+		if err := writeGapReport(reportPath, vodID, failed, m.Chunks[0].Offset+trim.start); err != nil {
+			return fmt.Errorf("could not write gap report: %v", err)
+		}
+		d.printf("Wrote the missing parts to %s\n", reportPath)
+	}
+
+	if err := m.setCombined(); err != nil {
+		return fmt.Errorf("could not save manifest: %v", err)
+	}
+
+	return d.finishVOD(ctx, m, newpath, vodID, vodSavePath, opts, archiveEntry)
+}
+
+/*
+Returns the part of the combined chunks that matches opts.Start and opts.End. startRemainder is the
+position of opts.Start in the first chunk. Failed chunks are left out of the combined chunks, so
+they shorten the cut
+*/
+func outputCut(m *manifest, startRemainder float64, opts Options) cut {
+	c := cut{start: startRemainder}
+	if opts.End != 0 {
+		c.duration = (opts.End - opts.Start).Seconds()
+	}
+
+	for i, chunk := range m.Chunks {
+		if chunk.State != chunkFailed {
+			continue
+		}
+		missing := chunk.Duration
+		if i == 0 {
+			// the output starts at the next chunk, the skipped part of the first chunk is lost anyway
+			missing -= c.start
+			c.start = 0
+		}
+		if c.duration > 0 {
+			c.duration -= missing
+			if c.duration <= 0 {
+				c.duration = 0.001
+			}
+		}
+	}
+	return c
+}
+
+/*
+Returns how long the output of the chunks in m cut by c should be
+*/
+func expectedDuration(m *manifest, c cut) time.Duration {
+	if c.duration > 0 {
+		return secondsToDuration(c.duration)
+	}
+	total := -c.start
+	for _, chunk := range m.Chunks {
+		if chunk.State != chunkFailed {
+			total += chunk.Duration
+		}
+	}
+	return secondsToDuration(total)
+}
+
+/*
+Downloads the chat if opts asks for it and it is missing, then finishes the download. The chunks
+are kept if the chat fails, so the next run resumes after combining
+*/
+func (d *Downloader) finishVOD(ctx context.Context, m *manifest, newpath string, vodID string, vodSavePath string, opts Options, archiveEntry ArchiveEntry) error {
+	files := []string{vodSavePath, opts.audioSavePath(vodSavePath)}
+	if opts.Chat {
+		chatPath := chatSavePath(vodSavePath)
+		// the chat is saved before combining, unless the chunks were combined by an older run
+		if _, err := os.Stat(chatPath); err != nil {
+			if _, err := d.saveChat(ctx, vodID, vodSavePath, opts, m); err != nil {
+				return err
+			}
+		}
+		files = append(files, chatPath)
+	}
+	return d.finishDownload(m, newpath, vodID, archiveEntry, files...)
+}
+
+/*
+Deletes the chunks and the temp dir of a combined download and records it in the archive
+*/
+func (d *Downloader) finishDownload(m *manifest, newpath string, vodID string, archiveEntry ArchiveEntry, files ...string) error {
+	d.print("Deleting chunks")
+
+	for _, c := range m.Chunks {
+		err := os.Remove(chunkPath(newpath, vodID, c.Number))
+		if err != nil && !os.IsNotExist(err) {
+			d.print("Could not delete all chunks, try manually deleting them", err)
+		}
+	}
+	m.remove()
+
+	d.print("Deleting temp dir")
+
+	os.Remove(newpath)
+
+	if err := d.recordArchive(archiveEntry, files...); err != nil {
+		return err
+	}
+
+	d.print("All done!")
+	return nil
+}
+
+func calcStartChunkAndChunkCount(chunkDurations []float64, startSeconds int, clipDuration int) (int, int, float64) {
+	startChunk := 0
+	chunkCount := 0
+	startSecondsRemainder := float64(0)
+
+	cumulatedDuration := 0.0
+	for chunk, chunkDuration := range chunkDurations {
+		cumulatedDuration += chunkDuration
+
+		if cumulatedDuration > float64(startSeconds) {
+			startChunk = chunk
+			startSecondsRemainder = float64(startSeconds) - (cumulatedDuration - chunkDuration)
+			break
+		}
+	}
+
+	cumulatedDuration = 0.0
+	minChunkedClipDuration := float64(clipDuration) + startSecondsRemainder
+	for chunk := startChunk; chunk < len(chunkDurations); chunk++ {
+		cumulatedDuration += chunkDurations[chunk]
+
+		if cumulatedDuration > minChunkedClipDuration {
+			chunkCount = chunk - startChunk + 1
+			break
+		}
+	}
+
+	if chunkCount == 0 {
+		chunkCount = len(chunkDurations) - startChunk
+	}
+
+	return startChunk, chunkCount, startSecondsRemainder
+}
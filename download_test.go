@@ -0,0 +1,610 @@
+package concat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const mediaPlaylistTemplate string = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+#ID3-EQUIV-TDTG:2020-06-01T10:00:00
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-TWITCH-ELAPSED-SECS:0.000
+#EXT-X-TWITCH-TOTAL-SECS:%[1]d.000
+%[2]s#EXT-X-ENDLIST
+`
+
+// fakeChunk returns the content of a chunk, a single mpeg-ts packet
+func fakeChunk(number int) []byte {
+	chunk := bytes.Repeat([]byte{0xff}, tsPacketSize)
+	chunk[0] = tsSyncByte
+	copy(chunk[4:], fmt.Sprintf("chunk %d", number))
+	return chunk
+}
+
+// vodServer is a stand-in for the GQL api, usher and the edgecast servers of one vod
+type vodServer struct {
+	*httptest.Server
+	chunks int
+
+	mu        sync.Mutex
+	requested map[string]int
+	// fail returns the status code for a chunk request, 0 for success
+	fail func(path string, try int) int
+	// chunk returns the content of a chunk, fakeChunk if nil
+	chunk func(number int) []byte
+	// usher is the master playlist with %[1]s for the server url, usherResponse if empty
+	usher string
+	// gql answers the GQL requests other than the access token
+	gql func(req gqlRequest) (interface{}, error)
+}
+
+func newVODServer(t *testing.T, chunks int) *vodServer {
+	s := &vodServer{chunks: chunks, requested: make(map[string]int)}
+	token := playbackAccessTokenHandler(t)
+	gql := gqlHandler(t, func(req gqlRequest) (interface{}, error) {
+		if s.gql != nil && req.OperationName != "PlaybackAccessToken" {
+			return s.gql(req)
+		}
+		return token(req)
+	})
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requested[r.URL.Path]++
+		try := s.requested[r.URL.Path]
+		s.mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/gql":
+			gql.ServeHTTP(w, r)
+		case r.URL.Path == "/vod/"+vodString:
+			usher := s.usher
+			if usher == "" {
+				usher = usherResponse
+			}
+			fmt.Fprintf(w, usher, s.URL)
+		case strings.HasSuffix(r.URL.Path, "/index-dvr.m3u8"):
+			var segments strings.Builder
+			for i := 0; i < s.chunks; i++ {
+				fmt.Fprintf(&segments, "#EXTINF:10.000,\n%d.ts\n", i)
+			}
+			fmt.Fprintf(w, mediaPlaylistTemplate, s.chunks*10, segments.String())
+		case strings.HasSuffix(r.URL.Path, ".ts"):
+			var number int
+			fmt.Sscanf(filepath.Base(r.URL.Path), "%d.ts", &number)
+			if s.fail != nil {
+				if status := s.fail(r.URL.Path, try); status != 0 {
+					http.Error(w, "failed", status)
+					return
+				}
+			}
+			if s.chunk != nil {
+				w.Write(s.chunk(number))
+				return
+			}
+			w.Write(fakeChunk(number))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return s
+}
+
+func (s *vodServer) requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requested[path]
+}
+
+func (s *vodServer) chunkPath(number int) string {
+	return fmt.Sprintf("/903cba256ea3055674be_reckful_26660278144_734937575/chunked/%d.ts", number)
+}
+
+// fakeFFmpeg writes a script that behaves like ffmpeg for the concat demuxer: it writes all
+// input files listed in the concat file into the output file, which is the last argument.
+// The arguments of every run are appended to ffmpeg.args in dir.
+func fakeFFmpeg(t *testing.T, dir string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg needs a posix shell")
+	}
+	script := `#!/bin/sh
+echo "$@" >> "$0.args"
+for last; do :; done
+list=""
+while [ $# -gt 0 ]; do
+	if [ "$1" = "-i" ]; then list="$2"; fi
+	shift
+done
+if [ -z "$list" ]; then exit 1; fi
+: > "$last"
+sed -n "s/^file '\(.*\)'$/\1/p" "$list" | while read -r f; do cat "$f" >> "$last"; done
+`
+	path := filepath.Join(dir, "ffmpeg")
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// ffmpegRuns returns the arguments of all runs of the fake ffmpeg in dir
+func ffmpegRuns(t *testing.T, dir string) []string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "ffmpeg.args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+// newTestDownloader returns a Downloader that only talks to server and uses a fake ffmpeg
+func newTestDownloader(t *testing.T, server *vodServer, dir string) *Downloader {
+	return &Downloader{
+		GQLEndpoint:  server.URL + "/gql",
+		UsherBaseURL: server.URL,
+		FFmpegCmd:    fakeFFmpeg(t, dir),
+		Retry:        RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond},
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "concat_download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestCalcStartChunkAndChunkCount(t *testing.T) {
+	durations := []float64{10, 10, 10, 10, 10}
+	tests := []struct {
+		start, duration        int
+		startChunk, chunkCount int
+		startRemainder         float64
+	}{
+		{0, 50, 0, 5, 0},
+		{0, 15, 0, 2, 0},
+		{25, 10, 2, 2, 5},
+		{40, 100, 4, 1, 0},
+	}
+	for _, tt := range tests {
+		startChunk, chunkCount, remainder := calcStartChunkAndChunkCount(durations, tt.start, tt.duration)
+		if startChunk != tt.startChunk || chunkCount != tt.chunkCount || remainder != tt.startRemainder {
+			t.Errorf("calcStartChunkAndChunkCount(%d, %d) = %d, %d, %v, want %d, %d, %v", tt.start, tt.duration,
+				startChunk, chunkCount, remainder, tt.startChunk, tt.chunkCount, tt.startRemainder)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	server := newVODServer(t, 6)
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d := newTestDownloader(t, server, dir)
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	opts.Start = 15 * time.Second
+	opts.End = 35 * time.Second
+
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, vodString+".mp4"))
+	want := append(append(fakeChunk(1), fakeChunk(2)...), fakeChunk(3)...)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("got %d bytes, %v, want chunks 1 to 3", len(got), err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "_"+vodString)); !os.IsNotExist(err) {
+		t.Errorf("temp dir wasn't deleted: %v", err)
+	}
+
+	runs := ffmpegRuns(t, dir)
+	if len(runs) != 1 || !strings.HasPrefix(runs[0], "-ss 5.000 -f concat") || !strings.Contains(runs[0], " -t 20.000 -c copy ") {
+		t.Errorf("ffmpeg doesn't cut at start and end: %q", runs)
+	}
+
+	if err := d.Download(context.Background(), vodString, opts); err == nil {
+		t.Errorf("expected error for existing destination file")
+	}
+}
+
+func TestDownloadSmartCut(t *testing.T) {
+	server := newVODServer(t, 6)
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// keyframes every 2 seconds of high profile h264 video with stereo aac audio
+	ffprobe := filepath.Join(dir, "ffprobe")
+	script := `#!/bin/sh
+case "$*" in
+*stream=*) echo '{"streams": [{"codec_type": "video", "codec_name": "h264", "profile": "High", "pix_fmt": "yuv420p", "level": 42},
+	{"codec_type": "audio", "codec_name": "aac", "profile": "LC", "sample_rate": "48000", "channels": 2}]}' ;;
+*) for i in $(seq 0 2 30); do echo "$i.000000"; done ;;
+esac
+`
+	if err := ioutil.WriteFile(ffprobe, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newTestDownloader(t, server, dir)
+	d.FFprobeCmd = ffprobe
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	opts.Start = 15*time.Second + 500*time.Millisecond
+	opts.End = 35 * time.Second
+	opts.SmartCut = true
+
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	runs := ffmpegRuns(t, dir)
+	want := []string{
+		`^-ss 5\.500 -f concat -safe 0 -i \S+ -t 0\.500 -c:v libx264 -profile:v high -level:v 4\.2 -pix_fmt yuv420p .* -c:a aac -ar 48000 -ac 2 -f mpegts \S+head\.ts$`,
+		`^-ss 6\.000 -f concat -safe 0 -i \S+ -t 18\.000 -c copy -f mpegts \S+middle\.ts$`,
+		`^-ss 24\.000 -f concat -safe 0 -i \S+ -t 1\.000 -c:v libx264 .* -f mpegts \S+tail\.ts$`,
+		`^-f concat -safe 0 -i \S+parts\.txt -c copy .*\.mp4$`,
+	}
+	if len(runs) != len(want) {
+		t.Fatalf("got %d ffmpeg runs, want %d: %q", len(runs), len(want), runs)
+	}
+	for i, w := range want {
+		if !regexp.MustCompile(w).MatchString(runs[i]) {
+			t.Errorf("run %d: got %q, want %q", i, runs[i], w)
+		}
+	}
+}
+
+func TestDownloadEncode(t *testing.T) {
+	server := newVODServer(t, 6)
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d := newTestDownloader(t, server, dir)
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	opts.Start = 15*time.Second + 500*time.Millisecond
+	opts.End = 35 * time.Second
+	opts.Format = FormatMKV
+	opts.Encode = EncodeOptions{Codec: CodecH265, CRF: 28, Preset: "slow", Height: 720}
+	// re-encoding cuts exactly, there is nothing left for the smart cut to do
+	opts.SmartCut = true
+
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	runs := ffmpegRuns(t, dir)
+	want := `^-ss 5\.500 -f concat -safe 0 -i \S+ -t 19\.500 -c copy -c:v libx265 -crf 28 -preset slow -vf scale=-2:720 -bsf:a aac_adtstoasc -fflags \+genpts \S+\.mkv$`
+	if len(runs) != 1 || !regexp.MustCompile(want).MatchString(runs[0]) {
+		t.Errorf("got ffmpeg runs %q, want %q", runs, want)
+	}
+
+	opts.Format = "avi"
+	if err := d.Download(context.Background(), vodString, opts); err == nil || !strings.Contains(err.Error(), "unknown format") {
+		t.Errorf("expected error for unknown format, got %v", err)
+	}
+}
+
+func TestOutputArgs(t *testing.T) {
+	tests := []struct {
+		output string
+		encode EncodeOptions
+		want   string
+	}{
+		{"vod.mp4", EncodeOptions{}, "-c copy -bsf:a aac_adtstoasc -fflags +genpts vod.mp4"},
+		{"vod.ts", EncodeOptions{}, "-c copy -fflags +genpts vod.ts"},
+		{"vod.mov", EncodeOptions{CRF: 23}, "-c copy -c:v libx264 -crf 23 -bsf:a aac_adtstoasc -fflags +genpts vod.mov"},
+		{"vod.mp4", EncodeOptions{Codec: CodecH265}, "-c copy -c:v libx265 -tag:v hvc1 -bsf:a aac_adtstoasc -fflags +genpts vod.mp4"},
+		{"vod.ts", EncodeOptions{Codec: CodecAV1, Preset: "8", Height: 480}, "-c copy -c:v libsvtav1 -preset 8 -vf scale=-2:480 -fflags +genpts vod.ts"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(outputArgs(tt.output, CombineOptions{Encode: tt.encode}), " "); got != tt.want {
+			t.Errorf("outputArgs(%q, %+v) = %q, want %q", tt.output, tt.encode, got, tt.want)
+		}
+	}
+
+	want := "-c copy -map 0:v? -map 0:a? -map 1:s -metadata:s:s:0 title=Chat -c:s mov_text -bsf:a aac_adtstoasc -fflags +genpts vod.mp4"
+	if got := strings.Join(outputArgs("vod.mp4", CombineOptions{Subtitles: "vod.chat.srt"}), " "); got != want {
+		t.Errorf("outputArgs with subtitles = %q, want %q", got, want)
+	}
+
+	want = "-c copy -c:v libx264 -filter_complex [0:v]scale=-2:720[scaled];[scaled][2:v]overlay=x=main_w-overlay_w-20:y=20[v] -map [v] -map 0:a? -map 1:s -metadata:s:s:0 title=Chat -bsf:a aac_adtstoasc -fflags +genpts vod.mkv"
+	opts := CombineOptions{Encode: EncodeOptions{Height: 720}, Subtitles: "vod.chat.ass", Overlay: "chat_overlay.txt", OverlayPosition: ChatTopRight}
+	if got := strings.Join(outputArgs("vod.mkv", opts), " "); got != want {
+		t.Errorf("outputArgs with overlay = %q, want %q", got, want)
+	}
+}
+
+// usherAudioResponse is usherResponse with an audio only rendition
+const usherAudioResponse = usherResponse + `#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio_only",NAME="Audio Only",AUTOSELECT=NO,DEFAULT=NO
+#EXT-X-STREAM-INF:PROGRAM-ID=1,BANDWIDTH=160000,CODECS="mp4a.40.2",VIDEO="audio_only"
+%[1]s/903cba256ea3055674be_reckful_26660278144_734937575/audio_only/index-dvr.m3u8
+`
+
+func TestDownloadAudioOnly(t *testing.T) {
+	server := newVODServer(t, 3)
+	server.usher = usherAudioResponse
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d := newTestDownloader(t, server, dir)
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	opts.AudioOnly = true
+	opts.AudioFormat = AudioFormatM4A
+
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// the chunks of the audio only rendition are copied straight into the m4a
+	got, err := ioutil.ReadFile(filepath.Join(dir, vodString+".m4a"))
+	want := append(append(fakeChunk(0), fakeChunk(1)...), fakeChunk(2)...)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("got %d bytes, %v, want the audio chunks", len(got), err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, vodString+".mp4")); !os.IsNotExist(err) {
+		t.Errorf("a video was written: %v", err)
+	}
+	if n := server.requests(server.chunkPath(0)); n != 0 {
+		t.Errorf("the video chunks were requested %d times", n)
+	}
+	if n := server.requests(strings.Replace(server.chunkPath(0), "chunked", "audio_only", 1)); n != 1 {
+		t.Errorf("the audio chunks were requested %d times", n)
+	}
+	runs := ffmpegRuns(t, dir)
+	if len(runs) != 1 || !strings.HasSuffix(runs[0], " -c copy -bsf:a aac_adtstoasc -fflags +genpts "+filepath.Join(dir, vodString+".m4a")) {
+		t.Errorf("unexpected ffmpeg runs %q", runs)
+	}
+
+	// other formats convert the combined audio
+	opts.Filename = "mp3"
+	opts.AudioFormat = AudioFormatMP3
+	opts.AudioBitrate = 192
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+	runs = ffmpegRuns(t, dir)[1:]
+	if len(runs) != 2 || runs[1] != fmt.Sprintf("-i %s -vn -sn -c:a libmp3lame -b:a 192k %s", filepath.Join(dir, "mp3.mp4"), filepath.Join(dir, "mp3.mp3")) {
+		t.Errorf("unexpected ffmpeg runs %q", runs)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "mp3.mp4")); !os.IsNotExist(err) {
+		t.Errorf("the combined audio wasn't removed: %v", err)
+	}
+}
+
+func TestAudioArgs(t *testing.T) {
+	tests := []struct {
+		output  string
+		bitrate int
+		want    string
+	}{
+		{"a.m4a", 128, "-vn -sn -c:a copy -bsf:a aac_adtstoasc a.m4a"},
+		{"a.mp3", 0, "-vn -sn -c:a libmp3lame a.mp3"},
+		{"a.mp3", 320, "-vn -sn -c:a libmp3lame -b:a 320k a.mp3"},
+		{"a.opus", 96, "-vn -sn -c:a libopus -b:a 96k a.opus"},
+		{"a.flac", 320, "-vn -sn -c:a flac a.flac"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(audioArgs(tt.output, AudioOptions{Bitrate: tt.bitrate}), " "); got != tt.want {
+			t.Errorf("audioArgs(%q, %d) = %q, want %q", tt.output, tt.bitrate, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadRetriesTemporaryErrors(t *testing.T) {
+	server := newVODServer(t, 3)
+	defer server.Close()
+	server.fail = func(path string, try int) int {
+		if path == server.chunkPath(1) && try == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d := newTestDownloader(t, server, dir)
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.requests(server.chunkPath(1)); got != 2 {
+		t.Errorf("chunk 1 was requested %d times, want 2", got)
+	}
+}
+
+func TestDownloadFailedChunks(t *testing.T) {
+	server := newVODServer(t, 4)
+	defer server.Close()
+	broken := true
+	server.fail = func(path string, try int) int {
+		if path == server.chunkPath(2) && broken {
+			return http.StatusNotFound
+		}
+		return 0
+	}
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d := newTestDownloader(t, server, dir)
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+
+	err := d.Download(context.Background(), vodString, opts)
+	failedErr, ok := err.(*ChunksFailedError)
+	if !ok || len(failedErr.Chunks) != 1 {
+		t.Fatalf("expected one failed chunk, got %v", err)
+	}
+	if c := failedErr.Chunks[0]; c.Number != 2 || c.Start != 20*time.Second || c.Duration != 10*time.Second {
+		t.Errorf("unexpected failed chunk %+v", c)
+	}
+	if got := server.requests(server.chunkPath(2)); got != 1 {
+		t.Errorf("chunk 2 was requested %d times, a 404 shouldn't be retried", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, vodString+".mp4")); !os.IsNotExist(err) {
+		t.Errorf("output with a gap was written: %v", err)
+	}
+
+	// running the download again only fetches the failed chunk
+	broken = false
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{1, 1, 2, 1} {
+		if got := server.requests(server.chunkPath(i)); got != want {
+			t.Errorf("chunk %d was requested %d times, want %d", i, got, want)
+		}
+	}
+}
+
+func TestDownloadAllowGaps(t *testing.T) {
+	for _, format := range []string{FormatMP4, FormatMKV, FormatTS} {
+		t.Run(format, func(t *testing.T) {
+			server := newVODServer(t, 4)
+			defer server.Close()
+			server.fail = func(path string, try int) int {
+				if path == server.chunkPath(2) {
+					return http.StatusForbidden
+				}
+				return 0
+			}
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+
+			d := newTestDownloader(t, server, dir)
+			opts := DefaultOptions()
+			opts.DownloadPath = dir
+			opts.Start = 10 * time.Second
+			opts.AllowGaps = true
+			opts.Format = format
+
+			if err := d.Download(context.Background(), vodString, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ioutil.ReadFile(filepath.Join(dir, vodString+"."+format))
+			want := append(fakeChunk(1), fakeChunk(3)...)
+			if err != nil || !bytes.Equal(got, want) {
+				t.Errorf("got %d bytes, %v, want chunks 1 and 3", len(got), err)
+			}
+
+			// the report replaces the extension of every format
+			report, err := ioutil.ReadFile(filepath.Join(dir, vodString+"_gaps.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(report), "0:00:20.000\t0:00:30.000\t0:00:10.000\t2.ts\t") {
+				t.Errorf("gap report doesn't list chunk 2:\n%s", report)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "_"+vodString)); !os.IsNotExist(err) {
+				t.Errorf("temp dir wasn't deleted: %v", err)
+			}
+		})
+	}
+}
+
+func TestGapReportStartRemainder(t *testing.T) {
+	server := newVODServer(t, 4)
+	defer server.Close()
+	server.fail = func(path string, try int) int {
+		if path == server.chunkPath(2) {
+			return http.StatusForbidden
+		}
+		return 0
+	}
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d := newTestDownloader(t, server, dir)
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	opts.Start = 15 * time.Second
+	opts.AllowGaps = true
+
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// the output starts 5 seconds into chunk 1, so chunk 2 is missing 5 seconds into it
+	report, err := ioutil.ReadFile(filepath.Join(dir, vodString+"_gaps.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(report), "0:00:20.000\t0:00:30.000\t0:00:05.000\t2.ts\t") {
+		t.Errorf("gap report doesn't list chunk 2 at the position in the output:\n%s", report)
+	}
+}
+
+func TestOutputCut(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Start = 15 * time.Second
+	opts.End = 45 * time.Second
+	m := &manifest{}
+	for i := 1; i < 5; i++ {
+		m.Chunks = append(m.Chunks, manifestChunk{Number: i, Offset: float64(i * 10), Duration: 10, State: chunkCompleted})
+	}
+
+	if c := outputCut(m, 5, opts); c != (cut{start: 5, duration: 30}) {
+		t.Errorf("without failed chunks got %+v", c)
+	}
+
+	m.Chunks[0].State = chunkFailed
+	m.Chunks[2].State = chunkFailed
+	if c := outputCut(m, 5, opts); c != (cut{start: 0, duration: 15}) {
+		t.Errorf("with failed chunks got %+v", c)
+	}
+}
+
+func TestDownloadRelativeEnd(t *testing.T) {
+	server := newVODServer(t, 6)
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d := newTestDownloader(t, server, dir)
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	opts.Start = 30 * time.Second
+	opts.End = -15 * time.Second
+
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, vodString+".mp4"))
+	want := append(fakeChunk(3), fakeChunk(4)...)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("got %d bytes, %v, want chunks 3 and 4", len(got), err)
+	}
+	if runs := ffmpegRuns(t, dir); !strings.Contains(runs[0], " -t 15.000 ") {
+		t.Errorf("ffmpeg doesn't stop 15 seconds before the end: %q", runs)
+	}
+
+	opts.Filename = "too_short"
+	opts.End = -time.Minute
+	if err := d.Download(context.Background(), vodString, opts); err == nil {
+		t.Errorf("expected error for an end before the start")
+	}
+}
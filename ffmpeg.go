@@ -0,0 +1,341 @@
+package concat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// FFmpegMuxer is a Muxer that runs ffmpeg and ffprobe.
+type FFmpegMuxer struct {
+	// FFmpegCmd and FFprobeCmd are the binaries that are run.
+	// Default to ffmpeg and ffprobe (ffmpeg.exe and ffprobe.exe on windows).
+	FFmpegCmd  string
+	FFprobeCmd string
+
+	// Output receives progress messages. Nothing is printed if nil.
+	Output io.Writer
+
+	// Debug prints the commands that are run to Output.
+	Debug bool
+}
+
+func (m *FFmpegMuxer) ffmpegCmd() string {
+	if m.FFmpegCmd != "" {
+		return m.FFmpegCmd
+	}
+	if runtime.GOOS == "windows" {
+		return `ffmpeg.exe`
+	}
+	return `ffmpeg`
+}
+
+func (m *FFmpegMuxer) ffprobeCmd() string {
+	if m.FFprobeCmd != "" {
+		return m.FFprobeCmd
+	}
+	if runtime.GOOS == "windows" {
+		return `ffprobe.exe`
+	}
+	return `ffprobe`
+}
+
+func (m *FFmpegMuxer) print(msg ...interface{}) {
+	if m.Output != nil {
+		fmt.Fprintln(m.Output, msg...)
+	}
+}
+
+func (m *FFmpegMuxer) printf(format string, args ...interface{}) {
+	if m.Output != nil {
+		fmt.Fprintf(m.Output, format, args...)
+	}
+}
+
+func (m *FFmpegMuxer) printDebugf(format string, args ...interface{}) {
+	if m.Debug {
+		m.printf(format, args...)
+	}
+}
+
+/*
+Writes a list of inputs for the concat demuxer next to the first input
+*/
+func createConcatFile(inputs []string) (string, error) {
+	tempFile, err := ioutil.TempFile(filepath.Dir(inputs[0]), "concat_list_")
+	if err != nil {
+		return "", err
+	}
+	defer tempFile.Close()
+
+	var list strings.Builder
+	for _, input := range inputs {
+		filePath, _ := filepath.Abs(input)
+		list.WriteString("file '" + filePath + "'\n")
+	}
+
+	if _, err := tempFile.WriteString(list.String()); err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+	return tempFile.Name(), nil
+}
+
+// cut is the part of the combined chunks that is kept in the output, in seconds.
+type cut struct {
+	start float64
+	// duration of 0 keeps everything after start
+	duration float64
+}
+
+func (c cut) isZero() bool {
+	return c.start <= 0 && c.duration <= 0
+}
+
+/*
+Returns the ffmpeg input and output options that seek to c.start and stop after c.duration
+*/
+func (c cut) args(input []string, output ...string) []string {
+	var args []string
+	if c.start > 0 {
+		args = append(args, "-ss", formatSeconds(c.start))
+	}
+	args = append(args, input...)
+	if c.duration > 0 {
+		args = append(args, "-t", formatSeconds(c.duration))
+	}
+	return append(args, output...)
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
+
+func concatInput(listPath string) []string {
+	return []string{"-f", "concat", "-safe", "0", "-i", listPath}
+}
+
+func subtitleInput(subtitles string) []string {
+	if subtitles == "" {
+		return nil
+	}
+	return []string{"-i", subtitles}
+}
+
+/*
+Returns the ffmpeg output options for output. The video is re-encoded if opts.Encode is enabled or
+there is an overlay, the audio is always copied. The AAC bitstream filter isn't needed for transport
+streams, they keep the ADTS headers. The inputs are the chunks, then the subtitles and the overlay
+if opts has them
+*/
+func outputArgs(output string, opts CombineOptions) []string {
+	format := strings.TrimPrefix(filepath.Ext(output), ".")
+	e := opts.Encode
+	args := []string{"-c", "copy"}
+	if e.enabled() || opts.Overlay != "" {
+		args = append(args, "-c:v", e.codec())
+		if e.CRF > 0 {
+			args = append(args, "-crf", strconv.Itoa(e.CRF))
+		}
+		if e.Preset != "" {
+			args = append(args, "-preset", e.Preset)
+		}
+		if e.Height > 0 && opts.Overlay == "" {
+			// -2 keeps the aspect ratio with an even width, the encoders need that
+			args = append(args, "-vf", fmt.Sprintf("scale=-2:%d", e.Height))
+		}
+		// players of apple only take HEVC in mp4 and mov with the hvc1 tag
+		if e.codec() == CodecH265 && (format == FormatMP4 || format == FormatMOV) {
+			args = append(args, "-tag:v", "hvc1")
+		}
+	}
+
+	video := "0:v?"
+	if opts.Overlay != "" {
+		input := 1
+		if opts.Subtitles != "" {
+			input = 2
+		}
+		args = append(args, "-filter_complex", overlayFilter(input, e.Height, opts.OverlayPosition))
+		video = "[v]"
+	}
+	if opts.Subtitles != "" || opts.Overlay != "" {
+		args = append(args, "-map", video, "-map", "0:a?")
+	}
+	if opts.Subtitles != "" {
+		args = append(args, "-map", "1:s", "-metadata:s:s:0", "title=Chat")
+		// mp4 and mov only take their own text subtitles, mkv takes srt and ass as they are
+		if format == FormatMP4 || format == FormatMOV {
+			args = append(args, "-c:s", "mov_text")
+		}
+	}
+	if format != FormatTS {
+		args = append(args, "-bsf:a", "aac_adtstoasc")
+	}
+	return append(args, "-fflags", "+genpts", output)
+}
+
+/*
+Returns the filter that draws the images of input over the video in the corner position. The video
+is scaled to height first, so the size of the overlay is in pixels of the output
+*/
+func overlayFilter(input int, height int, position string) string {
+	x, y := strconv.Itoa(overlayMargin), strconv.Itoa(overlayMargin)
+	if position == ChatBottomRight || position == ChatTopRight {
+		x = fmt.Sprintf("main_w-overlay_w-%d", overlayMargin)
+	}
+	if position == ChatBottomLeft || position == ChatBottomRight || position == "" {
+		y = fmt.Sprintf("main_h-overlay_h-%d", overlayMargin)
+	}
+
+	video := "[0:v]"
+	filter := ""
+	if height > 0 {
+		filter = fmt.Sprintf("[0:v]scale=-2:%d[scaled];", height)
+		video = "[scaled]"
+	}
+	return filter + fmt.Sprintf("%s[%d:v]overlay=x=%s:y=%s[v]", video, input, x, y)
+}
+
+// Combine joins the inputs with the concat demuxer of ffmpeg. The streams are copied unless
+// opts.Encode or opts.Overlay is set, then the cut is exact without SmartCut. Only the start and
+// end of a smart cut are re-encoded, if that fails the output is cut at keyframes.
+func (m *FFmpegMuxer) Combine(ctx context.Context, inputs []string, output string, opts CombineOptions) error {
+	if len(inputs) == 0 {
+		return errors.New("nothing to combine")
+	}
+	listPath, err := createConcatFile(inputs)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(listPath)
+
+	c := cut{start: opts.Start.Seconds(), duration: opts.Duration.Seconds()}
+	if opts.SmartCut && !c.isZero() && !opts.Encode.enabled() && opts.Overlay == "" {
+		err := m.smartCut(ctx, filepath.Dir(listPath), listPath, output, c, opts.Subtitles)
+		if err == nil {
+			return nil
+		}
+		m.print("Smart cut failed, cutting at keyframes instead:", err)
+	}
+
+	input := append(concatInput(listPath), subtitleInput(opts.Subtitles)...)
+	switch {
+	case opts.Overlay != "":
+		m.printf("Burning the chat into the video with %s\n", opts.Encode.codec())
+		input = append(input, concatInput(opts.Overlay)...)
+	case opts.Encode.enabled():
+		m.printf("Re-encoding the video with %s\n", opts.Encode.codec())
+	}
+	return m.run(ctx, c.args(input, outputArgs(output, opts)...)...)
+}
+
+// ExtractAudio converts the audio of input into the format of the extension of output. m4a files
+// get a copy of the AAC audio, everything else is re-encoded.
+func (m *FFmpegMuxer) ExtractAudio(ctx context.Context, input string, output string, opts AudioOptions) error {
+	m.printDebugf("Running ffmpeg audio extraction\n")
+	return m.run(ctx, append([]string{"-i", input}, audioArgs(output, opts)...)...)
+}
+
+/*
+Returns the ffmpeg output options that write the audio into output
+*/
+func audioArgs(output string, opts AudioOptions) []string {
+	args := []string{"-vn", "-sn"}
+	switch strings.TrimPrefix(filepath.Ext(output), ".") {
+	case AudioFormatM4A:
+		// transport streams have ADTS headers, mp4 doesn't and passes the filter unchanged
+		return append(args, "-c:a", "copy", "-bsf:a", "aac_adtstoasc", output)
+	case AudioFormatMP3:
+		args = append(args, "-c:a", "libmp3lame")
+	case AudioFormatOpus:
+		args = append(args, "-c:a", "libopus")
+	case AudioFormatFLAC:
+		return append(args, "-c:a", "flac", output)
+	}
+	if opts.Bitrate > 0 {
+		args = append(args, "-b:a", strconv.Itoa(opts.Bitrate)+"k")
+	}
+	return append(args, output)
+}
+
+// Probe runs ffprobe on path.
+func (m *FFmpegMuxer) Probe(ctx context.Context, path string) (*MediaInfo, error) {
+	args := []string{"-v", "error", "-show_entries", "format=duration:stream=codec_type,codec_name,width,height", "-of", "json", path}
+	out, err := m.runProbe(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			CodecName string `json:"codec_name"`
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("could not read ffprobe output: %v", err)
+	}
+	seconds, err := strconv.ParseFloat(result.Format.Duration, 64)
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe returned no duration for %s", path)
+	}
+
+	info := &MediaInfo{Duration: secondsToDuration(seconds)}
+	for _, s := range result.Streams {
+		info.Streams = append(info.Streams, StreamInfo{Type: s.CodecType, Codec: s.CodecName, Width: s.Width, Height: s.Height})
+	}
+	return info, nil
+}
+
+/*
+Runs ffprobe with args and returns its output, the error contains the errors of ffprobe
+*/
+func (m *FFmpegMuxer) runProbe(ctx context.Context, args ...string) ([]byte, error) {
+	m.printDebugf("Running ffprobe: %s %s\n", m.ffprobeCmd(), args)
+
+	cmd := exec.CommandContext(ctx, m.ffprobeCmd(), args...)
+	var errbuf bytes.Buffer
+	cmd.Stderr = &errbuf
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe: %v: %s", err, strings.TrimSpace(errbuf.String()))
+	}
+	return out, nil
+}
+
+/*
+Runs ffmpeg with args, the error contains the output of ffmpeg
+*/
+func (m *FFmpegMuxer) run(ctx context.Context, args ...string) error {
+	m.printDebugf("Running ffmpeg: %s %s\n", m.ffmpegCmd(), args)
+
+	cmd := exec.CommandContext(ctx, m.ffmpegCmd(), args...)
+	var errbuf bytes.Buffer
+	cmd.Stderr = &errbuf
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(errbuf.String()))
+	}
+	return nil
+}
+
+// FFmpegIsInstalled reports whether the ffmpeg binary of the Downloader can be run.
+func (d *Downloader) FFmpegIsInstalled() bool {
+	out, _ := exec.Command(d.ffmpegCmd()).Output()
+	return out != nil
+}
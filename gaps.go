@@ -0,0 +1,80 @@
+package concat
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FailedChunk is a chunk that couldn't be downloaded after all tries.
+type FailedChunk struct {
+	Number int
+	URI    string
+	// Start is the position of the chunk in the vod.
+	Start    time.Duration
+	Duration time.Duration
+	Err      error
+}
+
+func (f *FailedChunk) Error() string {
+	return fmt.Sprintf("chunk %d (%s - %s): %v", f.Number, formatOffset(f.Start), formatOffset(f.Start+f.Duration), f.Err)
+}
+
+/*
+ChunksFailedError is returned by Download if chunks couldn't be downloaded and Options.AllowGaps
+isn't set. The downloaded chunks are kept in TempDir, so running the download again only fetches
+the failed ones.
+*/
+type ChunksFailedError struct {
+	Chunks  []FailedChunk
+	TempDir string
+}
+
+func (e *ChunksFailedError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d chunks could not be downloaded, they are kept in %s to resume later:", len(e.Chunks), e.TempDir)
+	for _, c := range e.Chunks {
+		b.WriteString("\n  ")
+		b.WriteString(c.Error())
+	}
+	return b.String()
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+/*
+Formats d as H:MM:SS.mmm, the way timestamps are shown in players
+*/
+func formatOffset(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+/*
+Returns the path of the gap report next to the video at vodSavePath
+*/
+func gapReportPath(vodSavePath string) string {
+	return strings.TrimSuffix(vodSavePath, filepath.Ext(vodSavePath)) + "_gaps.txt"
+}
+
+/*
+Writes the parts of the vod that are missing in the output. start is the position in the vod the
+output starts at, the output times are relative to it and account for the missing chunks before
+each gap
+*/
+func writeGapReport(path string, vodID string, failed []FailedChunk, start float64) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "vod %s is missing %d chunks\n", vodID, len(failed))
+	fmt.Fprintln(&b, "vod start\tvod end\toutput position\tchunk\terror")
+
+	var missing time.Duration
+	for _, c := range failed {
+		output := c.Start - secondsToDuration(start) - missing
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%v\n", formatOffset(c.Start), formatOffset(c.Start+c.Duration), formatOffset(output), c.URI, c.Err)
+		missing += c.Duration
+	}
+	return writeFileAtomic(path, []byte(b.String()))
+}
@@ -1,5 +1,5 @@
 module github.com/ArneVogel/concat
 
-go 1.13
+go 1.18
 
 require github.com/abiosoft/semaphore v0.0.0-20180811165425-cb737ff681bd
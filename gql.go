@@ -0,0 +1,129 @@
+package concat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// DefaultGQLEndpoint is the twitch GQL api used if Downloader.GQLEndpoint is empty.
+const DefaultGQLEndpoint string = "https://gql.twitch.tv/gql"
+
+// DefaultGQLClientID is the client id of the twitch website. The GQL api doesn't accept other client ids
+// for most queries.
+const DefaultGQLClientID string = "kimne78kx3ncx6brgo4mv6wki5h1ko"
+
+type gqlRequest struct {
+	OperationName string                 `json:"operationName"`
+	Query         string                 `json:"query,omitempty"`
+	Variables     map[string]interface{} `json:"variables"`
+	Extensions    *gqlExtensions         `json:"extensions,omitempty"`
+}
+
+type gqlExtensions struct {
+	PersistedQuery gqlPersistedQuery `json:"persistedQuery"`
+}
+
+type gqlPersistedQuery struct {
+	Version    int    `json:"version"`
+	SHA256Hash string `json:"sha256Hash"`
+}
+
+type gqlResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func persistedQuery(operationName string, sha256Hash string, variables map[string]interface{}) gqlRequest {
+	return gqlRequest{
+		OperationName: operationName,
+		Variables:     variables,
+		Extensions: &gqlExtensions{
+			PersistedQuery: gqlPersistedQuery{Version: 1, SHA256Hash: sha256Hash},
+		},
+	}
+}
+
+/*
+Returns a request with the full query, for queries the twitch website doesn't have persisted
+*/
+func query(operationName string, query string, variables map[string]interface{}) gqlRequest {
+	return gqlRequest{
+		OperationName: operationName,
+		Query:         query,
+		Variables:     variables,
+	}
+}
+
+/*
+Sends a GQL request to endpoint and decodes the data field of the response into data
+*/
+func postGQL(ctx context.Context, client *http.Client, endpoint string, clientID string, gqlReq gqlRequest, data interface{}) error {
+	reqBody, err := json.Marshal(gqlReq)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Client-ID", clientID)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gql %s: status %d: %s", gqlReq.OperationName, resp.StatusCode, body)
+	}
+
+	var gqlResp gqlResponse
+	if err := json.Unmarshal(body, &gqlResp); err != nil {
+		return fmt.Errorf("gql %s: %v", gqlReq.OperationName, err)
+	}
+
+	if len(gqlResp.Errors) > 0 {
+		var messages []string
+		for _, e := range gqlResp.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("gql %s: %s", gqlReq.OperationName, strings.Join(messages, ", "))
+	}
+
+	return json.Unmarshal(gqlResp.Data, data)
+}
+
+func (d *Downloader) gqlEndpoint() string {
+	if d.GQLEndpoint != "" {
+		return d.GQLEndpoint
+	}
+	return DefaultGQLEndpoint
+}
+
+func (d *Downloader) gqlClientID() string {
+	if d.GQLClientID != "" {
+		return d.GQLClientID
+	}
+	return DefaultGQLClientID
+}
+
+func (d *Downloader) gql(ctx context.Context, gqlReq gqlRequest, data interface{}) error {
+	d.printDebugf("\nGQL request: %s %v\n", gqlReq.OperationName, gqlReq.Variables)
+	return postGQL(ctx, d.httpClient(), d.gqlEndpoint(), d.gqlClientID(), gqlReq, data)
+}
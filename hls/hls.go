@@ -0,0 +1,162 @@
+// Package hls parses the HLS playlists twitch serves for vods, clips and live streams.
+//
+// ParseMaster reads master playlists, which list the qualities of a video, and ParseMedia reads media
+// playlists, which list the segments of one quality. Tags the parser doesn't know are ignored, so
+// playlists with newer tags still parse.
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrNoHeader is returned for data that doesn't start with #EXTM3U.
+var ErrNoHeader = errors.New("hls: playlist doesn't start with #EXTM3U")
+
+// ParseError is returned for a line that can't be parsed.
+type ParseError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("hls: line %d %q: %v", e.Line, e.Text, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Attributes are the attributes of a tag like EXT-X-STREAM-INF. Quoted values are stored without quotes.
+type Attributes map[string]string
+
+// ParseAttributes parses an attribute list like BANDWIDTH=6211302,CODECS="avc1.64002A,mp4a.40.2".
+func ParseAttributes(s string) (Attributes, error) {
+	attrs := make(Attributes)
+	for len(s) > 0 {
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("attribute without value in %q", s)
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted value of %s", name)
+			}
+			value = s[1 : end+1]
+			s = s[end+2:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+
+		if _, ok := attrs[name]; ok {
+			return nil, fmt.Errorf("duplicate attribute %s", name)
+		}
+		attrs[name] = value
+
+		if len(s) > 0 {
+			if s[0] != ',' {
+				return nil, fmt.Errorf("expected ',' after %s", name)
+			}
+			s = s[1:]
+		}
+	}
+	return attrs, nil
+}
+
+// Int returns the attribute name as int, 0 if it is missing or not a number.
+func (a Attributes) Int(name string) int {
+	i, _ := strconv.Atoi(a[name])
+	return i
+}
+
+// Float returns the attribute name as float, 0 if it is missing or not a number.
+func (a Attributes) Float(name string) float64 {
+	f, _ := strconv.ParseFloat(a[name], 64)
+	return f
+}
+
+// Bool returns whether the attribute name is YES.
+func (a Attributes) Bool(name string) bool {
+	return a[name] == "YES"
+}
+
+// line is a line of a playlist with its number for errors
+type line struct {
+	number int
+	text   string
+}
+
+func (l line) errorf(format string, args ...interface{}) error {
+	return &ParseError{Line: l.number, Text: l.text, Err: fmt.Errorf(format, args...)}
+}
+
+/*
+Returns the tag name and value of a line like #EXT-X-TARGETDURATION:10. Lines that aren't tags have an empty name
+*/
+func (l line) tag() (string, string) {
+	if !strings.HasPrefix(l.text, "#") {
+		return "", ""
+	}
+	colon := strings.IndexByte(l.text, ':')
+	if colon < 0 {
+		return l.text[1:], ""
+	}
+	return l.text[1:colon], l.text[colon+1:]
+}
+
+/*
+Splits data into its non empty lines and checks the #EXTM3U header
+*/
+func readLines(data []byte) ([]line, error) {
+	var lines []line
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		lines = append(lines, line{number: number, text: text})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(lines) == 0 || strings.TrimPrefix(lines[0].text, "\ufeff") != "#EXTM3U" {
+		return nil, ErrNoHeader
+	}
+	return lines[1:], nil
+}
+
+func parseInt(l line, value string) (int, error) {
+	i, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || i < 0 {
+		return 0, l.errorf("invalid number %q", value)
+	}
+	return i, nil
+}
+
+func parseFloat(l line, value string) (float64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || f < 0 || f != f || f > 1e12 {
+		return 0, l.errorf("invalid number %q", value)
+	}
+	return f, nil
+}
@@ -0,0 +1,201 @@
+package hls
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestGolden parses every playlist in testdata and compares the result with the .golden file next to it.
+// Files starting with master_ are master playlists, everything else media playlists.
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.m3u8"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no playlists in testdata: %v", err)
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var playlist interface{}
+		if strings.HasPrefix(filepath.Base(file), "master_") {
+			playlist, err = ParseMaster(data)
+		} else {
+			playlist, err = ParseMedia(data)
+		}
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+
+		got, err := json.MarshalIndent(playlist, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, '\n')
+
+		golden := strings.TrimSuffix(file, ".m3u8") + ".golden"
+		if *update {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("%v, run the tests with -update to create it", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: parsed playlist differs from %s:\n%s", file, golden, got)
+		}
+	}
+}
+
+func TestParseAttributes(t *testing.T) {
+	attrs, err := ParseAttributes(`BANDWIDTH=6211302,CODECS="avc1.64002A,mp4a.40.2",RESOLUTION=1920x1080,NAME="",FRAME-RATE=60.000`)
+	want := Attributes{"BANDWIDTH": "6211302", "CODECS": "avc1.64002A,mp4a.40.2", "RESOLUTION": "1920x1080", "NAME": "", "FRAME-RATE": "60.000"}
+	if err != nil || !reflect.DeepEqual(attrs, want) {
+		t.Errorf("got %v, %v, want %v", attrs, err, want)
+	}
+	if attrs.Int("BANDWIDTH") != 6211302 || attrs.Float("FRAME-RATE") != 60 || attrs.Int("MISSING") != 0 {
+		t.Errorf("unexpected typed values of %v", attrs)
+	}
+
+	for _, s := range []string{`NAME="unterminated`, `=1`, `BANDWIDTH`, `A=1,A=2`, `NAME="a"b`} {
+		if attrs, err := ParseAttributes(s); err == nil {
+			t.Errorf("ParseAttributes(%q) = %v, expected error", s, attrs)
+		}
+	}
+}
+
+func TestMasterName(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "master_vod.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParseMaster(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, v := range p.Variants {
+		names = append(names, p.Name(v))
+	}
+	if want := []string{"1080p60", "720p60", "720p", "Audio Only"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got names %v, want %v", names, want)
+	}
+	if p.Name(&Variant{Video: "unknown"}) != "unknown" {
+		t.Errorf("Name doesn't fall back to the group id")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	media := []string{
+		"",
+		"#EXT-X-VERSION:3\n#EXTINF:10,\n0.ts\n",
+		"#EXTM3U\n#EXTINF:ten,\n0.ts\n",
+		"#EXTM3U\n#EXTINF:-1,\n0.ts\n",
+		"#EXTM3U\n0.ts\n",
+		"#EXTM3U\n#EXTINF:10,\n",
+		"#EXTM3U\n#EXTINF:10,\n#EXTINF:10,\n0.ts\n",
+		"#EXTM3U\n#EXT-X-TARGETDURATION:x\n",
+		"#EXTM3U\n#EXT-X-BYTERANGE:100\n#EXTINF:10,\n0.ts\n",
+		"#EXTM3U\n#EXT-X-PROGRAM-DATE-TIME:yesterday\n",
+		"#EXTM3U\n#EXT-X-MAP:BYTERANGE=\"10@0\"\n",
+		"#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1\nindex.m3u8\n",
+		"#EXTM3U\n#EXTINF:10,\n0.ts\n#EXT-X-MEDIA-SEQUENCE:5\n",
+	}
+	for _, s := range media {
+		if p, err := ParseMedia([]byte(s)); err == nil {
+			t.Errorf("ParseMedia(%q) = %+v, expected error", s, p)
+		}
+	}
+
+	master := []string{
+		"#EXT-X-STREAM-INF:BANDWIDTH=1\nindex.m3u8\n",
+		"#EXTM3U\nindex.m3u8\n",
+		"#EXTM3U\n#EXT-X-STREAM-INF:RESOLUTION=1x1\nindex.m3u8\n",
+		"#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1,RESOLUTION=big\nindex.m3u8\n",
+		"#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1\n",
+		"#EXTM3U\n#EXT-X-MEDIA:NAME=\"720p\"\n",
+		"#EXTM3U\n#EXTINF:10,\n0.ts\n",
+	}
+	for _, s := range master {
+		if p, err := ParseMaster([]byte(s)); err == nil {
+			t.Errorf("ParseMaster(%q) = %+v, expected error", s, p)
+		}
+	}
+
+	_, err := ParseMedia([]byte("#EXTM3U\n\n#EXTINF:ten,\n0.ts\n"))
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Line != 3 {
+		t.Errorf("expected error in line 3, got %v", err)
+	}
+	if _, err := ParseMaster(nil); err != ErrNoHeader {
+		t.Errorf("expected ErrNoHeader, got %v", err)
+	}
+}
+
+func addSeeds(f *testing.F) {
+	files, _ := filepath.Glob(filepath.Join("testdata", "*.m3u8"))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+}
+
+func FuzzParseMedia(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := ParseMedia(data)
+		if err != nil {
+			return
+		}
+		for i, s := range p.Segments {
+			if s.Sequence != p.MediaSequence+i {
+				t.Errorf("segment %d has sequence %d, media sequence is %d", i, s.Sequence, p.MediaSequence)
+			}
+			if s.URI == "" || strings.HasPrefix(s.URI, "#") {
+				t.Errorf("segment %d has uri %q", i, s.URI)
+			}
+			if s.Duration < 0 || s.ByteRange != nil && (s.ByteRange.Offset < 0 || s.ByteRange.Length < 0) {
+				t.Errorf("segment %d has invalid duration or byte range %+v", i, s)
+			}
+		}
+		if p.Duration() < 0 || p.TargetDuration < 0 {
+			t.Errorf("negative duration in %+v", p)
+		}
+	})
+}
+
+func FuzzParseMaster(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := ParseMaster(data)
+		if err != nil {
+			return
+		}
+		for i, v := range p.Variants {
+			if v.URI == "" || strings.HasPrefix(v.URI, "#") {
+				t.Errorf("variant %d has uri %q", i, v.URI)
+			}
+			if v.Resolution.Width < 0 || v.Resolution.Height < 0 {
+				t.Errorf("variant %d has resolution %v", i, v.Resolution)
+			}
+			p.Name(v)
+		}
+	})
+}
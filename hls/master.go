@@ -0,0 +1,191 @@
+package hls
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MasterPlaylist lists the qualities of a video.
+type MasterPlaylist struct {
+	Version int
+
+	// Variants are the EXT-X-STREAM-INF entries in the order of the playlist.
+	Variants []*Variant
+
+	// Renditions are the EXT-X-MEDIA entries in the order of the playlist.
+	Renditions []*Rendition
+
+	// TwitchInfo are the attributes of twitch's EXT-X-TWITCH-INFO tag.
+	TwitchInfo Attributes
+}
+
+// Variant is an EXT-X-STREAM-INF entry, a media playlist in one quality.
+type Variant struct {
+	URI              string
+	Bandwidth        int
+	AverageBandwidth int
+	Codecs           string
+	Resolution       Resolution
+	FrameRate        float64
+
+	// Video and Audio are the GROUP-IDs of the renditions of the variant. Twitch uses the
+	// VIDEO group as the name of the quality, like "chunked" or "720p30".
+	Video string
+	Audio string
+
+	// Attributes are all attributes of the tag.
+	Attributes Attributes
+}
+
+// Resolution of a variant, zero if the playlist has none like for audio only variants.
+type Resolution struct {
+	Width  int
+	Height int
+}
+
+func (r Resolution) String() string {
+	if r.Width == 0 && r.Height == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d", r.Width, r.Height)
+}
+
+// Rendition is an EXT-X-MEDIA entry. Twitch uses them to name the VIDEO groups, like "1080p60"
+// or "Audio Only".
+type Rendition struct {
+	Type       string
+	GroupID    string
+	Name       string
+	Language   string
+	URI        string
+	Default    bool
+	Autoselect bool
+
+	// Attributes are all attributes of the tag.
+	Attributes Attributes
+}
+
+// Rendition returns the first rendition of typ in groupID, nil if there is none.
+func (p *MasterPlaylist) Rendition(typ string, groupID string) *Rendition {
+	for _, r := range p.Renditions {
+		if r.Type == typ && r.GroupID == groupID {
+			return r
+		}
+	}
+	return nil
+}
+
+// Name returns the name twitch shows for v, the NAME of its VIDEO rendition. Falls back to the
+// VIDEO group id.
+func (p *MasterPlaylist) Name(v *Variant) string {
+	if r := p.Rendition("VIDEO", v.Video); r != nil && r.Name != "" {
+		return r.Name
+	}
+	return v.Video
+}
+
+// ParseMaster parses a master playlist.
+func ParseMaster(data []byte) (*MasterPlaylist, error) {
+	lines, err := readLines(data)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &MasterPlaylist{}
+	var variant *Variant
+	for _, l := range lines {
+		name, value := l.tag()
+		switch {
+		case name == "EXT-X-VERSION":
+			if p.Version, err = parseInt(l, value); err != nil {
+				return nil, err
+			}
+		case name == "EXT-X-STREAM-INF":
+			if variant != nil {
+				return nil, l.errorf("EXT-X-STREAM-INF without uri before it")
+			}
+			if variant, err = parseVariant(l, value); err != nil {
+				return nil, err
+			}
+		case name == "EXT-X-MEDIA":
+			r, err := parseRendition(l, value)
+			if err != nil {
+				return nil, err
+			}
+			p.Renditions = append(p.Renditions, r)
+		case name == "EXT-X-TWITCH-INFO":
+			if p.TwitchInfo, err = ParseAttributes(value); err != nil {
+				return nil, l.errorf("%v", err)
+			}
+		case name == "EXTINF":
+			return nil, l.errorf("media segment in master playlist")
+		case strings.HasPrefix(l.text, "#"):
+			// comments and unknown tags
+		default:
+			if variant == nil {
+				return nil, l.errorf("uri without EXT-X-STREAM-INF")
+			}
+			variant.URI = l.text
+			p.Variants = append(p.Variants, variant)
+			variant = nil
+		}
+	}
+	if variant != nil {
+		return nil, fmt.Errorf("hls: EXT-X-STREAM-INF without uri at the end of the playlist")
+	}
+	return p, nil
+}
+
+func parseVariant(l line, value string) (*Variant, error) {
+	attrs, err := ParseAttributes(value)
+	if err != nil {
+		return nil, l.errorf("%v", err)
+	}
+	if _, ok := attrs["BANDWIDTH"]; !ok {
+		return nil, l.errorf("missing BANDWIDTH")
+	}
+
+	v := &Variant{
+		Bandwidth:        attrs.Int("BANDWIDTH"),
+		AverageBandwidth: attrs.Int("AVERAGE-BANDWIDTH"),
+		Codecs:           attrs["CODECS"],
+		FrameRate:        attrs.Float("FRAME-RATE"),
+		Video:            attrs["VIDEO"],
+		Audio:            attrs["AUDIO"],
+		Attributes:       attrs,
+	}
+	if res, ok := attrs["RESOLUTION"]; ok {
+		parts := strings.Split(strings.ToLower(res), "x")
+		if len(parts) != 2 {
+			return nil, l.errorf("invalid RESOLUTION %q", res)
+		}
+		w, errW := strconv.Atoi(parts[0])
+		h, errH := strconv.Atoi(parts[1])
+		if errW != nil || errH != nil || w < 0 || h < 0 {
+			return nil, l.errorf("invalid RESOLUTION %q", res)
+		}
+		v.Resolution = Resolution{Width: w, Height: h}
+	}
+	return v, nil
+}
+
+func parseRendition(l line, value string) (*Rendition, error) {
+	attrs, err := ParseAttributes(value)
+	if err != nil {
+		return nil, l.errorf("%v", err)
+	}
+	if attrs["TYPE"] == "" || attrs["GROUP-ID"] == "" {
+		return nil, l.errorf("missing TYPE or GROUP-ID")
+	}
+	return &Rendition{
+		Type:       attrs["TYPE"],
+		GroupID:    attrs["GROUP-ID"],
+		Name:       attrs["NAME"],
+		Language:   attrs["LANGUAGE"],
+		URI:        attrs["URI"],
+		Default:    attrs.Bool("DEFAULT"),
+		Autoselect: attrs.Bool("AUTOSELECT"),
+		Attributes: attrs,
+	}, nil
+}
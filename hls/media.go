@@ -0,0 +1,289 @@
+package hls
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MediaPlaylist lists the segments of one quality.
+type MediaPlaylist struct {
+	Version        int
+	TargetDuration float64
+	MediaSequence  int
+
+	// DiscontinuitySequence is the value of EXT-X-DISCONTINUITY-SEQUENCE.
+	DiscontinuitySequence int
+
+	// PlaylistType is EVENT, VOD or empty.
+	PlaylistType string
+
+	// Ended is set if the playlist has EXT-X-ENDLIST, no segments are added to it anymore.
+	Ended bool
+
+	Segments []*Segment
+
+	// DateRanges are the EXT-X-DATERANGE tags, twitch marks ads in live streams with them.
+	DateRanges []*DateRange
+
+	// Twitch are the values of twitch's custom tags like EXT-X-TWITCH-TOTAL-SECS and ID3-EQUIV-TDTG,
+	// by tag name without the leading #. Tags that appear more than once keep their last value.
+	Twitch map[string]string
+
+	// Prefetch are the EXT-X-TWITCH-PREFETCH uris of low latency live streams, segments that are
+	// announced but not finished yet.
+	Prefetch []string
+}
+
+// Segment is a media segment of a playlist.
+type Segment struct {
+	// Sequence is the media sequence number of the segment.
+	Sequence int
+
+	URI string
+
+	// Duration and Title are the values of EXTINF. Twitch titles live segments "live" and ads
+	// something else.
+	Duration float64
+	Title    string
+
+	// Discontinuity is set if EXT-X-DISCONTINUITY is before the segment.
+	Discontinuity bool
+
+	// ByteRange is the part of URI that is the segment, nil for the whole resource.
+	ByteRange *ByteRange
+
+	// Map is the EXT-X-MAP that applies to the segment, nil if there is none.
+	Map *Map
+
+	// ProgramDateTime is the EXT-X-PROGRAM-DATE-TIME of the segment, the zero time if there is none.
+	ProgramDateTime time.Time
+}
+
+// ByteRange is a part of a resource, the value of EXT-X-BYTERANGE or the BYTERANGE attribute of EXT-X-MAP.
+type ByteRange struct {
+	Length int64
+	Offset int64
+}
+
+// Map is an EXT-X-MAP tag, the initialization section of the segments after it.
+type Map struct {
+	URI       string
+	ByteRange *ByteRange
+}
+
+// DateRange is an EXT-X-DATERANGE tag.
+type DateRange struct {
+	ID        string
+	Class     string
+	StartDate time.Time
+	// Duration is 0 if the tag has no DURATION.
+	Duration float64
+
+	// Attributes are all attributes of the tag.
+	Attributes Attributes
+}
+
+// IsTwitchAd reports whether the date range marks an ad twitch stitched into a live stream.
+func (r *DateRange) IsTwitchAd() bool {
+	return r.Class == "twitch-stitched-ad" || strings.HasPrefix(r.ID, "stitched-ad-")
+}
+
+// Duration returns the sum of the durations of the segments.
+func (p *MediaPlaylist) Duration() float64 {
+	sum := 0.0
+	for _, s := range p.Segments {
+		sum += s.Duration
+	}
+	return sum
+}
+
+// ParseMedia parses a media playlist.
+func ParseMedia(data []byte) (*MediaPlaylist, error) {
+	lines, err := readLines(data)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &MediaPlaylist{Twitch: make(map[string]string)}
+
+	// state for the next segment
+	var segment *Segment
+	var discontinuity bool
+	var byteRange *ByteRange
+	var programDateTime time.Time
+	var currentMap *Map
+	// the end of the last byte range of each uri, for byte ranges without offset
+	rangeEnds := make(map[string]int64)
+
+	for _, l := range lines {
+		name, value := l.tag()
+		switch {
+		case name == "EXT-X-VERSION":
+			if p.Version, err = parseInt(l, value); err != nil {
+				return nil, err
+			}
+		case name == "EXT-X-TARGETDURATION":
+			if p.TargetDuration, err = parseFloat(l, value); err != nil {
+				return nil, err
+			}
+		case name == "EXT-X-MEDIA-SEQUENCE":
+			if len(p.Segments) > 0 {
+				return nil, l.errorf("EXT-X-MEDIA-SEQUENCE after the first segment")
+			}
+			if p.MediaSequence, err = parseInt(l, value); err != nil {
+				return nil, err
+			}
+		case name == "EXT-X-DISCONTINUITY-SEQUENCE":
+			if p.DiscontinuitySequence, err = parseInt(l, value); err != nil {
+				return nil, err
+			}
+		case name == "EXT-X-PLAYLIST-TYPE":
+			p.PlaylistType = strings.TrimSpace(value)
+		case name == "EXT-X-ENDLIST":
+			p.Ended = true
+		case name == "EXTINF":
+			if segment != nil {
+				return nil, l.errorf("EXTINF without uri before it")
+			}
+			segment = &Segment{}
+			durationValue := value
+			if comma := strings.IndexByte(value, ','); comma >= 0 {
+				durationValue = value[:comma]
+				segment.Title = strings.TrimSpace(value[comma+1:])
+			}
+			if segment.Duration, err = parseFloat(l, durationValue); err != nil {
+				return nil, err
+			}
+		case name == "EXT-X-DISCONTINUITY":
+			discontinuity = true
+		case name == "EXT-X-BYTERANGE":
+			if byteRange, err = parseByteRange(l, value); err != nil {
+				return nil, err
+			}
+		case name == "EXT-X-PROGRAM-DATE-TIME":
+			if programDateTime, err = parseTime(l, value); err != nil {
+				return nil, err
+			}
+		case name == "EXT-X-MAP":
+			attrs, err := ParseAttributes(value)
+			if err != nil {
+				return nil, l.errorf("%v", err)
+			}
+			if attrs["URI"] == "" {
+				return nil, l.errorf("EXT-X-MAP without URI")
+			}
+			currentMap = &Map{URI: attrs["URI"]}
+			if r, ok := attrs["BYTERANGE"]; ok {
+				if currentMap.ByteRange, err = parseByteRange(l, r); err != nil {
+					return nil, err
+				}
+				if currentMap.ByteRange.Offset < 0 {
+					return nil, l.errorf("BYTERANGE of EXT-X-MAP without offset")
+				}
+			}
+		case name == "EXT-X-DATERANGE":
+			r, err := parseDateRange(l, value)
+			if err != nil {
+				return nil, err
+			}
+			p.DateRanges = append(p.DateRanges, r)
+		case name == "EXT-X-TWITCH-PREFETCH":
+			p.Prefetch = append(p.Prefetch, strings.TrimSpace(value))
+		case strings.HasPrefix(name, "EXT-X-TWITCH-") || name == "ID3-EQUIV-TDTG":
+			p.Twitch[name] = strings.TrimSpace(value)
+		case name == "EXT-X-STREAM-INF" || name == "EXT-X-MEDIA":
+			return nil, l.errorf("master playlist tag in media playlist")
+		case strings.HasPrefix(l.text, "#"):
+			// comments and unknown tags
+		default:
+			if segment == nil {
+				return nil, l.errorf("uri without EXTINF")
+			}
+			segment.URI = l.text
+			segment.Sequence = p.MediaSequence + len(p.Segments)
+			segment.Discontinuity = discontinuity
+			segment.ProgramDateTime = programDateTime
+			segment.Map = currentMap
+			if byteRange != nil {
+				if byteRange.Offset < 0 {
+					end, ok := rangeEnds[segment.URI]
+					if !ok {
+						return nil, l.errorf("EXT-X-BYTERANGE without offset for the first range of %s", segment.URI)
+					}
+					byteRange.Offset = end
+				}
+				rangeEnds[segment.URI] = byteRange.Offset + byteRange.Length
+				segment.ByteRange = byteRange
+			}
+			p.Segments = append(p.Segments, segment)
+
+			// the next segment follows this one without gap
+			if !programDateTime.IsZero() {
+				programDateTime = programDateTime.Add(time.Duration(math.Round(segment.Duration * float64(time.Second))))
+			}
+			segment = nil
+			discontinuity = false
+			byteRange = nil
+		}
+	}
+	if segment != nil {
+		return nil, fmt.Errorf("hls: EXTINF without uri at the end of the playlist")
+	}
+	return p, nil
+}
+
+/*
+Parses <length>[@<offset>], the offset is -1 if it is missing
+*/
+func parseByteRange(l line, value string) (*ByteRange, error) {
+	r := &ByteRange{Offset: -1}
+	parts := strings.SplitN(strings.TrimSpace(value), "@", 2)
+	length, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || length < 0 {
+		return nil, l.errorf("invalid byte range %q", value)
+	}
+	r.Length = length
+	if len(parts) == 2 {
+		offset, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || offset < 0 {
+			return nil, l.errorf("invalid byte range %q", value)
+		}
+		r.Offset = offset
+	}
+	return r, nil
+}
+
+func parseTime(l line, value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999Z0700"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, l.errorf("invalid date %q", value)
+}
+
+func parseDateRange(l line, value string) (*DateRange, error) {
+	attrs, err := ParseAttributes(value)
+	if err != nil {
+		return nil, l.errorf("%v", err)
+	}
+	r := &DateRange{ID: attrs["ID"], Class: attrs["CLASS"], Attributes: attrs}
+	if r.ID == "" {
+		return nil, l.errorf("EXT-X-DATERANGE without ID")
+	}
+	if start, ok := attrs["START-DATE"]; ok {
+		if r.StartDate, err = parseTime(l, start); err != nil {
+			return nil, err
+		}
+	}
+	if duration, ok := attrs["DURATION"]; ok {
+		if r.Duration, err = parseFloat(l, duration); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
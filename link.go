@@ -0,0 +1,104 @@
+package concat
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Kinds of twitch links.
+const (
+	LinkVOD        string = "vod"
+	LinkClip       string = "clip"
+	LinkChannel    string = "channel"
+	LinkCollection string = "collection"
+)
+
+// Link is a twitch link parsed by ParseLink.
+type Link struct {
+	// Kind is LinkVOD, LinkClip, LinkChannel or LinkCollection.
+	Kind string
+
+	// ID is the vod id, the clip slug, the channel name or the collection id.
+	ID string
+
+	// Start is the time of the t= parameter of vod links, 0 if there is none.
+	Start time.Duration
+}
+
+var (
+	vodPathRegex        = regexp.MustCompile(`^/(?:videos|[^/]+/v(?:ideo)?)/v?(\d+)/?$`)
+	collectionPathRegex = regexp.MustCompile(`^/collections/([A-Za-z0-9_-]+)/?$`)
+	channelPathRegex    = regexp.MustCompile(`^/([A-Za-z0-9_]{1,25})(?:/(?:videos|clips|schedule|about))?/?$`)
+)
+
+// paths of twitch.tv that look like channels but aren't
+var reservedPaths = map[string]bool{
+	"directory": true, "downloads": true, "jobs": true, "p": true, "search": true,
+	"settings": true, "subscriptions": true, "turbo": true, "videos": true, "wallet": true,
+}
+
+// ParseLink detects the kind of a twitch link. Accepted are vod links like
+// https://www.twitch.tv/videos/123456789?t=1h2m3s, clip links, channel links like
+// https://www.twitch.tv/reckful and collection links like https://www.twitch.tv/collections/abc123.
+func ParseLink(link string) (Link, error) {
+	raw := strings.TrimSpace(link)
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Link{}, fmt.Errorf("invalid link %q: %v", link, err)
+	}
+
+	host := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(u.Hostname()), "www."), "m.")
+	if host != "twitch.tv" && host != "clips.twitch.tv" && host != "player.twitch.tv" {
+		return Link{}, fmt.Errorf("%q is not a twitch link", link)
+	}
+
+	if match := clipURLRegex.FindStringSubmatch(raw); match != nil {
+		return Link{Kind: LinkClip, ID: match[1]}, nil
+	}
+
+	query := u.Query()
+
+	// player.twitch.tv/?video=v123456789 and player.twitch.tv/?channel=reckful
+	if host == "player.twitch.tv" {
+		if video := strings.TrimPrefix(query.Get("video"), "v"); video != "" {
+			return vodLink(video, query.Get("t"))
+		}
+		if channel := query.Get("channel"); channel != "" {
+			return Link{Kind: LinkChannel, ID: strings.ToLower(channel)}, nil
+		}
+		if collection := query.Get("collection"); collection != "" {
+			return Link{Kind: LinkCollection, ID: collection}, nil
+		}
+		return Link{}, fmt.Errorf("%q has no video, channel or collection", link)
+	}
+
+	if match := vodPathRegex.FindStringSubmatch(u.Path); match != nil {
+		return vodLink(match[1], query.Get("t"))
+	}
+	if match := collectionPathRegex.FindStringSubmatch(u.Path); match != nil {
+		return Link{Kind: LinkCollection, ID: match[1]}, nil
+	}
+	if match := channelPathRegex.FindStringSubmatch(u.Path); match != nil && !reservedPaths[strings.ToLower(match[1])] {
+		return Link{Kind: LinkChannel, ID: strings.ToLower(match[1])}, nil
+	}
+	return Link{}, fmt.Errorf("unsupported twitch link %q", link)
+}
+
+func vodLink(id string, t string) (Link, error) {
+	l := Link{Kind: LinkVOD, ID: id}
+	if t == "" {
+		return l, nil
+	}
+	start, err := ParseTimestamp(t)
+	if err != nil || start < 0 {
+		return Link{}, fmt.Errorf("invalid t= parameter %q", t)
+	}
+	l.Start = start
+	return l, nil
+}
@@ -0,0 +1,44 @@
+package concat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLink(t *testing.T) {
+	tests := []struct {
+		link string
+		want Link
+	}{
+		{"https://www.twitch.tv/videos/187938112", Link{Kind: LinkVOD, ID: "187938112"}},
+		{"https://www.twitch.tv/videos/187938112?t=1h2m3s", Link{Kind: LinkVOD, ID: "187938112", Start: time.Hour + 2*time.Minute + 3*time.Second}},
+		{"twitch.tv/videos/187938112?t=90s&filter=archives", Link{Kind: LinkVOD, ID: "187938112", Start: 90 * time.Second}},
+		{"https://m.twitch.tv/videos/187938112/", Link{Kind: LinkVOD, ID: "187938112"}},
+		{"https://www.twitch.tv/videos/187938112?collection=abc", Link{Kind: LinkVOD, ID: "187938112"}},
+		{"https://www.twitch.tv/reckful/v/187938112", Link{Kind: LinkVOD, ID: "187938112"}},
+		{"https://player.twitch.tv/?video=v187938112&t=10m", Link{Kind: LinkVOD, ID: "187938112", Start: 10 * time.Minute}},
+		{"https://clips.twitch.tv/AwkwardHelplessSalamanderSwiftRage", Link{Kind: LinkClip, ID: "AwkwardHelplessSalamanderSwiftRage"}},
+		{"https://www.twitch.tv/reckful/clip/AwkwardHelplessSalamanderSwiftRage?filter=clips", Link{Kind: LinkClip, ID: "AwkwardHelplessSalamanderSwiftRage"}},
+		{"https://www.twitch.tv/Reckful", Link{Kind: LinkChannel, ID: "reckful"}},
+		{"https://www.twitch.tv/reckful/videos?filter=archives", Link{Kind: LinkChannel, ID: "reckful"}},
+		{"https://www.twitch.tv/collections/nWBvfBhsfRVSsA", Link{Kind: LinkCollection, ID: "nWBvfBhsfRVSsA"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseLink(tt.link)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseLink(%q) = %+v, %v, want %+v", tt.link, got, err, tt.want)
+		}
+	}
+
+	for _, link := range []string{
+		"https://www.youtube.com/watch?v=abc",
+		"https://www.twitch.tv/directory/game/Hearthstone",
+		"https://www.twitch.tv/videos/187938112?t=soon",
+		"https://www.twitch.tv/",
+		"https://player.twitch.tv/?autoplay=true",
+	} {
+		if got, err := ParseLink(link); err == nil {
+			t.Errorf("ParseLink(%q) = %+v, expected error", link, got)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package concat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ArneVogel/concat/hls"
+	"github.com/abiosoft/semaphore"
+)
+
+// number of target durations without new segments after which a stream is considered ended
+const liveStalledPolls = 15
+
+// LiveOptions describe how a live stream is recorded.
+type LiveOptions struct {
+	// Options.Start, Options.End and Options.MaxConcurrentDownloads are ignored for live streams.
+	Options
+
+	// MaxDuration stops the recording once that much of the stream is recorded.
+	// 0 records until the stream ends.
+	MaxDuration time.Duration
+
+	// PollInterval is the time between two requests of the media playlist.
+	// Defaults to half the target duration of the playlist.
+	PollInterval time.Duration
+}
+
+type liveSegment struct {
+	sequence int
+	duration float64
+	title    string
+	uri      string
+}
+
+type livePlaylist struct {
+	targetDuration float64
+	segments       []liveSegment
+	ended          bool
+}
+
+/*
+Reads the segments of a live media playlist. The segments are numbered starting with the
+media sequence of the playlist
+*/
+func parseLivePlaylist(m3u8List string) (livePlaylist, error) {
+	var playlist livePlaylist
+
+	p, err := hls.ParseMedia([]byte(m3u8List))
+	if err != nil {
+		return playlist, err
+	}
+
+	playlist.targetDuration = p.TargetDuration
+	playlist.ended = p.Ended
+	for _, s := range p.Segments {
+		playlist.segments = append(playlist.segments, liveSegment{sequence: s.Sequence, duration: s.Duration, title: s.Title, uri: s.URI})
+	}
+	return playlist, nil
+}
+
+/*
+Segments of ads twitch stitches into the stream don't have the "live" title
+*/
+func (s liveSegment) isAd() bool {
+	return s.title != "" && s.title != "live"
+}
+
+// RecordLive records the live stream of channel until the stream ends, opts.MaxDuration is reached or
+// ctx is canceled, and combines the recorded segments into a single mp4.
+func (d *Downloader) RecordLive(ctx context.Context, channel string, opts LiveOptions) error {
+	channel = strings.ToLower(channel)
+	if channel == "" {
+		return errors.New("no channel given")
+	}
+	if err := opts.checkFormat(); err != nil {
+		return err
+	}
+	if opts.Filename == "" {
+		opts.Filename = channel + "_" + time.Now().Format("2006-01-02_15-04-05")
+	}
+
+	vodSavePath := opts.savePath()
+
+	_, err := os.Stat(vodSavePath)
+
+	if err == nil || !os.IsNotExist(err) {
+		return fmt.Errorf("destination file %s already exists", vodSavePath)
+	}
+
+	d.print("Contacting Twitch Server")
+
+	token, err := d.tokenProvider().ChannelToken(ctx, channel)
+	if err != nil {
+		return fmt.Errorf("could not get access token: %v", err)
+	}
+
+	d.printDebugf("\nSig: %s, Token: %s\n", token.Signature, token.Value)
+
+	usherLink := d.usherChannelLink(channel, token)
+
+	d.printDebugf("\nusherAPILink: %s\n", usherLink)
+
+	qualities, err := d.usherQualities(ctx, usherLink)
+	if isStatus(err, http.StatusNotFound) {
+		return fmt.Errorf("%s is offline", channel)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't access usher api: %v", err)
+	}
+
+	d.printDebug(qualities)
+
+	quality, err := d.selectQuality(qualities, opts.qualitySelector())
+	if err != nil {
+		return err
+	}
+	m3u8Link := quality.URL
+
+	newpath := filepath.Join(opts.DownloadPath, "_"+opts.Filename)
+
+	err = os.MkdirAll(newpath, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("could not create directory: %v", err)
+	}
+	d.printf("Created temp dir: %s\n", newpath)
+
+	chunkCount, err := d.recordSegments(ctx, channel, m3u8Link, newpath, opts)
+	if err != nil {
+		return err
+	}
+	if chunkCount == 0 {
+		os.Remove(newpath)
+		return errors.New("no segments recorded")
+	}
+
+	d.print("\nCombining parts")
+
+	chunks := make([]int, chunkCount)
+	for i := range chunks {
+		chunks[i] = i
+	}
+	// ctx is already cancelled when the recording was stopped with ctrl+c
+	if err := d.combine(context.Background(), newpath, chunks, channel, vodSavePath, opts.Options, cut{}, opts.directAudio(quality)); err != nil {
+		return fmt.Errorf("could not combine the segments, they are kept in %s: %v", newpath, err)
+	}
+
+	d.print("Deleting chunks")
+
+	d.deleteChunks(newpath, chunkCount, 0, channel)
+
+	d.print("Deleting temp dir")
+
+	os.Remove(newpath)
+
+	d.print("All done!")
+	return nil
+}
+
+/*
+Polls the media playlist and downloads every new segment once. The segments are saved with
+consecutive chunk numbers starting at 0, the number of saved segments is returned
+*/
+func (d *Downloader) recordSegments(ctx context.Context, channel string, m3u8Link string, newpath string, opts LiveOptions) (int, error) {
+	job := &chunkJob{
+		newpath:  newpath,
+		vodID:    channel,
+		tryCount: opts.TryCount,
+		sem:      semaphore.New(1),
+	}
+
+	lastSequence := -1
+	chunkCount := 0
+	recorded := 0.0
+	stalled := 0
+
+	d.print("Starting Recording")
+
+	for {
+		m3u8List, err := d.getM3U8List(ctx, m3u8Link)
+		if ctx.Err() != nil {
+			return chunkCount, nil
+		}
+		if isStatus(err, http.StatusNotFound) {
+			d.print("\nStream ended")
+			return chunkCount, nil
+		}
+		if err != nil {
+			return chunkCount, fmt.Errorf("couldn't download m3u8 list: %v", err)
+		}
+
+		playlist, err := parseLivePlaylist(m3u8List)
+		if err != nil {
+			return chunkCount, err
+		}
+
+		newSegments := 0
+		for _, segment := range playlist.segments {
+			if segment.sequence <= lastSequence {
+				continue
+			}
+			lastSequence = segment.sequence
+			newSegments++
+
+			if segment.isAd() {
+				d.printDebugf("Skipping ad segment %d\n", segment.sequence)
+				continue
+			}
+
+			err := d.downloadChunk(ctx, job, chunkCount, resolveURI(m3u8Link, segment.uri))
+			if ctx.Err() != nil {
+				return chunkCount, nil
+			}
+			var failed *FailedChunk
+			if errors.As(err, &failed) {
+				d.printDebugf("Missing segment %d\n", segment.sequence)
+			} else if err != nil {
+				return chunkCount, err
+			} else {
+				chunkCount++
+				recorded += segment.duration
+			}
+
+			d.printf("\rRecorded %d segments (%v)", chunkCount, time.Duration(recorded)*time.Second)
+
+			if opts.MaxDuration > 0 && time.Duration(recorded*float64(time.Second)) >= opts.MaxDuration {
+				d.print("\nReached maximum duration")
+				return chunkCount, nil
+			}
+		}
+
+		if playlist.ended {
+			d.print("\nStream ended")
+			return chunkCount, nil
+		}
+
+		if newSegments == 0 {
+			stalled++
+		} else {
+			stalled = 0
+		}
+
+		pollInterval := opts.PollInterval
+		if pollInterval <= 0 {
+			pollInterval = time.Duration(playlist.targetDuration * float64(time.Second) / 2)
+		}
+		if pollInterval <= 0 {
+			pollInterval = time.Second
+		}
+
+		if playlist.targetDuration > 0 && float64(stalled)*pollInterval.Seconds() >= liveStalledPolls*playlist.targetDuration {
+			d.print("\nStream ended, no new segments")
+			return chunkCount, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return chunkCount, nil
+		case <-time.After(pollInterval):
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package concat
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const livePlaylistTemplate string = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:6
+#EXT-X-MEDIA-SEQUENCE:%d
+#EXT-X-TWITCH-ELAPSED-SECS:2000.000
+#EXT-X-PROGRAM-DATE-TIME:2020-06-01T10:00:00.000Z
+#EXTINF:2.000,live
+%s
+#EXT-X-PROGRAM-DATE-TIME:2020-06-01T10:00:02.000Z
+#EXTINF:2.000,%s
+%s
+%s`
+
+func TestParseLivePlaylist(t *testing.T) {
+	playlist, err := parseLivePlaylist(fmt.Sprintf(livePlaylistTemplate, 41, "https://example.com/a.ts", "Amazon", "b.ts", "#EXT-X-ENDLIST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if playlist.targetDuration != 6 || !playlist.ended || len(playlist.segments) != 2 {
+		t.Fatalf("unexpected playlist %+v", playlist)
+	}
+	if playlist.segments[0].sequence != 41 || playlist.segments[0].isAd() || playlist.segments[0].uri != "https://example.com/a.ts" {
+		t.Errorf("unexpected first segment %+v", playlist.segments[0])
+	}
+	if playlist.segments[1].sequence != 42 || !playlist.segments[1].isAd() || playlist.segments[1].duration != 2 {
+		t.Errorf("unexpected second segment %+v", playlist.segments[1])
+	}
+}
+
+func TestRecordSegments(t *testing.T) {
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index-live.m3u8":
+			polls++
+			switch polls {
+			case 1:
+				fmt.Fprintf(w, livePlaylistTemplate, 10, "10.ts", "live", "11.ts", "")
+			case 2:
+				fmt.Fprintf(w, livePlaylistTemplate, 11, "11.ts", "Amazon", "ad.ts", "")
+			default:
+				fmt.Fprintf(w, livePlaylistTemplate, 13, "13.ts", "live", "14.ts", "#EXT-X-ENDLIST")
+			}
+		default:
+			fmt.Fprintf(w, "segment %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "concat_live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := &Downloader{}
+	opts := LiveOptions{PollInterval: time.Millisecond}
+	chunkCount, err := d.recordSegments(context.Background(), "reckful", server.URL+"/index-live.m3u8", dir, opts)
+	if err != nil || chunkCount != 4 {
+		t.Fatalf("recordSegments: got %d, %v", chunkCount, err)
+	}
+
+	for i, want := range []string{"/10.ts", "/11.ts", "/13.ts", "/14.ts"} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("reckful_%d.ts", i)))
+		if err != nil || string(got) != "segment "+want {
+			t.Errorf("chunk %d: got %q, %v", i, got, err)
+		}
+	}
+
+	opts.MaxDuration = 3 * time.Second
+	polls = 0
+	dir, err = ioutil.TempDir("", "concat_live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	chunkCount, err = d.recordSegments(context.Background(), "reckful", server.URL+"/index-live.m3u8", dir, opts)
+	if err != nil || chunkCount != 2 {
+		t.Errorf("recordSegments with MaxDuration: got %d, %v", chunkCount, err)
+	}
+}
@@ -1,34 +1,29 @@
-package main
+package concat
 
 import (
 	"fmt"
-	"os"
 )
 
-func printDebug(msg ...interface{}) {
-	if debug {
-		fmt.Println(msg...)
+func (d *Downloader) print(msg ...interface{}) {
+	if d.Output != nil {
+		fmt.Fprintln(d.Output, msg...)
 	}
 }
 
-func printDebugf(format string, args ...interface{}) {
-	if debug {
-		fmt.Printf(format, args...)
+func (d *Downloader) printf(format string, args ...interface{}) {
+	if d.Output != nil {
+		fmt.Fprintf(d.Output, format, args...)
 	}
 }
 
-func printFatal(err error, msg ...interface{}) {
-	if len(msg) > 0 {
-		fmt.Println(msg...)
+func (d *Downloader) printDebug(msg ...interface{}) {
+	if d.Debug {
+		d.print(msg...)
 	}
-	printDebug(err)
-	os.Exit(1)
 }
 
-func printFatalf(err error, format string, args ...interface{}) {
-	if len(format) > 0 {
-		fmt.Printf(format, args...)
+func (d *Downloader) printDebugf(format string, args ...interface{}) {
+	if d.Debug {
+		d.printf(format, args...)
 	}
-	printDebug(err)
-	os.Exit(1)
 }
@@ -0,0 +1,238 @@
+package concat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const manifestFileName string = "manifest.json"
+
+// States of the chunks in a manifest.
+const (
+	chunkPending   string = "pending"
+	chunkCompleted string = "completed"
+	chunkVerified  string = "verified"
+	chunkFailed    string = "failed"
+)
+
+// mpeg-ts packets are 188 bytes long and start with the sync byte 0x47
+const tsPacketSize int = 188
+const tsSyncByte byte = 0x47
+
+/*
+manifest lists the chunks of a download and their state. It is saved in the temp dir of the download
+after every change, so an interrupted download can be resumed.
+*/
+type manifest struct {
+	VODID   string `json:"vod"`
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Quality string `json:"quality"`
+
+	// Combined is set once the chunks are combined into the final file.
+	Combined bool            `json:"combined"`
+	Chunks   []manifestChunk `json:"chunks"`
+
+	path string
+	mu   sync.Mutex
+}
+
+type manifestChunk struct {
+	Number int    `json:"number"`
+	URI    string `json:"uri"`
+	// Offset is the position of the chunk in the vod in seconds.
+	Offset   float64 `json:"offset"`
+	Duration float64 `json:"duration"`
+	State    string  `json:"state"`
+	Size     int64   `json:"size,omitempty"`
+	SHA256   string  `json:"sha256,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+func newManifest(newpath string, vodID string, opts Options) *manifest {
+	e := vodArchiveEntry(vodID, opts)
+	return &manifest{
+		VODID:   vodID,
+		Start:   e.Start,
+		End:     e.End,
+		Quality: e.Quality,
+		path:    filepath.Join(newpath, manifestFileName),
+	}
+}
+
+/*
+Reads the manifest in newpath. Returns nil if there is none or it belongs to a download with different options
+*/
+func loadManifest(newpath string, vodID string, opts Options) *manifest {
+	m := newManifest(newpath, vodID, opts)
+
+	data, err := ioutil.ReadFile(m.path)
+	if err != nil {
+		return nil
+	}
+
+	var saved manifest
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil
+	}
+	if saved.VODID != m.VODID || saved.Start != m.Start || saved.End != m.End || saved.Quality != m.Quality {
+		return nil
+	}
+
+	m.Combined = saved.Combined
+	m.Chunks = saved.Chunks
+	return m
+}
+
+/*
+Takes over the state of the chunks of a previous run that have the same number and uri.
+Completed chunks are verified against the file on disk, everything else is downloaded again.
+*/
+func (m *manifest) resume(previous *manifest, chunkPath func(number int) string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old := make(map[int]manifestChunk)
+	if previous != nil {
+		for _, c := range previous.Chunks {
+			old[c.Number] = c
+		}
+	}
+
+	resumed := 0
+	for i, c := range m.Chunks {
+		o, ok := old[c.Number]
+		if ok && o.URI == c.URI && (o.State == chunkCompleted || o.State == chunkVerified) && verifyChunk(chunkPath(c.Number), o.Size, o.SHA256) {
+			o.State = chunkVerified
+			m.Chunks[i] = o
+			resumed++
+			continue
+		}
+		m.Chunks[i].State = chunkPending
+		os.Remove(chunkPath(c.Number))
+	}
+	return resumed
+}
+
+func (m *manifest) chunk(number int) *manifestChunk {
+	for i := range m.Chunks {
+		if m.Chunks[i].Number == number {
+			return &m.Chunks[i]
+		}
+	}
+	return nil
+}
+
+func (m *manifest) isDone(number int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.chunk(number)
+	return c != nil && (c.State == chunkCompleted || c.State == chunkVerified)
+}
+
+func (m *manifest) complete(number int, body []byte) error {
+	sum := sha256.Sum256(body)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c := m.chunk(number); c != nil {
+		c.State = chunkCompleted
+		c.Size = int64(len(body))
+		c.SHA256 = hex.EncodeToString(sum[:])
+	}
+	return m.save()
+}
+
+/*
+Marks the chunk of f as failed and fills in its position in the vod
+*/
+func (m *manifest) fail(f *FailedChunk) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c := m.chunk(f.Number); c != nil {
+		c.State = chunkFailed
+		c.Error = f.Err.Error()
+		f.Start = secondsToDuration(c.Offset)
+		f.Duration = secondsToDuration(c.Duration)
+	}
+	return m.save()
+}
+
+func (m *manifest) setCombined() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Combined = true
+	return m.save()
+}
+
+func (m *manifest) saveLocked() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.save()
+}
+
+func (m *manifest) save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.path, data)
+}
+
+func (m *manifest) remove() {
+	os.Remove(m.path)
+}
+
+/*
+Checks that the chunk on disk is the one that was downloaded and looks like mpeg-ts
+*/
+func verifyChunk(path string, size int64, sha string) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || int64(len(data)) != size {
+		return false
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) == sha && looksLikeTS(data)
+}
+
+func looksLikeTS(data []byte) bool {
+	return len(data) >= tsPacketSize && data[0] == tsSyncByte
+}
+
+/*
+Writes data to a temp file next to path and renames it to path, so path is never half written
+*/
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package concat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file")
+	for _, content := range []string{"first", "second"} {
+		if err := writeFileAtomic(path, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ioutil.ReadFile(path); err != nil || string(got) != content {
+			t.Errorf("got %q, %v, want %q", got, err, content)
+		}
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("temp files were left behind: %v", files)
+	}
+}
+
+// interruptedDownload sets up the temp dir of a download of chunks 0 to 3 that was interrupted
+func interruptedDownload(t *testing.T, dir string, opts Options) *manifest {
+	newpath := filepath.Join(dir, "_"+vodString)
+	if err := os.MkdirAll(newpath, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newManifest(newpath, vodString, opts)
+	for i := 0; i < 4; i++ {
+		m.Chunks = append(m.Chunks, manifestChunk{Number: i, URI: fmt.Sprintf("%d.ts", i), Duration: 10, State: chunkPending})
+	}
+
+	// chunk 0 is complete, chunk 1 was cut off after it was completed and chunk 2 is half written
+	for i := 0; i < 2; i++ {
+		if err := ioutil.WriteFile(chunkPath(newpath, vodString, i), fakeChunk(i), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.complete(i, fakeChunk(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(chunkPath(newpath, vodString, 1), fakeChunk(1)[:100], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(chunkPath(newpath, vodString, 2), fakeChunk(2)[:100], 0644); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestResumeDownload(t *testing.T) {
+	server := newVODServer(t, 4)
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	interruptedDownload(t, dir, opts)
+
+	// a half written output of the interrupted run
+	vodSavePath := filepath.Join(dir, vodString+".mp4")
+	if err := ioutil.WriteFile(vodSavePath, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newTestDownloader(t, server, dir)
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []int{0, 1, 1, 1} {
+		if got := server.requests(server.chunkPath(i)); got != want {
+			t.Errorf("chunk %d was requested %d times, want %d", i, got, want)
+		}
+	}
+
+	var want []byte
+	for i := 0; i < 4; i++ {
+		want = append(want, fakeChunk(i)...)
+	}
+	got, err := ioutil.ReadFile(vodSavePath)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("got %d bytes, %v, want all chunks", len(got), err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "_"+vodString)); !os.IsNotExist(err) {
+		t.Errorf("temp dir wasn't deleted: %v", err)
+	}
+}
+
+func TestResumeCombinedDownload(t *testing.T) {
+	server := newVODServer(t, 4)
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	m := interruptedDownload(t, dir, opts)
+	if err := m.setCombined(); err != nil {
+		t.Fatal(err)
+	}
+	vodSavePath := filepath.Join(dir, vodString+".mp4")
+	if err := ioutil.WriteFile(vodSavePath, []byte("combined"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newTestDownloader(t, server, dir)
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(server.requested) != 0 {
+		t.Errorf("expected no requests, got %v", server.requested)
+	}
+	if got, _ := ioutil.ReadFile(vodSavePath); string(got) != "combined" {
+		t.Errorf("output was changed to %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "_"+vodString)); !os.IsNotExist(err) {
+		t.Errorf("temp dir wasn't deleted: %v", err)
+	}
+}
+
+func TestLoadManifestWithOtherOptions(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	interruptedDownload(t, dir, opts)
+
+	newpath := filepath.Join(dir, "_"+vodString)
+	m := loadManifest(newpath, vodString, opts)
+	if m == nil || len(m.Chunks) != 4 || m.Chunks[0].State != chunkCompleted {
+		t.Fatalf("could not load manifest: %+v", m)
+	}
+
+	data, _ := ioutil.ReadFile(filepath.Join(newpath, manifestFileName))
+	var saved map[string]interface{}
+	if err := json.Unmarshal(data, &saved); err != nil || saved["vod"] != vodString {
+		t.Errorf("unexpected manifest %s", data)
+	}
+
+	opts.Quality = "720p30"
+	if m := loadManifest(newpath, vodString, opts); m != nil {
+		t.Errorf("loaded manifest of a download in a different quality")
+	}
+}
@@ -0,0 +1,384 @@
+package concat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Formats of Options.Format.
+const (
+	FormatMP4 string = "mp4"
+	FormatTS  string = "ts"
+	FormatMKV string = "mkv"
+	FormatMOV string = "mov"
+)
+
+// Video encoders of EncodeOptions.Codec.
+const (
+	CodecH264 string = "libx264"
+	CodecH265 string = "libx265"
+	CodecAV1  string = "libsvtav1"
+)
+
+// Audio formats of Options.AudioFormat.
+const (
+	// AudioFormatM4A copies the AAC audio of twitch without re-encoding.
+	AudioFormatM4A string = "m4a"
+	AudioFormatMP3 string = "mp3"
+	// AudioFormatOpus writes opus in an ogg container.
+	AudioFormatOpus string = "opus"
+	AudioFormatFLAC string = "flac"
+)
+
+// Muxer turns the downloaded chunks into the output files. FFmpegMuxer is used if
+// Downloader.Muxer is nil, BuiltinMuxer works without external tools.
+type Muxer interface {
+	// Combine joins the chunk files inputs in order into output. The format of output is chosen
+	// by its extension.
+	Combine(ctx context.Context, inputs []string, output string, opts CombineOptions) error
+
+	// ExtractAudio writes the audio of input into output, the format is chosen by the extension of output.
+	ExtractAudio(ctx context.Context, input string, output string, opts AudioOptions) error
+
+	// Probe returns the duration and the streams of a media file.
+	Probe(ctx context.Context, path string) (*MediaInfo, error)
+}
+
+// CombineOptions are the options of Muxer.Combine.
+type CombineOptions struct {
+	// Start and Duration are the part of the joined chunks that is kept, relative to the beginning
+	// of the first chunk. A Duration of 0 keeps everything after Start.
+	Start    time.Duration
+	Duration time.Duration
+
+	// SmartCut asks for an output that starts and ends exactly at Start and Duration. Otherwise
+	// and for muxers that can't re-encode, the output starts at the keyframe before Start.
+	SmartCut bool
+
+	// Encode re-encodes the video instead of copying it.
+	Encode EncodeOptions
+
+	// Subtitles is a subtitle file that is added as a track, its times start at Start.
+	Subtitles string
+
+	// Overlay is a list of transparent images for the concat demuxer of ffmpeg that is drawn
+	// over the video, its times start at Start. OverlayPosition is the corner of the video it is
+	// drawn in, like ChatBottomLeft. The video is re-encoded with Encode.
+	Overlay         string
+	OverlayPosition string
+}
+
+// AudioOptions are the options of Muxer.ExtractAudio.
+type AudioOptions struct {
+	// Bitrate of lossy formats like mp3 and opus in kbit/s. The default of the encoder is used if 0.
+	Bitrate int
+}
+
+// EncodeOptions re-encode the video, for smaller copies of a vod. The audio is still copied.
+// The zero value copies the video.
+type EncodeOptions struct {
+	// Codec is the ffmpeg video encoder, like CodecH264, CodecH265 or CodecAV1.
+	// CodecH264 is used if it is empty and another option is set.
+	Codec string
+
+	// CRF is the constant rate factor, lower values give a better quality and bigger files.
+	// The default of the encoder is used if 0.
+	CRF int
+
+	// Preset trades encoding speed for file size, like veryfast or slow for x264 and x265
+	// and 0 to 13 for SVT-AV1. The default of the encoder is used if empty.
+	Preset string
+
+	// Height scales the video to this height and keeps the aspect ratio. Not scaled if 0.
+	Height int
+}
+
+func (e EncodeOptions) enabled() bool {
+	return e.Codec != "" || e.CRF > 0 || e.Preset != "" || e.Height > 0
+}
+
+func (e EncodeOptions) codec() string {
+	if e.Codec == "" {
+		return CodecH264
+	}
+	return e.Codec
+}
+
+/*
+Returns an error for formats the muxers can't write and for invalid encode options
+*/
+func (o Options) checkFormat() error {
+	switch o.format() {
+	case FormatMP4, FormatTS, FormatMKV, FormatMOV:
+	default:
+		return fmt.Errorf("unknown format %q, use mp4, ts, mkv or mov", o.Format)
+	}
+	if o.Encode.CRF < 0 || o.Encode.Height < 0 {
+		return fmt.Errorf("invalid encode options %+v", o.Encode)
+	}
+	switch o.audioFormat() {
+	case AudioFormatM4A, AudioFormatMP3, AudioFormatOpus, AudioFormatFLAC:
+	default:
+		return fmt.Errorf("unknown audio format %q, use m4a, mp3, opus or flac", o.AudioFormat)
+	}
+	if o.AudioBitrate < 0 {
+		return fmt.Errorf("invalid audio bitrate %d", o.AudioBitrate)
+	}
+	switch o.Subtitles {
+	case "", SubtitlesSRT, SubtitlesASS:
+	default:
+		return fmt.Errorf("unknown subtitle format %q, use srt or ass", o.Subtitles)
+	}
+	if _, err := o.SubtitleOptions.alignment(); err != nil {
+		return err
+	}
+	if o.Subtitles != "" && !o.Chat {
+		return errors.New("subtitles need the chat")
+	}
+	if o.MuxSubtitles && o.Subtitles == "" {
+		return errors.New("muxing subtitles needs a subtitle format")
+	}
+	if o.MuxSubtitles && o.format() == FormatTS {
+		return errors.New("ts files can't hold the chat subtitles, use mp4, mov or mkv")
+	}
+	if o.Assets != nil && !o.Chat {
+		return errors.New("emotes and badges need the chat")
+	}
+	if o.ChatHTML && !o.Chat {
+		return errors.New("the html chat replay needs the chat")
+	}
+	if o.ChatHTML && o.Assets == nil {
+		return errors.New("the html chat replay needs an asset cache for its emotes and badges")
+	}
+	if o.EmbedAssets && o.Assets == nil {
+		return errors.New("embedding emotes and badges needs an asset cache")
+	}
+	if o.BurnChat {
+		if !o.Chat {
+			return errors.New("burning the chat in needs the chat")
+		}
+		if o.AudioOnly {
+			return errors.New("burning the chat in needs the video, not only the audio")
+		}
+		if hardwareEncoder(o.Encode.codec()) {
+			return fmt.Errorf("the chat is burned in with software encoding, %s is a hardware encoder", o.Encode.codec())
+		}
+		if err := o.Overlay.check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Reports whether codec is an ffmpeg encoder that runs on a GPU or other hardware, like h264_nvenc
+*/
+func hardwareEncoder(codec string) bool {
+	for _, suffix := range []string{"_nvenc", "_qsv", "_vaapi", "_videotoolbox", "_amf", "_v4l2m2m", "_mf", "_vulkan", "_omx", "_mediacodec"} {
+		if strings.HasSuffix(codec, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o Options) audioFormat() string {
+	if o.AudioFormat == "" {
+		return AudioFormatMP3
+	}
+	return o.AudioFormat
+}
+
+/*
+Returns the path of the audio file next to the video at vodSavePath
+*/
+func (o Options) audioSavePath(vodSavePath string) string {
+	return strings.TrimSuffix(vodSavePath, filepath.Ext(vodSavePath)) + "." + o.audioFormat()
+}
+
+/*
+Reports whether the chunks of q are combined straight into the audio file. That works for audio
+only renditions if the audio is copied anyway
+*/
+func (o Options) directAudio(q Quality) bool {
+	return o.AudioOnly && q.audioOnly() && o.audioFormat() == AudioFormatM4A
+}
+
+/*
+Returns the quality selector for o. Audio only downloads prefer the audio only rendition, so no
+video is downloaded just to be thrown away
+*/
+func (o Options) qualitySelector() string {
+	if o.AudioOnly {
+		return AudioOnlyQuality + "," + o.Quality
+	}
+	return o.Quality
+}
+
+// MediaInfo is the result of Muxer.Probe.
+type MediaInfo struct {
+	Duration time.Duration
+	Streams  []StreamInfo
+}
+
+// StreamInfo is a stream of a media file.
+type StreamInfo struct {
+	// Type is video, audio or data.
+	Type string
+	// Codec is the name ffmpeg uses for the codec, like h264 or aac.
+	Codec string
+	// Width and Height of video streams.
+	Width  int
+	Height int
+}
+
+func (d *Downloader) muxer() Muxer {
+	if d.Muxer != nil {
+		return d.Muxer
+	}
+	return &FFmpegMuxer{FFmpegCmd: d.FFmpegCmd, FFprobeCmd: d.FFprobeCmd, Output: d.Output, Debug: d.Debug}
+}
+
+/*
+Combines the chunks into vodSavePath and extracts the audio if opts asks for it. With direct the
+chunks are combined straight into the audio file instead. A partial output file is removed if
+combining fails
+*/
+func (d *Downloader) combine(ctx context.Context, newpath string, chunks []int, vodID string, vodSavePath string, opts Options, c cut, direct bool) error {
+	var inputs []string
+	for _, i := range chunks {
+		inputs = append(inputs, chunkPath(newpath, vodID, i))
+	}
+	output := vodSavePath
+	if direct {
+		output = opts.audioSavePath(vodSavePath)
+	}
+
+	combineOpts := CombineOptions{
+		Start:    secondsToDuration(c.start),
+		Duration: secondsToDuration(c.duration),
+		SmartCut: opts.SmartCut,
+		Encode:   opts.Encode,
+	}
+	if opts.MuxSubtitles && !direct {
+		combineOpts.Subtitles = subtitleSavePath(vodSavePath, opts)
+		// live recordings have no chat
+		if _, err := os.Stat(combineOpts.Subtitles); err != nil {
+			combineOpts.Subtitles = ""
+		}
+	}
+	if opts.BurnChat && !direct {
+		overlay, err := d.chatOverlay(newpath, vodSavePath, opts)
+		if err != nil {
+			return err
+		}
+		if overlay != "" {
+			defer os.RemoveAll(filepath.Dir(overlay))
+			combineOpts.Overlay = overlay
+			combineOpts.OverlayPosition = opts.Overlay.position()
+		}
+	}
+	muxer := d.muxer()
+	if _, builtin := muxer.(BuiltinMuxer); builtin && opts.SmartCut && !c.isZero() {
+		d.print("Smart cut needs ffmpeg, cutting at keyframes instead")
+	}
+	if err := muxer.Combine(ctx, inputs, output, combineOpts); err != nil {
+		os.Remove(output)
+		return err
+	}
+	if _, err := os.Stat(output); err != nil {
+		return fmt.Errorf("muxer didn't write %s: %v", output, err)
+	}
+
+	if direct {
+		return nil
+	}
+	return d.extractAudio(ctx, vodSavePath, opts)
+}
+
+/*
+Draws the chat saved next to vodSavePath into a temp dir in newpath and returns the list of the
+images for CombineOptions.Overlay. Returns an empty list if there is no chat, like for live recordings
+*/
+func (d *Downloader) chatOverlay(newpath string, vodSavePath string, opts Options) (string, error) {
+	chatPath := chatSavePath(vodSavePath)
+	if _, err := os.Stat(chatPath); err != nil {
+		return "", nil
+	}
+	chat, err := ReadChat(chatPath)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(newpath, "chat_overlay")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	overlay := opts.Overlay
+	if overlay.Images == nil && chat.Assets != nil {
+		overlay.Images = opts.Assets.Images(chat.Assets)
+	}
+	list, err := d.renderOverlay(chat, dir, overlay)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("could not render the chat overlay: %v", err)
+	}
+	return list, nil
+}
+
+/*
+Extracts the audio of vodSavePath in opts.AudioFormat if opts.Audio or opts.AudioOnly is set.
+Deletes vodSavePath for opts.AudioOnly
+*/
+func (d *Downloader) extractAudio(ctx context.Context, vodSavePath string, opts Options) error {
+	if !opts.Audio && !opts.AudioOnly {
+		return nil
+	}
+	d.print("Extracting audio...")
+
+	audioPath := opts.audioSavePath(vodSavePath)
+	if err := d.muxer().ExtractAudio(ctx, vodSavePath, audioPath, AudioOptions{Bitrate: opts.AudioBitrate}); err != nil {
+		os.Remove(audioPath)
+		return fmt.Errorf("could not extract audio: %v", err)
+	}
+
+	if opts.AudioOnly {
+		os.Remove(vodSavePath)
+	}
+	return nil
+}
+
+func (d *Downloader) deleteChunks(newpath string, chunkCount int, startChunk int, vodID string) {
+	for i := startChunk; i < (startChunk + chunkCount); i++ {
+		err := os.Remove(chunkPath(newpath, vodID, i))
+		if err != nil {
+			d.print("Could not delete all chunks, try manually deleting them", err)
+		}
+	}
+}
+
+/*
+Warns if the output is a lot shorter than expected, that happens when chunks are broken.
+Outputs that can't be probed aren't checked
+*/
+func (d *Downloader) checkOutput(ctx context.Context, path string, expected time.Duration) {
+	info, err := d.muxer().Probe(ctx, path)
+	if err != nil {
+		d.printDebug("Could not probe the output:", err)
+		return
+	}
+	d.printDebugf("Output: %s, streams: %+v\n", info.Duration, info.Streams)
+
+	if expected > 0 && info.Duration+outputTolerance < expected {
+		d.printf("Warning: %s is %s long, expected %s\n", path, info.Duration, expected)
+	}
+}
+
+// outputTolerance is how much shorter than expected the output can be, the cut at the keyframe
+// and the rounding of chunk durations make it differ a bit
+const outputTolerance = 5 * time.Second
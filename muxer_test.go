@@ -0,0 +1,86 @@
+package concat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMuxer records the calls of Combine and fails with err
+type fakeMuxer struct {
+	err      error
+	inputs   []string
+	opts     CombineOptions
+	duration time.Duration
+}
+
+func (m *fakeMuxer) Combine(ctx context.Context, inputs []string, output string, opts CombineOptions) error {
+	m.inputs, m.opts = inputs, opts
+	if m.err != nil {
+		// a partial output like ffmpeg leaves behind
+		ioutil.WriteFile(output, []byte("partial"), 0644)
+		return m.err
+	}
+	return ioutil.WriteFile(output, []byte("combined"), 0644)
+}
+
+func (m *fakeMuxer) ExtractAudio(ctx context.Context, input string, output string, opts AudioOptions) error {
+	return errors.New("not supported")
+}
+
+func (m *fakeMuxer) Probe(ctx context.Context, path string) (*MediaInfo, error) {
+	return &MediaInfo{Duration: m.duration}, nil
+}
+
+func TestDownloadCombineFails(t *testing.T) {
+	server := newVODServer(t, 4)
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	muxer := &fakeMuxer{err: errors.New("disk full")}
+	d := newTestDownloader(t, server, dir)
+	d.Muxer = muxer
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	opts.Start = 15 * time.Second
+
+	err := d.Download(context.Background(), vodString, opts)
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("expected the error of the muxer, got %v", err)
+	}
+	if len(muxer.inputs) != 3 || muxer.opts.Start != 5*time.Second || muxer.opts.Duration != 0 {
+		t.Errorf("unexpected combine of %q with %+v", muxer.inputs, muxer.opts)
+	}
+	for _, input := range muxer.inputs {
+		if _, err := os.Stat(input); err != nil {
+			t.Errorf("chunk wasn't kept: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, vodString+".mp4")); !os.IsNotExist(err) {
+		t.Errorf("partial output wasn't removed: %v", err)
+	}
+
+	// the next run combines the kept chunks without downloading them again
+	var out bytes.Buffer
+	muxer.err = nil
+	muxer.duration = 10 * time.Second
+	d.Output = &out
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{0, 1, 1, 1} {
+		if got := server.requests(server.chunkPath(i)); got != want {
+			t.Errorf("chunk %d was requested %d times, want %d", i, got, want)
+		}
+	}
+	if !strings.Contains(out.String(), "Warning:") {
+		t.Errorf("output that is 15 seconds too short wasn't reported:\n%s", out.String())
+	}
+}
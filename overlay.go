@@ -0,0 +1,507 @@
+package concat
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // emotes and badges
+	_ "image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ArneVogel/concat/ttf"
+)
+
+// OverlayOptions control the chat box that Options.BurnChat draws into the video. Sizes are in
+// pixels of the output video. Zero values use the defaults.
+type OverlayOptions struct {
+	// Position of the chat box, like ChatBottomLeft or ChatTopRight. Defaults to ChatBottomLeft.
+	Position string
+
+	// Width and Height of the chat box. Default to 400 and 600.
+	Width  int
+	Height int
+
+	// Font is a TrueType font file, .ttf or .ttc. Defaults to Arial or DejaVu Sans of the system.
+	Font     string
+	FontSize int
+
+	// Opacity of the black background of the chat box from 0 to 1. Defaults to 0.5,
+	// negative values leave out the background.
+	Opacity float64
+
+	// Duration a message stays in the chat box. With 0 messages stay until newer ones push them out.
+	Duration time.Duration
+
+	// Images has the emotes and badges. Without it the images of the ChatAssets of the chat are used,
+	// if it has none emotes are drawn as text and badges are left out.
+	Images ChatImages
+}
+
+func (o OverlayOptions) position() string {
+	if o.Position == "" {
+		return ChatBottomLeft
+	}
+	return o.Position
+}
+
+func (o OverlayOptions) width() int {
+	if o.Width <= 0 {
+		return 400
+	}
+	return o.Width
+}
+
+func (o OverlayOptions) height() int {
+	if o.Height <= 0 {
+		return 600
+	}
+	return o.Height
+}
+
+func (o OverlayOptions) fontSize() int {
+	if o.FontSize <= 0 {
+		return 20
+	}
+	return o.FontSize
+}
+
+func (o OverlayOptions) opacity() float64 {
+	switch {
+	case o.Opacity < 0:
+		return 0
+	case o.Opacity == 0:
+		return 0.5
+	}
+	return o.Opacity
+}
+
+/*
+Returns an error for options the overlay can't be drawn with
+*/
+func (o OverlayOptions) check() error {
+	if _, err := chatAlignment(o.position()); err != nil {
+		return err
+	}
+	if o.Width < 0 || o.Height < 0 || o.FontSize < 0 || o.Opacity > 1 || o.Duration < 0 {
+		return fmt.Errorf("invalid overlay options %+v", o)
+	}
+	return nil
+}
+
+// defaultFonts are tried in order if OverlayOptions.Font is empty
+var defaultFonts = map[string][]string{
+	"windows": {`C:\Windows\Fonts\arial.ttf`, `C:\Windows\Fonts\segoeui.ttf`},
+	"darwin":  {"/System/Library/Fonts/Supplemental/Arial.ttf", "/Library/Fonts/Arial.ttf"},
+	"linux": {
+		"/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf",
+		"/usr/share/fonts/TTF/DejaVuSans.ttf",
+		"/usr/share/fonts/dejavu/DejaVuSans.ttf",
+		"/usr/share/fonts/truetype/liberation/LiberationSans-Regular.ttf",
+	},
+}
+
+func (o OverlayOptions) loadFont() (*ttf.Font, error) {
+	if o.Font != "" {
+		return ttf.Load(o.Font)
+	}
+	for _, path := range defaultFonts[runtime.GOOS] {
+		if _, err := os.Stat(path); err == nil {
+			return ttf.Load(path)
+		}
+	}
+	return nil, errors.New("no font found for the chat overlay, choose a .ttf file")
+}
+
+// ChatImages has the images of emotes and badges for the chat overlay.
+type ChatImages interface {
+	// Emote returns the image of the twitch emote with id, nil if there is none.
+	Emote(id string) image.Image
+
+	// NamedEmote returns the image of the emote of another provider like BTTV that chatters
+	// type as name, nil if there is none.
+	NamedEmote(name string) image.Image
+
+	// Badge returns the image of version of the badge set, like subscriber and 12, nil if there is none.
+	Badge(setID string, version string) image.Image
+}
+
+// ImageDir is ChatImages of a directory with the files emotes/<id>.png, emotes/<name>.png and
+// badges/<set id>/<version>.png. PNG, GIF and JPEG images are read, animated GIFs show their first frame.
+type ImageDir string
+
+// Emote reads the image of the emote id.
+func (dir ImageDir) Emote(id string) image.Image {
+	if !assetID.MatchString(id) {
+		return nil
+	}
+	return readImage(filepath.Join(string(dir), "emotes", id))
+}
+
+// NamedEmote reads the image of the emote name.
+func (dir ImageDir) NamedEmote(name string) image.Image {
+	// names are typed by chatters and must not leave the directory
+	if name != filepath.Base(name) || name == ".." {
+		return nil
+	}
+	return readImage(filepath.Join(string(dir), "emotes", name))
+}
+
+// Badge reads the image of version of the badge set.
+func (dir ImageDir) Badge(setID string, version string) image.Image {
+	if !assetID.MatchString(setID) || !assetID.MatchString(version) {
+		return nil
+	}
+	return readImage(filepath.Join(string(dir), "badges", setID, version))
+}
+
+/*
+Decodes the image at path with the extension png, gif or jpg. Returns nil if there is none
+*/
+func readImage(path string) image.Image {
+	for _, ext := range []string{".png", ".gif", ".jpg"} {
+		f, err := os.Open(path + ext)
+		if err != nil {
+			continue
+		}
+		img, _, err := image.Decode(f)
+		f.Close()
+		if err == nil {
+			return img
+		}
+	}
+	return nil
+}
+
+// the space between the chat box and the edges of the video, and the messages and the edges of the box
+const (
+	overlayMargin  = 20
+	overlayPadding = 8
+)
+
+// overlayRenderer draws the messages and frames of the chat overlay
+type overlayRenderer struct {
+	opts OverlayOptions
+	face *ttf.Face
+
+	lineHeight  int
+	imageHeight int
+	spaceWidth  float64
+
+	// images are the scaled emotes and badges, nil if there is none
+	images map[string]image.Image
+}
+
+func newOverlayRenderer(opts OverlayOptions) (*overlayRenderer, error) {
+	font, err := opts.loadFont()
+	if err != nil {
+		return nil, err
+	}
+	face := ttf.NewFace(font, float64(opts.fontSize()))
+	imageHeight := int(math.Ceil(face.LineHeight()))
+	return &overlayRenderer{
+		opts:        opts,
+		face:        face,
+		imageHeight: imageHeight,
+		lineHeight:  imageHeight + 2,
+		spaceWidth:  face.Advance(' '),
+		images:      make(map[string]image.Image),
+	}, nil
+}
+
+/*
+Returns the emote or badge for key scaled to the height of a line. load is only called the first time
+*/
+func (r *overlayRenderer) image(key string, load func() image.Image) image.Image {
+	if img, ok := r.images[key]; ok {
+		return img
+	}
+	var img image.Image
+	if r.opts.Images != nil {
+		if src := load(); src != nil && src.Bounds().Dy() > 0 {
+			img = scaleImage(src, src.Bounds().Dx()*r.imageHeight/src.Bounds().Dy(), r.imageHeight)
+		}
+	}
+	r.images[key] = img
+	return img
+}
+
+// overlayToken is a word, an emote or a badge of a message
+type overlayToken struct {
+	text  string
+	color color.Color
+	image image.Image
+	// glue puts the token right after the one before it, without a space
+	glue bool
+}
+
+func (r *overlayRenderer) tokens(c ChatComment) []overlayToken {
+	var tokens []overlayToken
+	for _, b := range c.Badges {
+		b := b
+		if img := r.image("badge/"+b.SetID+"/"+b.Version, func() image.Image { return r.opts.Images.Badge(b.SetID, b.Version) }); img != nil {
+			tokens = append(tokens, overlayToken{image: img})
+		}
+	}
+	name, _ := parseColor(c.nameColor())
+	tokens = append(tokens, overlayToken{text: c.Name(), color: name}, overlayToken{text: ":", color: color.White, glue: true})
+
+	for _, f := range c.Fragments {
+		if f.EmoteID != "" {
+			id := f.EmoteID
+			if img := r.image("emote/"+id, func() image.Image { return r.opts.Images.Emote(id) }); img != nil {
+				tokens = append(tokens, overlayToken{image: img})
+				continue
+			}
+		}
+		for _, word := range strings.Fields(f.Text) {
+			word := word
+			if img := r.image("named/"+word, func() image.Image { return r.opts.Images.NamedEmote(word) }); img != nil {
+				tokens = append(tokens, overlayToken{image: img})
+				continue
+			}
+			tokens = append(tokens, overlayToken{text: word, color: color.White})
+		}
+	}
+	return tokens
+}
+
+func (r *overlayRenderer) tokenWidth(t overlayToken) float64 {
+	if t.image != nil {
+		return float64(t.image.Bounds().Dx())
+	}
+	return r.face.Measure(t.text)
+}
+
+/*
+Draws the message c wrapped to the width of the chat box, on a transparent image as high as its lines
+*/
+func (r *overlayRenderer) message(c ChatComment) *image.RGBA {
+	type placed struct {
+		overlayToken
+		x    float64
+		line int
+	}
+	width := float64(r.opts.width() - 2*overlayPadding)
+
+	var layout []placed
+	x, line := 0.0, 0
+	for _, t := range r.tokens(c) {
+		if x > 0 && !t.glue {
+			x += r.spaceWidth
+		}
+		w := r.tokenWidth(t)
+		if x > 0 && x+w > width {
+			x, line = 0, line+1
+		}
+		// words longer than a line are split where the line ends
+		for t.image == nil && x+w > width && utf8.RuneCountInString(t.text) > 1 {
+			fit := 1
+			for i := range t.text {
+				if i > 0 && x+r.face.Measure(t.text[:i]) > width {
+					break
+				}
+				fit = i
+			}
+			if fit == 0 {
+				_, fit = utf8.DecodeRuneInString(t.text)
+			}
+			head := t
+			head.text = t.text[:fit]
+			layout = append(layout, placed{head, x, line})
+			t.text = t.text[fit:]
+			w = r.tokenWidth(t)
+			x, line = 0, line+1
+		}
+		layout = append(layout, placed{t, x, line})
+		x += w
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, int(width), (line+1)*r.lineHeight))
+	ascent, descent := r.face.Ascent(), r.face.Descent()
+	for _, p := range layout {
+		top := p.line * r.lineHeight
+		if p.image != nil {
+			at := image.Pt(int(math.Round(p.x)), top+(r.lineHeight-p.image.Bounds().Dy())/2)
+			draw.Draw(img, p.image.Bounds().Sub(p.image.Bounds().Min).Add(at), p.image, p.image.Bounds().Min, draw.Over)
+			continue
+		}
+		baseline := float64(top) + (float64(r.lineHeight)-ascent-descent)/2 + ascent
+		r.face.Draw(img, p.color, p.x, baseline, p.text)
+	}
+	return img
+}
+
+/*
+Draws a frame of the chat box with messages, the last one at the bottom. Messages that don't fit are cut off at the top
+*/
+func (r *overlayRenderer) frame(messages []*image.RGBA) *image.RGBA {
+	frame := image.NewRGBA(image.Rect(0, 0, r.opts.width(), r.opts.height()))
+	if opacity := r.opts.opacity(); opacity > 0 {
+		background := color.NRGBA{A: uint8(opacity*255 + 0.5)}
+		draw.Draw(frame, frame.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	}
+
+	box := frame.SubImage(image.Rect(overlayPadding, overlayPadding, r.opts.width()-overlayPadding, r.opts.height()-overlayPadding)).(*image.RGBA)
+	y := box.Bounds().Max.Y
+	for i := len(messages) - 1; i >= 0 && y > box.Bounds().Min.Y; i-- {
+		y -= messages[i].Bounds().Dy()
+		draw.Draw(box, messages[i].Bounds().Add(image.Pt(overlayPadding, y)), messages[i], image.Point{}, draw.Over)
+		y -= r.lineHeight / 4
+	}
+	return frame
+}
+
+/*
+Draws the chat overlay into dir as one image per change of the chat box and returns the path of a
+list for the concat demuxer of ffmpeg that shows every image as long as the chat box looks like it
+*/
+func (d *Downloader) renderOverlay(chat *Chat, dir string, opts OverlayOptions) (string, error) {
+	r, err := newOverlayRenderer(opts)
+	if err != nil {
+		return "", err
+	}
+	duration := math.Inf(1)
+	if opts.Duration > 0 {
+		duration = opts.Duration.Seconds()
+	}
+	// every message takes at least a line
+	events := chatEvents(chat.Comments, duration, opts.height()/r.lineHeight+1)
+
+	var list strings.Builder
+	list.WriteString("ffconcat version 1.0\n")
+	// the times are rounded to milliseconds before taking the difference, so they don't drift
+	entry := func(path string, start float64, end float64) {
+		abs, _ := filepath.Abs(path)
+		list.WriteString("file '" + abs + "'\n")
+		if !math.IsInf(end, 1) {
+			list.WriteString("duration " + formatSeconds(math.Round(end*1000)/1000-math.Round(start*1000)/1000) + "\n")
+		}
+	}
+
+	empty := filepath.Join(dir, "chat_empty.png")
+	if err := writePNG(empty, r.frame(nil)); err != nil {
+		return "", err
+	}
+
+	messages := make(map[*ChatComment]*image.RGBA)
+	last, frames := 0.0, 0
+	for i := 0; i < len(events); i++ {
+		e := events[i]
+		// spans that show the same messages are one image
+		for i+1 < len(events) && events[i+1].start == e.end && sameComments(events[i+1].comments, e.comments) {
+			e.end = events[i+1].end
+			i++
+		}
+		if e.start > last {
+			entry(empty, last, e.start)
+		}
+
+		// the images of messages are kept as long as they are shown
+		visible := make(map[*ChatComment]*image.RGBA)
+		var images []*image.RGBA
+		for j := range e.comments {
+			c := &e.comments[j]
+			img := messages[c]
+			if img == nil {
+				img = r.message(*c)
+			}
+			visible[c] = img
+			images = append(images, img)
+		}
+		messages = visible
+
+		frames++
+		path := filepath.Join(dir, fmt.Sprintf("chat_%06d.png", frames))
+		if err := writePNG(path, r.frame(images)); err != nil {
+			return "", err
+		}
+		entry(path, e.start, e.end)
+		last = e.end
+		d.printf("\rRendering the chat overlay: %d/%d", i+1, len(events))
+	}
+	// the last image is shown till the end of the video
+	if !math.IsInf(last, 1) {
+		entry(empty, last, math.Inf(1))
+	}
+	d.print("")
+
+	listPath := filepath.Join(dir, "chat_overlay.txt")
+	if err := ioutil.WriteFile(listPath, []byte(list.String()), 0644); err != nil {
+		return "", err
+	}
+	return listPath, nil
+}
+
+/*
+Reports whether a and b are the same comments of chatEvents
+*/
+func sameComments(a, b []ChatComment) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	err = encoder.Encode(f, img)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+/*
+Parses a color like #FF4500
+*/
+func parseColor(s string) (color.Color, error) {
+	var c color.RGBA
+	if _, err := fmt.Sscanf(s, "#%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+		return color.White, err
+	}
+	c.A = 0xff
+	return c, nil
+}
+
+/*
+Scales src to width and height, every pixel is the average of the pixels of src it covers
+*/
+func scaleImage(src image.Image, width int, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	b := src.Bounds()
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := b.Min.Y + (y+1)*b.Dy()/height
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := b.Min.X + (x+1)*b.Dx()/width
+			if x1 == x0 {
+				x1++
+			}
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+pr, g+pg, bl+pb, a+pa, n+1
+				}
+			}
+			// RGBA returns premultiplied 16 bit values
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(bl / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
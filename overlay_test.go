@@ -0,0 +1,194 @@
+package concat
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFont only has glyphs for A, B, C and the space
+var testFont = filepath.Join("ttf", "testdata", "test.ttf")
+
+func writeTestImage(t *testing.T, path string, c color.Color) {
+	img := image.NewRGBA(image.Rect(0, 0, 28, 28))
+	for y := 0; y < 28; y++ {
+		for x := 0; x < 28; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePNG(path, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestImage(t *testing.T, path string) image.Image {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+/*
+Reports whether img has an opaque pixel of color c
+*/
+func hasColor(img image.Image, c color.RGBA) bool {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.RGBAModel.Convert(img.At(x, y)) == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestImageDirPaths(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	images := ImageDir(filepath.Join(dir, "images"))
+	writeTestImage(t, filepath.Join(dir, "images", "emotes", "emotesv2_1a2b.png"), color.RGBA{R: 255, A: 255})
+	writeTestImage(t, filepath.Join(dir, "images", "badges", "sub-gifter", "1.png"), color.RGBA{B: 255, A: 255})
+	writeTestImage(t, filepath.Join(dir, "secret.png"), color.RGBA{G: 255, A: 255})
+
+	if images.Emote("emotesv2_1a2b") == nil || images.Badge("sub-gifter", "1") == nil {
+		t.Error("images in the directory can't be read")
+	}
+	// ids of chat files must not leave the directory
+	if images.Emote("../../secret") != nil || images.Badge("..", "../secret") != nil || images.NamedEmote("../../secret") != nil {
+		t.Error("read an image outside of the directory")
+	}
+}
+
+func TestRenderOverlay(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	images := filepath.Join(dir, "images")
+	writeTestImage(t, filepath.Join(images, "emotes", "25.png"), color.RGBA{R: 255, A: 255})
+	writeTestImage(t, filepath.Join(images, "badges", "subscriber", "12.png"), color.RGBA{B: 255, A: 255})
+	writeTestImage(t, filepath.Join(images, "emotes", "BA.png"), color.RGBA{R: 255, G: 255, A: 255})
+
+	first := testComment(1, "ab", "#00FF00", "ABC BA ")
+	first.Badges = []ChatBadge{{SetID: "subscriber", Version: "12"}, {SetID: "unknown", Version: "1"}}
+	first.Fragments = append(first.Fragments, ChatFragment{Text: "Kappa", EmoteID: "25"})
+	chat := &Chat{Comments: []ChatComment{first, testComment(3, "cc", "", "CCC")}}
+
+	opts := OverlayOptions{Width: 200, Height: 100, Font: testFont, FontSize: 20, Duration: 5 * time.Second, Images: ImageDir(images)}
+	d := &Downloader{}
+	list, err := d.renderOverlay(chat, dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := func(name string) string { return "file '" + filepath.Join(dir, name) + "'\n" }
+	want := "ffconcat version 1.0\n" +
+		frame("chat_empty.png") + "duration 1.000\n" +
+		frame("chat_000001.png") + "duration 2.000\n" +
+		frame("chat_000002.png") + "duration 3.000\n" +
+		frame("chat_000003.png") + "duration 2.000\n" +
+		frame("chat_empty.png")
+	if string(data) != want {
+		t.Errorf("got list\n%s\nwant\n%s", data, want)
+	}
+
+	empty := readTestImage(t, filepath.Join(dir, "chat_empty.png"))
+	if b := empty.Bounds(); b.Dx() != 200 || b.Dy() != 100 {
+		t.Errorf("frames are %v, want 200x100", b)
+	}
+	if c := color.NRGBAModel.Convert(empty.At(100, 50)).(color.NRGBA); c != (color.NRGBA{A: 128}) {
+		t.Errorf("background is %v, want half transparent black", c)
+	}
+
+	img := readTestImage(t, filepath.Join(dir, "chat_000001.png"))
+	for name, c := range map[string]color.RGBA{
+		"emote":       {R: 255, A: 255},
+		"named emote": {R: 255, G: 255, A: 255},
+		"badge":       {B: 255, A: 255},
+		"name":        {G: 255, A: 255},
+		"text":        {R: 255, G: 255, B: 255, A: 255},
+	} {
+		if !hasColor(img, c) {
+			t.Errorf("the %s isn't drawn", name)
+		}
+	}
+	if hasColor(readTestImage(t, filepath.Join(dir, "chat_000003.png")), color.RGBA{R: 255, A: 255}) {
+		t.Error("the first message is still shown after it went out")
+	}
+}
+
+func TestOverlayWrap(t *testing.T) {
+	r, err := newOverlayRenderer(OverlayOptions{Width: 116, Font: testFont, FontSize: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the name and a word fit into the 100 pixels of a line
+	short := r.message(testComment(0, "a", "", "ABC"))
+	long := r.message(testComment(0, "a", "", "ABC ABC ABC "+strings.Repeat("C", 20)))
+	if short.Bounds().Dy() != r.lineHeight {
+		t.Errorf("short message is %d high, want a line of %d", short.Bounds().Dy(), r.lineHeight)
+	}
+	if long.Bounds().Dy() != 4*r.lineHeight {
+		t.Errorf("long message is %d high, want 4 lines of %d", long.Bounds().Dy(), r.lineHeight)
+	}
+
+	if _, err := newOverlayRenderer(OverlayOptions{Font: "missing.ttf"}); err == nil {
+		t.Error("expected error for a missing font")
+	}
+}
+
+func TestDownloadBurnChat(t *testing.T) {
+	server := newVODServer(t, 3)
+	requests := 0
+	server.gql = videoCommentsHandler(t, &requests)
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d := newTestDownloader(t, server, dir)
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	opts.Chat = true
+	opts.BurnChat = true
+	opts.Overlay = OverlayOptions{Position: ChatTopLeft, Font: testFont}
+	opts.Encode = EncodeOptions{Preset: "veryfast"}
+
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	runs := ffmpegRuns(t, dir)
+	overlay := filepath.Join(dir, "_"+vodString, "chat_overlay", "chat_overlay.txt")
+	want := fmt.Sprintf("-f concat -safe 0 -i %s -c copy -c:v libx264 -preset veryfast -filter_complex [0:v][1:v]overlay=x=20:y=20[v] -map [v] -map 0:a? ", overlay)
+	if len(runs) != 1 || !strings.Contains(runs[0], want) {
+		t.Errorf("chat wasn't burned in: %q", runs)
+	}
+	if _, err := os.Stat(filepath.Dir(overlay)); !os.IsNotExist(err) {
+		t.Errorf("overlay images weren't deleted: %v", err)
+	}
+
+	opts.Encode.Codec = "h264_nvenc"
+	if err := d.Download(context.Background(), vodString, opts); err == nil || !strings.Contains(err.Error(), "hardware encoder") {
+		t.Errorf("expected error for a hardware encoder, got %v", err)
+	}
+}
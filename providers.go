@@ -0,0 +1,303 @@
+package concat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Default endpoints of the asset providers.
+const (
+	DefaultTwitchEmoteCDN string = "https://static-cdn.jtvnw.net/emoticons/v2"
+	DefaultBTTVAPIURL     string = "https://api.betterttv.net/3"
+	DefaultBTTVCDNURL     string = "https://cdn.betterttv.net"
+	DefaultFFZAPIURL      string = "https://api.frankerfacez.com/v1"
+	DefaultSevenTVAPIURL  string = "https://7tv.io/v3"
+)
+
+/*
+Gets link and decodes the JSON response into v. Returns false without an error for 404 Not Found,
+which the providers send for channels without emotes
+*/
+func (d *Downloader) getJSON(ctx context.Context, link string, v interface{}) (bool, error) {
+	body, err := d.get(ctx, link)
+	if isStatus(err, http.StatusNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return false, fmt.Errorf("could not read %s: %v", link, err)
+	}
+	return true, nil
+}
+
+func withDefault(value string, def string) string {
+	if value == "" {
+		return def
+	}
+	return strings.TrimSuffix(value, "/")
+}
+
+// TwitchAssets are the emotes and badges of twitch. Emotes are found by their id in the chat,
+// the badges of the channel and the global ones are listed with GQL.
+type TwitchAssets struct {
+	// EmoteCDN serves the emote images, defaults to DefaultTwitchEmoteCDN.
+	EmoteCDN string
+}
+
+// the twitch website has no persisted query with the image urls of the badges
+const chatBadgesQuery string = `query ChatBadges($channelID: ID!) {
+  badges { setID version imageURL(size: DOUBLE) }
+  user(id: $channelID) {
+    broadcastBadges { setID version imageURL(size: DOUBLE) }
+  }
+}`
+
+type twitchBadge struct {
+	SetID    string `json:"setID"`
+	Version  string `json:"version"`
+	ImageURL string `json:"imageURL"`
+}
+
+// Name returns twitch.
+func (TwitchAssets) Name() string {
+	return "twitch"
+}
+
+// EmoteURL returns the dark theme image of the emote id at twice the chat size.
+func (t TwitchAssets) EmoteURL(id string) string {
+	return withDefault(t.EmoteCDN, DefaultTwitchEmoteCDN) + "/" + id + "/default/dark/2.0"
+}
+
+// Assets returns the badges of the channel and the global ones. The emotes of twitch are
+// found with EmoteURL.
+func (TwitchAssets) Assets(ctx context.Context, d *Downloader, channelID string) ([]Asset, error) {
+	var data struct {
+		Badges []twitchBadge `json:"badges"`
+		User   *struct {
+			BroadcastBadges []twitchBadge `json:"broadcastBadges"`
+		} `json:"user"`
+	}
+	// an empty id finds no user and only returns the global badges
+	if err := d.gql(ctx, query("ChatBadges", chatBadgesQuery, map[string]interface{}{"channelID": channelID}), &data); err != nil {
+		return nil, err
+	}
+	badges := data.Badges
+	if data.User != nil {
+		badges = append(data.User.BroadcastBadges, badges...)
+	}
+	var assets []Asset
+	for _, b := range badges {
+		if b.ImageURL != "" {
+			assets = append(assets, Asset{Provider: "twitch", Type: AssetBadge, ID: b.SetID + "/" + b.Version, URL: b.ImageURL})
+		}
+	}
+	return assets, nil
+}
+
+// BTTVAssets are the emotes of BetterTTV.
+type BTTVAssets struct {
+	// APIURL and CDNURL default to DefaultBTTVAPIURL and DefaultBTTVCDNURL.
+	APIURL string
+	CDNURL string
+}
+
+type bttvEmote struct {
+	ID   string `json:"id"`
+	Code string `json:"code"`
+}
+
+// Name returns bttv.
+func (BTTVAssets) Name() string {
+	return "bttv"
+}
+
+// Assets returns the channel and shared emotes of the channel and the global emotes.
+func (b BTTVAssets) Assets(ctx context.Context, d *Downloader, channelID string) ([]Asset, error) {
+	api := withDefault(b.APIURL, DefaultBTTVAPIURL)
+	var emotes []bttvEmote
+	if channelID != "" {
+		var user struct {
+			ChannelEmotes []bttvEmote `json:"channelEmotes"`
+			SharedEmotes  []bttvEmote `json:"sharedEmotes"`
+		}
+		if _, err := d.getJSON(ctx, api+"/cached/users/twitch/"+channelID, &user); err != nil {
+			return nil, err
+		}
+		emotes = append(user.ChannelEmotes, user.SharedEmotes...)
+	}
+	var global []bttvEmote
+	if _, err := d.getJSON(ctx, api+"/cached/emotes/global", &global); err != nil {
+		return nil, err
+	}
+
+	cdn := withDefault(b.CDNURL, DefaultBTTVCDNURL)
+	var assets []Asset
+	for _, e := range append(emotes, global...) {
+		assets = append(assets, Asset{Provider: "bttv", Type: AssetEmote, ID: e.ID, Name: e.Code, URL: cdn + "/emote/" + e.ID + "/2x"})
+	}
+	return assets, nil
+}
+
+// FFZAssets are the emotes of FrankerFaceZ.
+type FFZAssets struct {
+	// APIURL defaults to DefaultFFZAPIURL.
+	APIURL string
+}
+
+type ffzSet struct {
+	Emoticons []struct {
+		ID   int               `json:"id"`
+		Name string            `json:"name"`
+		URLs map[string]string `json:"urls"`
+	} `json:"emoticons"`
+}
+
+// Name returns ffz.
+func (FFZAssets) Name() string {
+	return "ffz"
+}
+
+// Assets returns the emotes of the room of the channel and the default global sets.
+func (f FFZAssets) Assets(ctx context.Context, d *Downloader, channelID string) ([]Asset, error) {
+	api := withDefault(f.APIURL, DefaultFFZAPIURL)
+	var sets []ffzSet
+	if channelID != "" {
+		var room struct {
+			Room struct {
+				Set int `json:"set"`
+			} `json:"room"`
+			Sets map[string]ffzSet `json:"sets"`
+		}
+		if _, err := d.getJSON(ctx, api+"/room/id/"+channelID, &room); err != nil {
+			return nil, err
+		}
+		if set, ok := room.Sets[fmt.Sprint(room.Room.Set)]; ok {
+			sets = append(sets, set)
+		}
+	}
+	var global struct {
+		DefaultSets []int             `json:"default_sets"`
+		Sets        map[string]ffzSet `json:"sets"`
+	}
+	if _, err := d.getJSON(ctx, api+"/set/global", &global); err != nil {
+		return nil, err
+	}
+	for _, id := range global.DefaultSets {
+		if set, ok := global.Sets[fmt.Sprint(id)]; ok {
+			sets = append(sets, set)
+		}
+	}
+
+	var assets []Asset
+	for _, set := range sets {
+		for _, e := range set.Emoticons {
+			link := e.URLs["2"]
+			if link == "" {
+				link = e.URLs["1"]
+			}
+			if link == "" {
+				continue
+			}
+			// older responses leave out the scheme
+			if strings.HasPrefix(link, "//") {
+				link = "https:" + link
+			}
+			assets = append(assets, Asset{Provider: "ffz", Type: AssetEmote, ID: fmt.Sprint(e.ID), Name: e.Name, URL: link})
+		}
+	}
+	return assets, nil
+}
+
+// SevenTVAssets are the emotes of 7TV.
+type SevenTVAssets struct {
+	// APIURL defaults to DefaultSevenTVAPIURL.
+	APIURL string
+}
+
+type sevenTVEmote struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Data struct {
+		Host struct {
+			URL   string `json:"url"`
+			Files []struct {
+				Name   string `json:"name"`
+				Format string `json:"format"`
+			} `json:"files"`
+		} `json:"host"`
+	} `json:"data"`
+}
+
+// Name returns 7tv.
+func (SevenTVAssets) Name() string {
+	return "7tv"
+}
+
+// Assets returns the emotes of the emote set of the channel and the global set.
+func (s SevenTVAssets) Assets(ctx context.Context, d *Downloader, channelID string) ([]Asset, error) {
+	api := withDefault(s.APIURL, DefaultSevenTVAPIURL)
+	var emotes []sevenTVEmote
+	if channelID != "" {
+		var user struct {
+			EmoteSet *struct {
+				Emotes []sevenTVEmote `json:"emotes"`
+			} `json:"emote_set"`
+		}
+		if _, err := d.getJSON(ctx, api+"/users/twitch/"+channelID, &user); err != nil {
+			return nil, err
+		}
+		if user.EmoteSet != nil {
+			emotes = user.EmoteSet.Emotes
+		}
+	}
+	var global struct {
+		Emotes []sevenTVEmote `json:"emotes"`
+	}
+	if _, err := d.getJSON(ctx, api+"/emote-sets/global", &global); err != nil {
+		return nil, err
+	}
+
+	var assets []Asset
+	for _, e := range append(emotes, global.Emotes...) {
+		if link := sevenTVImage(e); link != "" {
+			assets = append(assets, Asset{Provider: "7tv", Type: AssetEmote, ID: e.ID, Name: e.Name, URL: link})
+		}
+	}
+	return assets, nil
+}
+
+/*
+Returns the url of the 2x image of e as PNG or GIF, which the overlay can decode, else as the first
+file of the size. Returns an empty string if e has no image
+*/
+func sevenTVImage(e sevenTVEmote) string {
+	host := e.Data.Host
+	if host.URL == "" || len(host.Files) == 0 {
+		return ""
+	}
+	if strings.HasPrefix(host.URL, "//") {
+		host.URL = "https:" + host.URL
+	}
+	name := ""
+	for _, f := range host.Files {
+		if !strings.HasPrefix(f.Name, "2x.") {
+			continue
+		}
+		if f.Format == "PNG" || f.Format == "GIF" {
+			name = f.Name
+			break
+		}
+		if name == "" {
+			name = f.Name
+		}
+	}
+	if name == "" {
+		name = host.Files[0].Name
+	}
+	return strings.TrimSuffix(host.URL, "/") + "/" + name
+}
@@ -0,0 +1,227 @@
+package concat
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AudioOnlyQuality is the group id twitch uses for the audio only rendition.
+const AudioOnlyQuality string = "audio_only"
+
+// a comparison like <=1080p or >=30fps
+var qualityComparison = regexp.MustCompile(`^(<=|>=|<|>|=)(\d+(?:\.\d+)?)(p|fps)$`)
+
+/*
+One alternative of a quality selector, like "best" or "<=1080p >=30fps". All conditions have to match,
+of the matching renditions the best is picked, or the worst if worst is set
+*/
+type qualityChoice struct {
+	text       string
+	conditions []func(q Quality) bool
+	worst      bool
+	// set for choices like "best" or "<=1080p" that only pick between video renditions
+	videoOnly bool
+}
+
+/*
+Parses a quality selector. Selectors are alternatives separated by commas that are tried in order, like
+"720p60,720p,best". An alternative is the name or group id of a rendition, best, worst or comparisons
+of the height and frame rate like "<=1080p >=30fps" separated by spaces
+*/
+func parseQualitySelector(selector string) ([]qualityChoice, error) {
+	var choices []qualityChoice
+	for _, text := range strings.Split(selector, ",") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			return nil, fmt.Errorf("empty choice in quality %q", selector)
+		}
+
+		c := qualityChoice{text: text}
+		var name []string
+		for _, term := range strings.Fields(strings.ToLower(text)) {
+			switch {
+			case term == "best":
+				c.videoOnly = true
+			case term == "worst":
+				c.videoOnly = true
+				c.worst = true
+			case strings.ContainsAny(term[:1], "<>="):
+				condition, err := parseQualityComparison(term)
+				if err != nil {
+					return nil, err
+				}
+				c.videoOnly = true
+				c.conditions = append(c.conditions, condition)
+			default:
+				name = append(name, term)
+			}
+		}
+		if len(name) > 0 {
+			// names like "Audio Only" contain spaces
+			n := normalizeQualityName(strings.Join(name, " "))
+			c.conditions = append(c.conditions, func(q Quality) bool {
+				return normalizeQualityName(q.GroupID) == n || normalizeQualityName(q.Name) == n
+			})
+		}
+		choices = append(choices, c)
+	}
+	return choices, nil
+}
+
+func parseQualityComparison(term string) (func(q Quality) bool, error) {
+	m := qualityComparison.FindStringSubmatch(term)
+	if m == nil {
+		return nil, fmt.Errorf("invalid quality comparison %q, use something like <=1080p or >=30fps", term)
+	}
+	op := m[1]
+	value, _ := strconv.ParseFloat(m[2], 64)
+	fps := m[3] == "fps"
+
+	return func(q Quality) bool {
+		var got float64
+		if fps {
+			// twitch lists 30fps as 29.97 or 30.000001
+			got = math.Round(q.FrameRate)
+		} else {
+			got = float64(q.Height)
+		}
+		switch op {
+		case "<=":
+			return got <= value
+		case ">=":
+			return got >= value
+		case "<":
+			return got < value
+		case ">":
+			return got > value
+		}
+		return got == value
+	}, nil
+}
+
+/*
+"Audio Only" and "audio_only" name the same rendition
+*/
+func normalizeQualityName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(strings.Replace(name, "_", " ", -1))), "_")
+}
+
+// codecs of video in the CODECS attribute of renditions, like avc1.64002A
+var videoCodecs = []string{"avc1", "avc3", "hvc1", "hev1", "dvh1", "dvhe", "vp08", "vp09", "av01"}
+
+/*
+Reports whether q has no video. Renditions without a RESOLUTION can still have video, so only the group
+id and the codecs tell
+*/
+func (q Quality) audioOnly() bool {
+	if q.GroupID == AudioOnlyQuality {
+		return true
+	}
+	if q.Codecs == "" {
+		return false
+	}
+	for _, codec := range strings.Split(q.Codecs, ",") {
+		codec = strings.ToLower(strings.TrimSpace(codec))
+		for _, video := range videoCodecs {
+			if strings.HasPrefix(codec, video) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+/*
+Sorts qualities from best to worst: video before audio only, then by height, frame rate and bandwidth
+*/
+func rankQualities(qualities []Quality) []Quality {
+	ranked := append([]Quality(nil), qualities...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.audioOnly() != b.audioOnly() {
+			return !a.audioOnly()
+		}
+		if a.Height != b.Height {
+			return a.Height > b.Height
+		}
+		if math.Round(a.FrameRate) != math.Round(b.FrameRate) {
+			return a.FrameRate > b.FrameRate
+		}
+		return a.Bandwidth > b.Bandwidth
+	})
+	return ranked
+}
+
+/*
+Picks the rendition for the quality selector, see parseQualitySelector. Falls back to the best rendition if
+no alternative matches. Logs the chosen rendition and why it was chosen
+*/
+func (d *Downloader) selectQuality(qualities []Quality, selector string) (Quality, error) {
+	choices, err := parseQualitySelector(selector)
+	if err != nil {
+		return Quality{}, err
+	}
+	if len(qualities) == 0 {
+		return Quality{}, errors.New("no available quality options found")
+	}
+	ranked := rankQualities(qualities)
+
+	for i, c := range choices {
+		var matches []Quality
+		for _, q := range ranked {
+			if c.matches(q) {
+				matches = append(matches, q)
+			}
+		}
+		if len(matches) == 0 {
+			d.printf("Couldn't find quality: %s\n", c.text)
+			continue
+		}
+
+		q := matches[0]
+		reason := fmt.Sprintf("matches %q", c.text)
+		if len(matches) > 1 {
+			reason = fmt.Sprintf("best of %d renditions matching %q", len(matches), c.text)
+		}
+		if c.worst {
+			q = matches[len(matches)-1]
+			reason = fmt.Sprintf("worst of %d renditions matching %q", len(matches), c.text)
+		}
+		if i > 0 {
+			reason += fmt.Sprintf(", the first available choice of %q", selector)
+		}
+		d.printf("Selected quality: %s, %s\n", qualityLabel(q), reason)
+		return q, nil
+	}
+
+	q := ranked[0]
+	d.printf("Downloading in max available quality: %s, nothing matches %q\n", qualityLabel(q), selector)
+	return q, nil
+}
+
+func (c qualityChoice) matches(q Quality) bool {
+	if c.videoOnly && q.audioOnly() {
+		return false
+	}
+	for _, condition := range c.conditions {
+		if !condition(q) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Name and group id of a rendition, like "1080p60 (chunked)"
+*/
+func qualityLabel(q Quality) string {
+	if q.Name == "" || q.Name == q.GroupID {
+		return q.GroupID
+	}
+	return fmt.Sprintf("%s (%s)", q.Name, q.GroupID)
+}
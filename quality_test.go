@@ -0,0 +1,105 @@
+package concat
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var testQualities = []Quality{
+	{GroupID: "chunked", Name: "1080p60", Width: 1920, Height: 1080, FrameRate: 60, Bandwidth: 6211302},
+	{GroupID: "720p60", Name: "720p60", Width: 1280, Height: 720, FrameRate: 60, Bandwidth: 3422999},
+	{GroupID: "720p30", Name: "720p", Width: 1280, Height: 720, FrameRate: 29.97, Bandwidth: 1733316},
+	{GroupID: "160p30", Name: "160p", Width: 284, Height: 160, FrameRate: 30, Bandwidth: 230000},
+	{GroupID: "audio_only", Name: "Audio Only", Bandwidth: 160000},
+}
+
+func TestSelectQuality(t *testing.T) {
+	tests := map[string]string{
+		"chunked":                  "chunked",
+		"best":                     "chunked",
+		"worst":                    "160p30",
+		"720p60":                   "720p60",
+		"720p":                     "720p30",
+		"480p,720p,best":           "720p30",
+		"<=720p":                   "720p60",
+		"<720p,best":               "160p30",
+		">=30fps":                  "chunked",
+		"<=720p <=30fps":           "720p30",
+		"=30fps worst":             "160p30",
+		"audio_only":               "audio_only",
+		"Audio Only":               "audio_only",
+		"1440p60":                  "chunked",
+		"<=100p,>=2160p,1440p60":   "chunked",
+		" 360p , <=1080p >=60fps ": "chunked",
+	}
+	for selector, want := range tests {
+		var out bytes.Buffer
+		d := &Downloader{Output: &out}
+		q, err := d.selectQuality(testQualities, selector)
+		if err != nil || q.GroupID != want {
+			t.Errorf("selectQuality(%q) = %s, %v, want %s", selector, q.GroupID, err, want)
+		}
+		if !strings.Contains(out.String(), "quality: "+qualityLabel(q)) {
+			t.Errorf("selectQuality(%q) doesn't log the chosen rendition: %q", selector, out.String())
+		}
+	}
+
+	for _, selector := range []string{"", "720p,,best", "<=720", ">=fast", "=<720p"} {
+		if q, err := (&Downloader{}).selectQuality(testQualities, selector); err == nil {
+			t.Errorf("selectQuality(%q) = %s, expected error", selector, q.GroupID)
+		}
+	}
+	if _, err := (&Downloader{}).selectQuality(nil, "best"); err == nil {
+		t.Errorf("expected error without qualities")
+	}
+}
+
+func TestAudioOnly(t *testing.T) {
+	tests := []struct {
+		q    Quality
+		want bool
+	}{
+		{Quality{GroupID: "audio_only", Codecs: "mp4a.40.2"}, true},
+		{Quality{GroupID: "audio_only"}, true},
+		{Quality{GroupID: "sound", Codecs: "mp4a.40.2"}, true},
+		{Quality{GroupID: "chunked", Codecs: "avc1.64002A,mp4a.40.2", Height: 1080}, false},
+		// no RESOLUTION, but video
+		{Quality{GroupID: "chunked", Codecs: "avc1.64002A,mp4a.40.2"}, false},
+		{Quality{GroupID: "chunked", Codecs: "mp4a.40.2, HEV1.2.4.L123.B0"}, false},
+		{Quality{GroupID: "chunked"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.q.audioOnly(); got != tt.want {
+			t.Errorf("%+v.audioOnly() = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+
+	// video without a resolution still ranks above audio
+	qualities := []Quality{
+		{GroupID: "audio_only", Codecs: "mp4a.40.2", Bandwidth: 160000},
+		{GroupID: "chunked", Codecs: "avc1.64002A,mp4a.40.2", Bandwidth: 6211302},
+	}
+	if q, err := (&Downloader{}).selectQuality(qualities, "best"); err != nil || q.GroupID != "chunked" {
+		t.Errorf("selectQuality(best) = %s, %v, want chunked", q.GroupID, err)
+	}
+	if ranked := rankQualities(qualities); ranked[0].GroupID != "chunked" {
+		t.Errorf("ranked %s first, want chunked", ranked[0].GroupID)
+	}
+	opts := Options{AudioOnly: true, AudioFormat: AudioFormatM4A}
+	if !opts.directAudio(qualities[0]) || opts.directAudio(qualities[1]) {
+		t.Error("only the audio rendition is combined straight into the audio file")
+	}
+}
+
+func TestSelectQualityReason(t *testing.T) {
+	var out bytes.Buffer
+	d := &Downloader{Output: &out}
+	if _, err := d.selectQuality(testQualities, "480p,<=720p"); err != nil {
+		t.Fatal(err)
+	}
+	want := `Selected quality: 720p60, best of 3 renditions matching "<=720p", the first available choice of "480p,<=720p"`
+	if !strings.Contains(out.String(), "Couldn't find quality: 480p") || !strings.Contains(out.String(), want) {
+		t.Errorf("got log %q", out.String())
+	}
+}
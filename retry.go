@@ -0,0 +1,98 @@
+package concat
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls the delay between the tries of a chunk download.
+// The zero value uses DefaultRetryPolicy.
+type RetryPolicy struct {
+	// BaseDelay is the delay before the first retry, it doubles with every further retry.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the delay between two tries, including delays requested with Retry-After.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy returns the retry policy used if Downloader.Retry isn't set.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		BaseDelay: time.Second,
+		MaxDelay:  30 * time.Second,
+	}
+}
+
+func (d *Downloader) retryPolicy() RetryPolicy {
+	p := d.Retry
+	def := DefaultRetryPolicy()
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = def.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = def.MaxDelay
+	}
+	return p
+}
+
+/*
+Returns the delay before the next try after retryCount failed tries. The exponential delay is
+jittered between half and all of it, so concurrent chunk downloads don't retry in lockstep.
+retryAfter is the delay the server asked for, it is used if it is longer
+*/
+func (p RetryPolicy) delay(retryCount int, retryAfter time.Duration) time.Duration {
+	backoff := p.MaxDelay
+	if retryCount < 30 {
+		if exp := p.BaseDelay << uint(retryCount); exp > 0 && exp < p.MaxDelay {
+			backoff = exp
+		}
+	}
+	backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+
+	if retryAfter > backoff {
+		backoff = retryAfter
+	}
+	if backoff > p.MaxDelay {
+		backoff = p.MaxDelay
+	}
+	return backoff
+}
+
+/*
+Parses the Retry-After header, either seconds or a http date. Returns 0 if there is none
+*/
+func retryAfter(resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+/*
+Waits for delay or until ctx is done
+*/
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
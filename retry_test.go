@@ -0,0 +1,114 @@
+package concat
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		retryCount int
+		retryAfter time.Duration
+		min, max   time.Duration
+	}{
+		{0, 0, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 0, 200 * time.Millisecond, 400 * time.Millisecond},
+		{10, 0, 500 * time.Millisecond, time.Second},
+		{100, 0, 500 * time.Millisecond, time.Second},
+		{0, 700 * time.Millisecond, 700 * time.Millisecond, 700 * time.Millisecond},
+		{0, time.Hour, time.Second, time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if got := p.delay(tt.retryCount, tt.retryAfter); got < tt.min || got > tt.max {
+				t.Errorf("delay(%d, %v) = %v, want between %v and %v", tt.retryCount, tt.retryAfter, got, tt.min, tt.max)
+				break
+			}
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		status int
+		header string
+		want   time.Duration
+	}{
+		{http.StatusTooManyRequests, "3", 3 * time.Second},
+		{http.StatusServiceUnavailable, "2", 2 * time.Second},
+		{http.StatusInternalServerError, "2", 0},
+		{http.StatusTooManyRequests, "soon", 0},
+		{http.StatusTooManyRequests, "Wed, 21 Oct 2015 07:28:00 GMT", 0},
+		{http.StatusTooManyRequests, "", 0},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		resp.Header.Set("Retry-After", tt.header)
+		if got := retryAfter(resp); got != tt.want {
+			t.Errorf("retryAfter(%d, %q) = %v, want %v", tt.status, tt.header, got, tt.want)
+		}
+	}
+
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	resp.Header.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	if got := retryAfter(resp); got < 58*time.Second || got > time.Minute {
+		t.Errorf("retryAfter with a date a minute from now = %v", got)
+	}
+}
+
+func TestFetchWithRetry(t *testing.T) {
+	var mu sync.Mutex
+	tries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		tries++
+		try := tries
+		mu.Unlock()
+
+		switch try {
+		case 1:
+			// drop the connection like a flaky network would
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Close()
+		case 2:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		default:
+			w.Write([]byte("chunk"))
+		}
+	}))
+	defer server.Close()
+
+	// without keep alive the transport doesn't silently retry on the dropped connection
+	d := &Downloader{
+		HTTPClient: &http.Client{Transport: &http.Transport{DisableKeepAlives: true}},
+		Retry:      RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond},
+	}
+
+	body, err := d.fetchWithRetry(context.Background(), server.URL, 3)
+	if err != nil || string(body) != "chunk" {
+		t.Fatalf("got %q, %v", body, err)
+	}
+
+	mu.Lock()
+	tries = 0
+	mu.Unlock()
+	if _, err := d.fetchWithRetry(context.Background(), server.URL, 2); err == nil {
+		t.Errorf("expected error after 2 tries")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.fetchWithRetry(ctx, server.URL, 0); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package concat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// smartCutWindow is how many seconds around the cut points are searched for keyframes.
+// Twitch uses a keyframe interval of 2 seconds, chunks are at most 10 seconds long.
+const smartCutWindow float64 = 20
+
+/*
+Cuts the chunks listed in listPath exactly at c. Only the parts before the first and after the
+last keyframe inside c are re-encoded, with the codec, profile and pixel format of the source,
+everything between them is copied. Fails for sources that can't be encoded like that. The
+subtitles file is added as track if it isn't empty
+*/
+func (m *FFmpegMuxer) smartCut(ctx context.Context, newpath string, listPath string, vodSavePath string, c cut, subtitles string) error {
+	end := 0.0
+	if c.duration > 0 {
+		end = c.start + c.duration
+	}
+
+	keyframes, err := m.keyframes(ctx, listPath, c.start, end)
+	if err != nil {
+		return err
+	}
+
+	// the copied part starts at the first keyframe after the start and ends at the last keyframe before the end
+	first, last := -1.0, -1.0
+	for _, k := range keyframes {
+		if k >= c.start && first < 0 {
+			first = k
+		}
+		if end > 0 && k <= end {
+			last = k
+		}
+	}
+	if first < 0 || end > 0 && last < first {
+		return errors.New("no keyframe between start and end")
+	}
+
+	var parts []string
+	defer func() {
+		for _, p := range parts {
+			os.Remove(p)
+		}
+	}()
+	part := func(name string, args []string) error {
+		p := filepath.Join(newpath, name)
+		parts = append(parts, p)
+		return m.run(ctx, append(args, "-f", "mpegts", p)...)
+	}
+	// the re-encoded parts are copied into one track with the middle, so they must be encoded like it
+	streams, err := m.sourceStreams(ctx, listPath)
+	if err != nil {
+		return err
+	}
+	reencode, err := reencodeArgs(streams)
+	if err != nil {
+		return err
+	}
+
+	if first > c.start {
+		head := cut{start: c.start, duration: first - c.start}
+		if err := part("head.ts", head.args(concatInput(listPath), reencode...)); err != nil {
+			return err
+		}
+	}
+
+	middle := cut{start: first}
+	if end > 0 {
+		middle.duration = last - first
+	}
+	if middle.duration > 0 || end == 0 {
+		if err := part("middle.ts", middle.args(concatInput(listPath), "-c", "copy")); err != nil {
+			return err
+		}
+	}
+
+	tailLength := 0.0
+	if end > last && end > 0 {
+		tailLength = end - last
+		tail := cut{start: last, duration: tailLength}
+		if err := part("tail.ts", tail.args(concatInput(listPath), reencode...)); err != nil {
+			return err
+		}
+	}
+
+	var list strings.Builder
+	for _, p := range parts {
+		abs, _ := filepath.Abs(p)
+		list.WriteString("file '" + abs + "'\n")
+	}
+	partsList := filepath.Join(newpath, "parts.txt")
+	if err := ioutil.WriteFile(partsList, []byte(list.String()), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(partsList)
+
+	m.printf("Smart cut: re-encoding %.3fs at the start and %.3fs at the end\n", first-c.start, tailLength)
+
+	input := append(concatInput(partsList), subtitleInput(subtitles)...)
+	return m.run(ctx, append(input, outputArgs(vodSavePath, CombineOptions{Subtitles: subtitles})...)...)
+}
+
+/*
+Returns the times of the video keyframes of the chunks listed in listPath around start and end,
+in seconds from the beginning of the first chunk. The area around end is skipped if end is 0
+*/
+func (m *FFmpegMuxer) keyframes(ctx context.Context, listPath string, start float64, end float64) ([]float64, error) {
+	intervals := fmt.Sprintf("%s%%+%s", formatSeconds(start), formatSeconds(smartCutWindow))
+	if end > 0 {
+		from := end - smartCutWindow
+		if from < 0 {
+			from = 0
+		}
+		intervals += fmt.Sprintf(",%s%%%s", formatSeconds(from), formatSeconds(end))
+	}
+
+	args := []string{"-v", "error", "-f", "concat", "-safe", "0", "-i", listPath,
+		"-select_streams", "v:0", "-skip_frame", "nokey", "-read_intervals", intervals,
+		"-show_entries", "frame=pts_time", "-of", "csv=p=0"}
+
+	out, err := m.runProbe(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyframes []float64
+	for _, line := range strings.Fields(string(out)) {
+		k, err := strconv.ParseFloat(strings.TrimSuffix(line, ","), 64)
+		if err != nil {
+			continue
+		}
+		keyframes = append(keyframes, k)
+	}
+	if len(keyframes) == 0 {
+		return nil, errors.New("ffprobe found no keyframes")
+	}
+	return keyframes, nil
+}
+
+// sourceStream is what the smart cut needs to know of a stream to encode the cut parts like it
+type sourceStream struct {
+	CodecType  string `json:"codec_type"`
+	CodecName  string `json:"codec_name"`
+	Profile    string `json:"profile"`
+	PixFmt     string `json:"pix_fmt"`
+	Level      int    `json:"level"`
+	SampleRate string `json:"sample_rate"`
+	Channels   int    `json:"channels"`
+}
+
+/*
+Returns the streams of the chunks listed in listPath
+*/
+func (m *FFmpegMuxer) sourceStreams(ctx context.Context, listPath string) ([]sourceStream, error) {
+	out, err := m.runProbe(ctx, "-v", "error", "-f", "concat", "-safe", "0", "-i", listPath,
+		"-show_entries", "stream=codec_type,codec_name,profile,pix_fmt,level,sample_rate,channels", "-of", "json")
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Streams []sourceStream `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("could not read ffprobe output: %v", err)
+	}
+	return result.Streams, nil
+}
+
+// the libx264 and libx265 profiles of the profiles ffprobe reports, others can't be matched
+var (
+	x264Profiles = map[string]string{
+		"Constrained Baseline":  "baseline",
+		"Baseline":              "baseline",
+		"Main":                  "main",
+		"High":                  "high",
+		"High 10":               "high10",
+		"High 4:2:2":            "high422",
+		"High 4:4:4 Predictive": "high444",
+	}
+	x265Profiles = map[string]string{
+		"Main":    "main",
+		"Main 10": "main10",
+	}
+)
+
+/*
+Returns the ffmpeg output options that re-encode the start and end of a smart cut with the codec,
+profile, level and pixel format of the video and the codec and layout of the audio in streams.
+Returns an error if they can't be matched, the parts wouldn't fit together in one track
+*/
+func reencodeArgs(streams []sourceStream) ([]string, error) {
+	var video, audio *sourceStream
+	for i := range streams {
+		switch {
+		case streams[i].CodecType == "video" && video == nil:
+			video = &streams[i]
+		case streams[i].CodecType == "audio" && audio == nil:
+			audio = &streams[i]
+		}
+	}
+	if video == nil {
+		return nil, errors.New("no video stream to cut")
+	}
+	if video.PixFmt == "" {
+		return nil, fmt.Errorf("unknown pixel format of the %s video", video.CodecName)
+	}
+
+	var args []string
+	switch video.CodecName {
+	case "h264":
+		profile, ok := x264Profiles[video.Profile]
+		if !ok {
+			return nil, fmt.Errorf("can't re-encode h264 video of profile %q", video.Profile)
+		}
+		args = append(args, "-c:v", "libx264", "-profile:v", profile)
+		// ffprobe reports level 4.1 as 41
+		if video.Level > 0 {
+			args = append(args, "-level:v", fmt.Sprintf("%d.%d", video.Level/10, video.Level%10))
+		}
+	case "hevc":
+		profile, ok := x265Profiles[video.Profile]
+		if !ok {
+			return nil, fmt.Errorf("can't re-encode hevc video of profile %q", video.Profile)
+		}
+		args = append(args, "-c:v", "libx265", "-profile:v", profile)
+	default:
+		return nil, fmt.Errorf("can't re-encode %s video", video.CodecName)
+	}
+	args = append(args, "-pix_fmt", video.PixFmt, "-preset", "veryfast", "-crf", "18")
+
+	if audio != nil {
+		if audio.CodecName != "aac" {
+			return nil, fmt.Errorf("can't re-encode %s audio", audio.CodecName)
+		}
+		args = append(args, "-c:a", "aac")
+		if audio.SampleRate != "" {
+			args = append(args, "-ar", audio.SampleRate)
+		}
+		if audio.Channels > 0 {
+			args = append(args, "-ac", strconv.Itoa(audio.Channels))
+		}
+	}
+	return args, nil
+}
@@ -0,0 +1,131 @@
+package concat
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReencodeArgs(t *testing.T) {
+	aac := sourceStream{CodecType: "audio", CodecName: "aac", SampleRate: "44100", Channels: 1}
+	tests := []struct {
+		streams []sourceStream
+		want    string
+	}{
+		{
+			[]sourceStream{{CodecType: "video", CodecName: "h264", Profile: "Main", PixFmt: "yuv420p", Level: 31}, aac},
+			"-c:v libx264 -profile:v main -level:v 3.1 -pix_fmt yuv420p -preset veryfast -crf 18 -c:a aac -ar 44100 -ac 1",
+		},
+		{
+			[]sourceStream{{CodecType: "video", CodecName: "hevc", Profile: "Main 10", PixFmt: "yuv420p10le", Level: 120}},
+			"-c:v libx265 -profile:v main10 -pix_fmt yuv420p10le -preset veryfast -crf 18",
+		},
+		{[]sourceStream{{CodecType: "video", CodecName: "h264", Profile: "Extended", PixFmt: "yuv420p"}, aac}, ""},
+		{[]sourceStream{{CodecType: "video", CodecName: "hevc", Profile: "Rext", PixFmt: "yuv444p12le"}}, ""},
+		{[]sourceStream{{CodecType: "video", CodecName: "vp9", Profile: "Profile 0", PixFmt: "yuv420p"}}, ""},
+		{[]sourceStream{{CodecType: "video", CodecName: "h264", Profile: "High"}}, ""},
+		{[]sourceStream{{CodecType: "video", CodecName: "h264", Profile: "High", PixFmt: "yuv420p"}, {CodecType: "audio", CodecName: "opus"}}, ""},
+		{[]sourceStream{aac}, ""},
+	}
+	for _, tt := range tests {
+		args, err := reencodeArgs(tt.streams)
+		if got := strings.Join(args, " "); got != tt.want || (err != nil) != (tt.want == "") {
+			t.Errorf("reencodeArgs(%+v) = %q, %v, want %q", tt.streams, got, err, tt.want)
+		}
+	}
+}
+
+func TestSmartCutUnmatchedSource(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ffprobe := filepath.Join(dir, "ffprobe")
+	script := `#!/bin/sh
+case "$*" in
+*stream=*) echo '{"streams": [{"codec_type": "video", "codec_name": "vp9", "pix_fmt": "yuv420p"}]}' ;;
+*) for i in $(seq 0 2 30); do echo "$i.000000"; done ;;
+esac
+`
+	if err := ioutil.WriteFile(ffprobe, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var inputs []string
+	for i := 0; i < 3; i++ {
+		input := filepath.Join(dir, fmt.Sprintf("%d.ts", i))
+		if err := ioutil.WriteFile(input, fakeChunk(i), 0644); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, input)
+	}
+
+	// the video can't be encoded like the source, so it is cut at keyframes
+	m := &FFmpegMuxer{FFmpegCmd: fakeFFmpeg(t, dir), FFprobeCmd: ffprobe}
+	opts := CombineOptions{Start: 5500 * time.Millisecond, Duration: 10 * time.Second, SmartCut: true}
+	if err := m.Combine(context.Background(), inputs, filepath.Join(dir, "out.mp4"), opts); err != nil {
+		t.Fatal(err)
+	}
+	if runs := ffmpegRuns(t, dir); len(runs) != 1 || !strings.HasPrefix(runs[0], "-ss 5.500 -f concat") || !strings.Contains(runs[0], " -c copy ") {
+		t.Errorf("expected a copy cut at keyframes, got %q", runs)
+	}
+}
+
+// TestSmartCutFFmpeg cuts a real video with ffmpeg, it is skipped if ffmpeg isn't installed
+func TestSmartCutFFmpeg(t *testing.T) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		t.Skip("ffmpeg isn't installed")
+	}
+	ffprobe, err := exec.LookPath("ffprobe")
+	if err != nil {
+		t.Skip("ffprobe isn't installed")
+	}
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// 30 seconds of main profile video in chunks of 10 seconds with a keyframe every 2 seconds, like twitch
+	generate := exec.Command(ffmpeg, "-v", "error",
+		"-f", "lavfi", "-i", "testsrc2=size=320x240:rate=30", "-f", "lavfi", "-i", "sine=frequency=440:sample_rate=48000",
+		"-t", "30", "-c:v", "libx264", "-profile:v", "main", "-pix_fmt", "yuv420p", "-g", "60", "-keyint_min", "60", "-sc_threshold", "0",
+		"-c:a", "aac", "-ac", "2", "-f", "segment", "-segment_time", "10", filepath.Join(dir, "%d.ts"))
+	if out, err := generate.CombinedOutput(); err != nil {
+		t.Skipf("ffmpeg can't encode the test video: %v: %s", err, out)
+	}
+	inputs := []string{filepath.Join(dir, "0.ts"), filepath.Join(dir, "1.ts"), filepath.Join(dir, "2.ts")}
+	listPath, err := createConcatFile(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &FFmpegMuxer{FFmpegCmd: ffmpeg, FFprobeCmd: ffprobe}
+	output := filepath.Join(dir, "out.mp4")
+	if err := m.smartCut(context.Background(), dir, listPath, output, cut{start: 5.5, duration: 19.5}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := m.Probe(context.Background(), output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(info.Duration.Seconds()-19.5) > 0.1 {
+		t.Errorf("output is %v long, want 19.5s", info.Duration)
+	}
+	streams, err := m.runProbe(context.Background(), "-v", "error", "-show_entries", "stream=codec_name,profile,pix_fmt", "-of", "csv=p=0", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(streams)); len(got) != 2 || got[0] != "h264,Main,yuv420p" || !strings.HasPrefix(got[1], "aac,") {
+		t.Errorf("got streams %q, want main profile h264 and aac", got)
+	}
+
+	// the re-encoded and the copied parts decode as one stream
+	decode := exec.Command(ffmpeg, "-v", "error", "-i", output, "-f", "null", "-")
+	if out, err := decode.CombinedOutput(); err != nil || len(strings.TrimSpace(string(out))) > 0 {
+		t.Errorf("output doesn't decode cleanly: %v: %s", err, out)
+	}
+}
@@ -0,0 +1,341 @@
+package concat
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Formats of Options.Subtitles.
+const (
+	SubtitlesSRT string = "srt"
+	SubtitlesASS string = "ass"
+)
+
+// Positions of the chat in SubtitleOptions.Position.
+const (
+	ChatBottomLeft  string = "bottom-left"
+	ChatBottomRight string = "bottom-right"
+	ChatTopLeft     string = "top-left"
+	ChatTopRight    string = "top-right"
+)
+
+// SubtitleOptions control how the chat is shown as subtitles. The chat is a box of the last
+// messages that scrolls as new messages come in. Sizes are in pixels of a 1080p video and
+// scale with the video. Zero values use the defaults.
+type SubtitleOptions struct {
+	// Duration a message stays on screen. Defaults to 8 seconds.
+	Duration time.Duration
+
+	// Lines is the number of messages shown at once. Defaults to 8.
+	Lines int
+
+	// Position of the chat box, like ChatBottomLeft or ChatTopRight. Defaults to ChatBottomLeft.
+	Position string
+
+	// Width of the chat box, longer messages wrap. Defaults to 600. Only used for ASS.
+	Width int
+
+	// Font and FontSize of the messages. Default to Arial and 32. Only used for ASS.
+	Font     string
+	FontSize int
+}
+
+func (o SubtitleOptions) duration() time.Duration {
+	if o.Duration <= 0 {
+		return 8 * time.Second
+	}
+	return o.Duration
+}
+
+func (o SubtitleOptions) lines() int {
+	if o.Lines <= 0 {
+		return 8
+	}
+	return o.Lines
+}
+
+func (o SubtitleOptions) position() string {
+	if o.Position == "" {
+		return ChatBottomLeft
+	}
+	return o.Position
+}
+
+func (o SubtitleOptions) width() int {
+	if o.Width <= 0 {
+		return 600
+	}
+	return o.Width
+}
+
+func (o SubtitleOptions) font() string {
+	if o.Font == "" {
+		return "Arial"
+	}
+	return o.Font
+}
+
+func (o SubtitleOptions) fontSize() int {
+	if o.FontSize <= 0 {
+		return 32
+	}
+	return o.FontSize
+}
+
+/*
+Returns the numpad alignment of ASS for the position, 1 is bottom left and 9 top right
+*/
+func (o SubtitleOptions) alignment() (int, error) {
+	return chatAlignment(o.position())
+}
+
+func chatAlignment(position string) (int, error) {
+	switch position {
+	case ChatBottomLeft:
+		return 1, nil
+	case ChatBottomRight:
+		return 3, nil
+	case ChatTopLeft:
+		return 7, nil
+	case ChatTopRight:
+		return 9, nil
+	}
+	return 0, fmt.Errorf("unknown chat position %q, use bottom-left, bottom-right, top-left or top-right", position)
+}
+
+// ReadChat reads a chat replay saved by Options.Chat.
+func ReadChat(path string) (*Chat, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var chat Chat
+	if err := json.Unmarshal(data, &chat); err != nil {
+		return nil, fmt.Errorf("could not read chat %s: %v", path, err)
+	}
+	return &chat, nil
+}
+
+// defaultNameColors are the colors twitch gives users who never chose one
+var defaultNameColors = []string{
+	"#FF0000", "#0000FF", "#008000", "#B22222", "#FF7F50",
+	"#9ACD32", "#FF4500", "#2E8B57", "#DAA520", "#D2691E",
+	"#5F9EA0", "#1E90FF", "#FF69B4", "#8A2BE2", "#00FF7F",
+}
+
+/*
+Returns the color of the name of the commenter as #RRGGBB. Users without a color get one of the
+default colors, always the same for a login
+*/
+func (c ChatComment) nameColor() string {
+	if len(c.Color) == 7 && c.Color[0] == '#' {
+		return strings.ToUpper(c.Color)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(c.Commenter.Login))
+	return defaultNameColors[h.Sum32()%uint32(len(defaultNameColors))]
+}
+
+// chatEvent is a time span in which the chat box shows the same messages
+type chatEvent struct {
+	start, end float64
+	comments   []ChatComment
+}
+
+/*
+Splits the chat into the spans between a message coming in and going out. Each span shows the
+messages of the last duration seconds, at most lines of them. With an infinite duration messages
+only go out when newer ones push them out, the last span never ends
+*/
+func chatEvents(comments []ChatComment, duration float64, lines int) []chatEvent {
+	comments = append([]ChatComment(nil), comments...)
+	sort.SliceStable(comments, func(i, j int) bool { return comments[i].Offset < comments[j].Offset })
+
+	var points []float64
+	for _, c := range comments {
+		points = append(points, c.Offset, c.Offset+duration)
+	}
+	sort.Float64s(points)
+
+	var events []chatEvent
+	// the visible messages are comments[lo:hi], they came in during the last duration
+	lo, hi := 0, 0
+	for i, p := range points {
+		if i+1 == len(points) || points[i+1] == p {
+			continue
+		}
+		for hi < len(comments) && comments[hi].Offset <= p {
+			hi++
+		}
+		for lo < hi && comments[lo].Offset+duration <= p {
+			lo++
+		}
+		first := lo
+		if hi-first > lines {
+			first = hi - lines
+		}
+		if first == hi {
+			continue
+		}
+		events = append(events, chatEvent{start: p, end: points[i+1], comments: comments[first:hi]})
+	}
+	return events
+}
+
+// WriteSRT writes the chat as SubRip subtitles. The names are colored with font tags and the
+// position is an alignment tag, most players show both.
+func WriteSRT(w io.Writer, chat *Chat, opts SubtitleOptions) error {
+	alignment, err := opts.alignment()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for i, e := range chatEvents(chat.Comments, opts.duration().Seconds(), opts.lines()) {
+		fmt.Fprintf(bw, "%d\n%s --> %s\n{\\an%d}", i+1, srtTime(e.start), srtTime(e.end), alignment)
+		for j, c := range e.comments {
+			if j > 0 {
+				bw.WriteString("\n")
+			}
+			fmt.Fprintf(bw, "<font color=\"%s\">%s</font>: %s", c.nameColor(), srtEscape(c.Name()), srtEscape(singleLine(c.Text())))
+		}
+		bw.WriteString("\n\n")
+	}
+	return bw.Flush()
+}
+
+// WriteASS writes the chat as styled Advanced SubStation Alpha subtitles with colored names.
+func WriteASS(w io.Writer, chat *Chat, opts SubtitleOptions) error {
+	alignment, err := opts.alignment()
+	if err != nil {
+		return err
+	}
+	const width, height, margin = 1920, 1080, 20
+	marginL, marginR := margin, width-margin-opts.width()
+	if alignment == 3 || alignment == 9 {
+		marginL, marginR = marginR, marginL
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `[Script Info]
+ScriptType: v4.00+
+PlayResX: %d
+PlayResY: %d
+WrapStyle: 0
+ScaledBorderAndShadow: yes
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Chat,%s,%d,&H00FFFFFF,&H00FFFFFF,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,2,0,%d,%d,%d,%d,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`, width, height, opts.font(), opts.fontSize(), alignment, marginL, marginR, margin)
+
+	for _, e := range chatEvents(chat.Comments, opts.duration().Seconds(), opts.lines()) {
+		var lines []string
+		for _, c := range e.comments {
+			lines = append(lines, fmt.Sprintf("{\\b1\\c%s}%s{\\b0\\c}: %s", assColor(c.nameColor()), assEscape(c.Name()), assEscape(singleLine(c.Text()))))
+		}
+		fmt.Fprintf(bw, "Dialogue: 0,%s,%s,Chat,,0,0,0,,%s\n", assTime(e.start), assTime(e.end), strings.Join(lines, "\\N"))
+	}
+	return bw.Flush()
+}
+
+// ConvertChat renders the chat replay at chatPath as subtitles in format, SubtitlesSRT or
+// SubtitlesASS. They are saved next to the chat, for example vod.chat.json becomes vod.chat.ass.
+// Returns the path of the subtitles.
+func ConvertChat(chatPath string, format string, opts SubtitleOptions) (string, error) {
+	chat, err := ReadChat(chatPath)
+	if err != nil {
+		return "", err
+	}
+	o := Options{Subtitles: format, SubtitleOptions: opts, Chat: true}
+	if err := o.checkFormat(); err != nil {
+		return "", err
+	}
+	// writeSubtitles names the subtitles after the video
+	videoPath := strings.TrimSuffix(strings.TrimSuffix(chatPath, ".json"), ".chat") + ".mp4"
+	return writeSubtitles(chat, videoPath, o)
+}
+
+/*
+Writes the chat as subtitles in opts.Subtitles next to vodSavePath and returns their path
+*/
+func writeSubtitles(chat *Chat, vodSavePath string, opts Options) (string, error) {
+	path := subtitleSavePath(vodSavePath, opts)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if opts.Subtitles == SubtitlesASS {
+		err = WriteASS(f, chat, opts.SubtitleOptions)
+	} else {
+		err = WriteSRT(f, chat, opts.SubtitleOptions)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+/*
+Returns the path of the chat subtitles next to the video at vodSavePath
+*/
+func subtitleSavePath(vodSavePath string, opts Options) string {
+	return strings.TrimSuffix(chatSavePath(vodSavePath), ".json") + "." + opts.Subtitles
+}
+
+// srtEscaper keeps markup in chat messages from being read as the tags of SRT. libass reads ASS
+// override tags in SRT too, so braces and backslashes are replaced like assEscape does
+var srtEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `\`, "⧵", "{", "❴", "}", "❵")
+
+func srtEscape(text string) string {
+	return srtEscaper.Replace(text)
+}
+
+func singleLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+/*
+Formats seconds as HH:MM:SS,mmm
+*/
+func srtTime(seconds float64) string {
+	ms := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+/*
+Formats seconds as H:MM:SS.cc, ASS has centiseconds
+*/
+func assTime(seconds float64) string {
+	cs := int64(seconds*100 + 0.5)
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+/*
+Converts #RRGGBB into the &HBBGGRR& of ASS
+*/
+func assColor(color string) string {
+	return "&H" + color[5:7] + color[3:5] + color[1:3] + "&"
+}
+
+/*
+Keeps braces and backslashes of messages from being read as override tags
+*/
+func assEscape(text string) string {
+	return strings.NewReplacer(`\`, "⧵", "{", "❴", "}", "❵").Replace(text)
+}
@@ -0,0 +1,189 @@
+package concat
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testComment(offset float64, login string, color string, text string) ChatComment {
+	return ChatComment{
+		ID:        login + text,
+		Offset:    offset,
+		Commenter: ChatUser{Login: login, DisplayName: strings.ToUpper(login[:1]) + login[1:]},
+		Color:     color,
+		Fragments: []ChatFragment{{Text: text}},
+	}
+}
+
+func TestChatEvents(t *testing.T) {
+	comments := []ChatComment{
+		testComment(0, "a", "", "1"),
+		testComment(1, "b", "", "2"),
+		testComment(2, "c", "", "3"),
+		testComment(10, "d", "", "4"),
+	}
+	events := chatEvents(comments, 5, 2)
+
+	want := []struct {
+		start, end float64
+		texts      string
+	}{
+		{0, 1, "1"},
+		{1, 2, "12"},
+		{2, 5, "23"},
+		{5, 6, "23"},
+		{6, 7, "3"},
+		{10, 15, "4"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, w := range want {
+		var texts string
+		for _, c := range events[i].comments {
+			texts += c.Text()
+		}
+		if e := events[i]; e.start != w.start || e.end != w.end || texts != w.texts {
+			t.Errorf("event %d is %v-%v %q, want %v-%v %q", i, e.start, e.end, texts, w.start, w.end, w.texts)
+		}
+	}
+}
+
+func TestWriteSRT(t *testing.T) {
+	chat := &Chat{Comments: []ChatComment{
+		testComment(1.5, "viewer", "#ff4500", "hello\nthere"),
+		testComment(3, "other", "", "hi"),
+	}}
+	var buf bytes.Buffer
+	if err := WriteSRT(&buf, chat, SubtitleOptions{Duration: 2 * time.Second, Position: ChatTopRight}); err != nil {
+		t.Fatal(err)
+	}
+	otherColor := chat.Comments[1].nameColor()
+	want := "1\n00:00:01,500 --> 00:00:03,000\n{\\an9}<font color=\"#FF4500\">Viewer</font>: hello there\n\n" +
+		"2\n00:00:03,000 --> 00:00:03,500\n{\\an9}<font color=\"#FF4500\">Viewer</font>: hello there\n<font color=\"" + otherColor + "\">Other</font>: hi\n\n" +
+		"3\n00:00:03,500 --> 00:00:05,000\n{\\an9}<font color=\"" + otherColor + "\">Other</font>: hi\n\n"
+	if buf.String() != want {
+		t.Errorf("got\n%q\nwant\n%q", buf.String(), want)
+	}
+
+	// markup in messages is shown as typed and doesn't end the font tag
+	buf.Reset()
+	chat = &Chat{Comments: []ChatComment{testComment(0, "viewer", "#FF4500", "</font><b>bold</b> & <i>")}}
+	if err := WriteSRT(&buf, chat, SubtitleOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want = "<font color=\"#FF4500\">Viewer</font>: &lt;/font&gt;&lt;b&gt;bold&lt;/b&gt; &amp; &lt;i&gt;\n\n"
+	if !strings.HasSuffix(buf.String(), want) {
+		t.Errorf("got\n%q\nwant the cue\n%q", buf.String(), want)
+	}
+
+	// so are the override tags libass reads in SRT
+	buf.Reset()
+	chat = &Chat{Comments: []ChatComment{testComment(0, "viewer", "#FF4500", `{\an8}top {\c&H0000FF&}red`)}}
+	if err := WriteSRT(&buf, chat, SubtitleOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want = "<font color=\"#FF4500\">Viewer</font>: ❴⧵an8❵top ❴⧵c&amp;H0000FF&amp;❵red\n\n"
+	if !strings.HasSuffix(buf.String(), want) {
+		t.Errorf("got\n%q\nwant the cue\n%q", buf.String(), want)
+	}
+
+	if err := WriteSRT(&buf, chat, SubtitleOptions{Position: "middle"}); err == nil {
+		t.Error("expected error for unknown position")
+	}
+}
+
+func TestWriteASS(t *testing.T) {
+	chat := &Chat{Comments: []ChatComment{testComment(61.25, "viewer", "#1E90FF", `{\b1}bold`)}}
+	var buf bytes.Buffer
+	opts := SubtitleOptions{Position: ChatBottomRight, Width: 500, Font: "DejaVu Sans", FontSize: 40}
+	if err := WriteASS(&buf, chat, opts); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "Style: Chat,DejaVu Sans,40,") || !strings.Contains(out, ",3,1400,20,20,1\n") {
+		t.Errorf("unexpected style:\n%s", out)
+	}
+	want := "Dialogue: 0,0:01:01.25,0:01:09.25,Chat,,0,0,0,,{\\b1\\c&HFF901E&}Viewer{\\b0\\c}: ❴⧵b1❵bold\n"
+	if !strings.HasSuffix(out, want) {
+		t.Errorf("got\n%s\nwant the dialogue\n%s", out, want)
+	}
+}
+
+func TestNameColor(t *testing.T) {
+	c := testComment(0, "viewer", "", "")
+	if color := c.nameColor(); color != c.nameColor() || len(color) != 7 {
+		t.Errorf("default color %q isn't stable", color)
+	}
+	c.Color = "#abcdef"
+	if color := c.nameColor(); color != "#ABCDEF" {
+		t.Errorf("got %q, want the color of the user", color)
+	}
+}
+
+func TestDownloadSubtitles(t *testing.T) {
+	server := newVODServer(t, 3)
+	requests := 0
+	server.gql = videoCommentsHandler(t, &requests)
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d := newTestDownloader(t, server, dir)
+	opts := DefaultOptions()
+	opts.DownloadPath = dir
+	opts.Format = FormatMKV
+	opts.Chat = true
+	opts.Subtitles = SubtitlesASS
+	opts.MuxSubtitles = true
+
+	if err := d.Download(context.Background(), vodString, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	subtitles := filepath.Join(dir, vodString+".chat.ass")
+	data, err := ioutil.ReadFile(subtitles)
+	if err != nil || !bytes.Contains(data, []byte("Dialogue: 0,0:00:00.00,")) {
+		t.Errorf("chat subtitles weren't written: %v", err)
+	}
+	runs := ffmpegRuns(t, dir)
+	if len(runs) != 1 || !strings.Contains(runs[0], " -i "+subtitles+" -c copy -map 0:v? -map 0:a? -map 1:s ") || strings.Contains(runs[0], "mov_text") {
+		t.Errorf("subtitles weren't muxed: %q", runs)
+	}
+
+	opts.Format = FormatTS
+	if err := d.Download(context.Background(), vodString, opts); err == nil {
+		t.Error("expected error for subtitles in a ts file")
+	}
+}
+
+func TestConvertChat(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	chatPath := filepath.Join(dir, "vod.chat.json")
+	if err := ioutil.WriteFile(chatPath, []byte(`{"vod_id":"1","start":0,"comments":[{"id":"1","offset":2,"commenter":{"login":"viewer"},"fragments":[{"text":"hi"}]}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := ConvertChat(chatPath, SubtitlesSRT, SubtitleOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, "vod.chat.srt") {
+		t.Errorf("subtitles were written to %s", path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil || !strings.HasPrefix(string(data), "1\n00:00:02,000 --> 00:00:10,000\n") {
+		t.Errorf("unexpected subtitles %q, %v", data, err)
+	}
+
+	if _, err := ConvertChat(chatPath, "vtt", SubtitleOptions{}); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
@@ -0,0 +1,139 @@
+package concat
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseTimestamp parses a position in a vod. Accepted are
+//   - "1 20 30", hours, minutes and seconds separated by spaces
+//   - "1:20:30" or "20:30", optionally with fractional seconds like "1:20:30.5"
+//   - "1h20m30s" or any other duration like "90s" or "1.5h"
+//   - "4830" or "4830.5", seconds
+//
+// A leading "-" makes the time negative, which Options.End treats as relative to the end of the vod.
+func ParseTimestamp(t string) (time.Duration, error) {
+	s := strings.TrimSpace(t)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimSpace(strings.TrimPrefix(s, "-"))
+
+	d, err := parseTimestamp(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: %v", t, err)
+	}
+	if negative {
+		d = -d
+	}
+	return d, nil
+}
+
+func parseTimestamp(s string) (time.Duration, error) {
+	switch {
+	case s == "":
+		return 0, fmt.Errorf("empty time")
+	case strings.Contains(s, " "):
+		return parseHMS(strings.Fields(s))
+	case strings.Contains(s, ":"):
+		return parseClock(strings.Split(s, ":"))
+	case strings.ContainsAny(s, "hmsµun"):
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, err
+		}
+		if d < 0 {
+			return 0, fmt.Errorf("negative duration")
+		}
+		return d, nil
+	default:
+		return parseSeconds(s)
+	}
+}
+
+/*
+Parses the old "H M S" form
+*/
+func parseHMS(parts []string) (time.Duration, error) {
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("expected hours, minutes and seconds")
+	}
+	var hms [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%q is not a number", p)
+		}
+		hms[i] = n
+	}
+	return hmsDuration(hms[0], hms[1], hms[2], 0)
+}
+
+/*
+Parses "HH:MM:SS" and "MM:SS", the seconds can have a fraction
+*/
+func parseClock(parts []string) (time.Duration, error) {
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("expected HH:MM:SS or MM:SS")
+	}
+	seconds, err := parseSeconds(parts[len(parts)-1])
+	if err != nil {
+		return 0, err
+	}
+	if seconds >= time.Minute {
+		return 0, fmt.Errorf("seconds must be less than 60")
+	}
+
+	var units []int
+	for _, p := range parts[:len(parts)-1] {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%q is not a number", p)
+		}
+		units = append(units, n)
+	}
+	h, m := 0, units[len(units)-1]
+	if len(units) == 2 {
+		h = units[0]
+		if m >= 60 {
+			return 0, fmt.Errorf("minutes must be less than 60")
+		}
+	}
+	return hmsDuration(h, m, 0, seconds)
+}
+
+// the most whole seconds a duration holds
+const maxSeconds = math.MaxInt64 / int64(time.Second)
+
+/*
+Returns h hours, m minutes, s seconds and fraction as a duration, or an error if that doesn't fit
+a duration instead of wrapping around
+*/
+func hmsDuration(h, m, s int, fraction time.Duration) (time.Duration, error) {
+	if int64(h) > maxSeconds/3600 || int64(m) > maxSeconds/60 || int64(s) > maxSeconds {
+		return 0, fmt.Errorf("time is too long")
+	}
+	total := int64(h)*3600 + int64(m)*60 + int64(s)
+	if total > maxSeconds {
+		return 0, fmt.Errorf("time is too long")
+	}
+	d := time.Duration(total) * time.Second
+	if d > math.MaxInt64-fraction {
+		return 0, fmt.Errorf("time is too long")
+	}
+	return d + fraction, nil
+}
+
+func parseSeconds(s string) (time.Duration, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, fmt.Errorf("%q is not a number of seconds", s)
+	}
+	// float64(math.MaxInt64) rounds up to 1<<63, which doesn't fit a duration anymore
+	ns := math.Round(f * float64(time.Second))
+	if ns >= float64(math.MaxInt64) {
+		return 0, fmt.Errorf("%q seconds is too long", s)
+	}
+	return time.Duration(ns), nil
+}
@@ -0,0 +1,44 @@
+package concat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"0 0 0", 0},
+		{"1 20 30", time.Hour + 20*time.Minute + 30*time.Second},
+		{"0 90 0", 90 * time.Minute},
+		{"1:20:30", time.Hour + 20*time.Minute + 30*time.Second},
+		{"20:30", 20*time.Minute + 30*time.Second},
+		{"90:30", 90*time.Minute + 30*time.Second},
+		{"1:20:30.25", time.Hour + 20*time.Minute + 30*time.Second + 250*time.Millisecond},
+		{"1h20m30s", time.Hour + 20*time.Minute + 30*time.Second},
+		{"1.5h", 90 * time.Minute},
+		{"4830", 4830 * time.Second},
+		{"12.5", 12*time.Second + 500*time.Millisecond},
+		{" 10 ", 10 * time.Second},
+		{"-10m", -10 * time.Minute},
+		{"-1:00", -time.Minute},
+		{"9223372036", 9223372036 * time.Second},
+		{"2562047 0 0", 2562047 * time.Hour},
+		{"2562047:47:16", 2562047*time.Hour + 47*time.Minute + 16*time.Second},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimestamp(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseTimestamp(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "-", "1 20", "1 x 30", "1 -20 30", "1:20:30:40", "1:60:00", "1:20:60", "a:20", "1h20x", "abc", "--10m", "NaN", "Inf", "1e400", "1e10", "9223372037", "1:00:1e10",
+		"3000000 0 0", "5124096 0 0", "0 0 9223372037", "2562048:00:00", "9999999999:00:00", "2562047:47:16.9"} {
+		if got, err := ParseTimestamp(in); err == nil {
+			t.Errorf("ParseTimestamp(%q) = %v, expected error", in, got)
+		}
+	}
+}
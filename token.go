@@ -0,0 +1,165 @@
+package concat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const playbackAccessTokenHash string = "0828119ded1c13477966434e15800ff57ddacf13ba1911c129dc2200705b0712"
+
+// AccessToken is the signature and token pair needed for accessing the usher api.
+type AccessToken struct {
+	Signature string
+	Value     string
+}
+
+// TokenProvider returns access tokens for vods and live channels.
+type TokenProvider interface {
+	VODToken(ctx context.Context, vodID string) (AccessToken, error)
+	ChannelToken(ctx context.Context, channel string) (AccessToken, error)
+}
+
+// GQLTokenProvider gets access tokens through the GQL PlaybackAccessToken query.
+type GQLTokenProvider struct {
+	// HTTPClient is used for the requests. http.DefaultClient is used if nil.
+	HTTPClient *http.Client
+	// Endpoint of the GQL api. DefaultGQLEndpoint is used if empty.
+	Endpoint string
+	// ClientID sent to the GQL api. DefaultGQLClientID is used if empty.
+	ClientID string
+}
+
+type playbackAccessToken struct {
+	Value     string `json:"value"`
+	Signature string `json:"signature"`
+}
+
+// VODToken returns the access token of a vod.
+func (p *GQLTokenProvider) VODToken(ctx context.Context, vodID string) (AccessToken, error) {
+	var data struct {
+		VideoPlaybackAccessToken *playbackAccessToken `json:"videoPlaybackAccessToken"`
+	}
+	err := p.playbackAccessToken(ctx, map[string]interface{}{
+		"isLive":     false,
+		"login":      "",
+		"isVod":      true,
+		"vodID":      vodID,
+		"playerType": "embed",
+	}, &data)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	if data.VideoPlaybackAccessToken == nil {
+		return AccessToken{}, fmt.Errorf("no access token for vod %s", vodID)
+	}
+	return AccessToken{Signature: data.VideoPlaybackAccessToken.Signature, Value: data.VideoPlaybackAccessToken.Value}, nil
+}
+
+// ChannelToken returns the access token of the live stream of a channel.
+func (p *GQLTokenProvider) ChannelToken(ctx context.Context, channel string) (AccessToken, error) {
+	var data struct {
+		StreamPlaybackAccessToken *playbackAccessToken `json:"streamPlaybackAccessToken"`
+	}
+	err := p.playbackAccessToken(ctx, map[string]interface{}{
+		"isLive":     true,
+		"login":      strings.ToLower(channel),
+		"isVod":      false,
+		"vodID":      "",
+		"playerType": "embed",
+	}, &data)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	if data.StreamPlaybackAccessToken == nil {
+		return AccessToken{}, fmt.Errorf("no access token for channel %s", channel)
+	}
+	return AccessToken{Signature: data.StreamPlaybackAccessToken.Signature, Value: data.StreamPlaybackAccessToken.Value}, nil
+}
+
+func (p *GQLTokenProvider) playbackAccessToken(ctx context.Context, variables map[string]interface{}, data interface{}) error {
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultGQLEndpoint
+	}
+	clientID := p.ClientID
+	if clientID == "" {
+		clientID = DefaultGQLClientID
+	}
+	return postGQL(ctx, client, endpoint, clientID, persistedQuery("PlaybackAccessToken", playbackAccessTokenHash, variables), data)
+}
+
+/*
+legacyTokenProvider uses the retired api.twitch.tv access_token endpoints.
+Only used as a fallback if the GQL api doesn't work.
+*/
+type legacyTokenProvider struct {
+	d *Downloader
+}
+
+func (p legacyTokenProvider) VODToken(ctx context.Context, vodID string) (AccessToken, error) {
+	return p.token(ctx, p.d.tokenAPILink(vodID))
+}
+
+func (p legacyTokenProvider) ChannelToken(ctx context.Context, channel string) (AccessToken, error) {
+	return p.token(ctx, p.d.channelTokenAPILink(channel))
+}
+
+func (p legacyTokenProvider) token(ctx context.Context, tokenAPILink string) (AccessToken, error) {
+	sig, token, err := p.d.accessTokenAPI(ctx, tokenAPILink)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	return AccessToken{Signature: sig, Value: token}, nil
+}
+
+// FallbackTokenProvider tries each provider in order and returns the first token it gets.
+type FallbackTokenProvider []TokenProvider
+
+// VODToken returns the access token of a vod from the first provider that has one.
+func (f FallbackTokenProvider) VODToken(ctx context.Context, vodID string) (AccessToken, error) {
+	return f.first(func(p TokenProvider) (AccessToken, error) {
+		return p.VODToken(ctx, vodID)
+	})
+}
+
+// ChannelToken returns the access token of a channel from the first provider that has one.
+func (f FallbackTokenProvider) ChannelToken(ctx context.Context, channel string) (AccessToken, error) {
+	return f.first(func(p TokenProvider) (AccessToken, error) {
+		return p.ChannelToken(ctx, channel)
+	})
+}
+
+func (f FallbackTokenProvider) first(get func(TokenProvider) (AccessToken, error)) (AccessToken, error) {
+	var errs []string
+	for _, p := range f {
+		token, err := get(p)
+		if err == nil && (token.Signature == "" || token.Value == "") {
+			err = errors.New("empty signature or token")
+		}
+		if err == nil {
+			return token, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	if len(errs) == 0 {
+		return AccessToken{}, errors.New("no token provider")
+	}
+	return AccessToken{}, errors.New(strings.Join(errs, "; "))
+}
+
+func (d *Downloader) tokenProvider() TokenProvider {
+	if d.TokenProvider != nil {
+		return d.TokenProvider
+	}
+	return FallbackTokenProvider{
+		&GQLTokenProvider{HTTPClient: d.HTTPClient, Endpoint: d.GQLEndpoint, ClientID: d.GQLClientID},
+		legacyTokenProvider{d: d},
+	}
+}
@@ -0,0 +1,140 @@
+package concat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newGQLServer starts a GQL stand-in that answers every request with handle.
+func newGQLServer(t *testing.T, handle func(req gqlRequest) (interface{}, error)) *httptest.Server {
+	return httptest.NewServer(gqlHandler(t, handle))
+}
+
+func gqlHandler(t *testing.T, handle func(req gqlRequest) (interface{}, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Client-ID") == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var req gqlRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("could not decode gql request: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := handle(req)
+		resp := map[string]interface{}{"data": data}
+		if err != nil {
+			resp["errors"] = []map[string]string{{"message": err.Error()}}
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+func playbackAccessTokenHandler(t *testing.T) func(req gqlRequest) (interface{}, error) {
+	return func(req gqlRequest) (interface{}, error) {
+		if req.OperationName != "PlaybackAccessToken" || req.Extensions == nil || req.Extensions.PersistedQuery.SHA256Hash != playbackAccessTokenHash {
+			t.Errorf("unexpected gql request: %+v", req)
+		}
+		if req.Variables["isVod"] == true {
+			return map[string]interface{}{
+				"videoPlaybackAccessToken": map[string]string{
+					"value":     fmt.Sprintf(`{"vod_id":%v}`, req.Variables["vodID"]),
+					"signature": "vodsig",
+				},
+			}, nil
+		}
+		if req.Variables["login"] == "" {
+			return nil, errors.New("no login")
+		}
+		return map[string]interface{}{
+			"streamPlaybackAccessToken": map[string]string{
+				"value":     fmt.Sprintf(`{"channel":"%v"}`, req.Variables["login"]),
+				"signature": "channelsig",
+			},
+		}, nil
+	}
+}
+
+func TestGQLTokenProvider(t *testing.T) {
+	server := newGQLServer(t, playbackAccessTokenHandler(t))
+	defer server.Close()
+
+	p := &GQLTokenProvider{Endpoint: server.URL}
+
+	token, err := p.VODToken(context.Background(), vodString)
+	if err != nil || token.Signature != "vodsig" || token.Value != `{"vod_id":187938112}` {
+		t.Errorf("VODToken: got %+v, %v", token, err)
+	}
+
+	token, err = p.ChannelToken(context.Background(), "Reckful")
+	if err != nil || token.Signature != "channelsig" || token.Value != `{"channel":"reckful"}` {
+		t.Errorf("ChannelToken: got %+v, %v", token, err)
+	}
+
+	_, err = p.ChannelToken(context.Background(), "")
+	if err == nil {
+		t.Errorf("ChannelToken: expected gql error")
+	}
+}
+
+type staticTokenProvider struct {
+	token AccessToken
+	err   error
+}
+
+func (p staticTokenProvider) VODToken(ctx context.Context, vodID string) (AccessToken, error) {
+	return p.token, p.err
+}
+
+func (p staticTokenProvider) ChannelToken(ctx context.Context, channel string) (AccessToken, error) {
+	return p.token, p.err
+}
+
+func TestFallbackTokenProvider(t *testing.T) {
+	want := AccessToken{Signature: "sig", Value: "token"}
+	f := FallbackTokenProvider{
+		staticTokenProvider{err: errors.New("retired")},
+		staticTokenProvider{token: AccessToken{}},
+		staticTokenProvider{token: want},
+	}
+
+	token, err := f.VODToken(context.Background(), vodString)
+	if err != nil || token != want {
+		t.Errorf("VODToken: got %+v, %v", token, err)
+	}
+
+	_, err = f[:2].ChannelToken(context.Background(), "reckful")
+	if err == nil {
+		t.Errorf("ChannelToken: expected error if no provider returns a token")
+	}
+}
+
+func TestQualityOptionsUsesTokenProvider(t *testing.T) {
+	gqlServer := newGQLServer(t, playbackAccessTokenHandler(t))
+	defer gqlServer.Close()
+
+	var usherServer *httptest.Server
+	usherServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("nauthsig") != "vodsig" {
+			http.Error(w, "bad token", http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, usherResponse, usherServer.URL)
+	}))
+	defer usherServer.Close()
+
+	d := &Downloader{GQLEndpoint: gqlServer.URL, UsherBaseURL: usherServer.URL}
+	options, err := d.QualityOptions(context.Background(), vodString)
+	if err != nil || len(options) != 2 {
+		t.Fatalf("QualityOptions: got %v, %v", options, err)
+	}
+	if options[0] != (QualityOption{Resolution: "1080p60", Quality: "chunked"}) {
+		t.Errorf("QualityOptions: got %+v", options[0])
+	}
+}
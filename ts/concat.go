@@ -0,0 +1,66 @@
+package ts
+
+import (
+	"bufio"
+	"io"
+)
+
+// nullPID is the pid of stuffing packets
+const nullPID = 0x1fff
+
+// Concat writes the inputs as a single transport stream. The continuity counters of every pid
+// count on across the inputs and the timestamps continue without jumps, starting at 1.4 seconds
+// like ffmpeg does. Only the part of c is written.
+func Concat(w io.Writer, inputs []string, c Cut) error {
+	pl, err := planCut(inputs, c)
+	if err != nil {
+		return err
+	}
+	out := func(ts int64) int64 {
+		return ts - pl.startDTS + outputStart
+	}
+
+	bw := bufio.NewWriterSize(w, 64*1024)
+	continuity := make(map[uint16]byte)
+	// keep is whether the current PES of a pid is written
+	keep := make(map[uint16]bool)
+
+	err = newReader().read(inputs, func(p packet, info *packetInfo) error {
+		pid := p.pid()
+		if pid == nullPID {
+			return nil
+		}
+
+		if info.stream != nil {
+			if info.pes {
+				keep[pid] = pl.keep(info)
+				if keep[pid] {
+					setPESTimestamps(p.payload(), info.header, out(info.pts), out(info.dts))
+				}
+			}
+			if !keep[pid] {
+				return nil
+			}
+			if info.hasPCR {
+				p.setPCR(out(info.pcr))
+			}
+		}
+
+		// the counter only counts packets with payload, it starts at 0
+		cc, ok := continuity[pid]
+		if p.hasPayload() {
+			if ok {
+				cc = (cc + 1) & 0x0f
+			}
+			continuity[pid] = cc
+		}
+		p.setContinuity(cc)
+
+		_, err := bw.Write(p)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,254 @@
+package ts
+
+import (
+	"errors"
+	"fmt"
+)
+
+// H.264 NAL unit types
+const (
+	nalIDR = 5
+	nalSPS = 7
+	nalPPS = 8
+	nalAUD = 9
+)
+
+/*
+Splits H.264 or HEVC data in Annex B format into its NAL units, without start codes
+*/
+func splitAnnexB(data []byte) [][]byte {
+	var nals [][]byte
+	start := -1
+	for i := 0; i+2 < len(data); i++ {
+		if data[i] != 0 || data[i+1] != 0 || data[i+2] != 1 {
+			continue
+		}
+		if start >= 0 {
+			nals = append(nals, trimZeros(data[start:i]))
+		}
+		start = i + 3
+		i += 2
+	}
+	if start >= 0 && start < len(data) {
+		nals = append(nals, trimZeros(data[start:]))
+	}
+	return nals
+}
+
+/*
+Removes the zero bytes at the end of a NAL unit, they belong to the next start code.
+NAL units end with the rbsp stop bit, so they never end with a zero byte themselves
+*/
+func trimZeros(nal []byte) []byte {
+	for len(nal) > 0 && nal[len(nal)-1] == 0 {
+		nal = nal[:len(nal)-1]
+	}
+	return nal
+}
+
+// bitReader reads the exp-Golomb coded fields of a SPS
+type bitReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (b *bitReader) bit() uint {
+	if b.pos >= len(b.data)*8 {
+		b.err = errors.New("sps too short")
+		return 0
+	}
+	v := b.data[b.pos/8] >> (7 - uint(b.pos%8)) & 1
+	b.pos++
+	return uint(v)
+}
+
+func (b *bitReader) bits(n int) uint {
+	var v uint
+	for i := 0; i < n; i++ {
+		v = v<<1 | b.bit()
+	}
+	return v
+}
+
+func (b *bitReader) ue() uint {
+	zeros := 0
+	for b.bit() == 0 && b.err == nil {
+		zeros++
+		if zeros > 31 {
+			b.err = errors.New("invalid exp-Golomb code in sps")
+			return 0
+		}
+	}
+	return 1<<uint(zeros) - 1 + b.bits(zeros)
+}
+
+func (b *bitReader) se() int {
+	v := b.ue()
+	if v%2 == 1 {
+		return int(v+1) / 2
+	}
+	return -int(v / 2)
+}
+
+/*
+Removes the emulation prevention bytes, the 3 in 00 00 03
+*/
+func unescapeRBSP(nal []byte) []byte {
+	out := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, c := range nal {
+		if zeros >= 2 && c == 3 {
+			zeros = 0
+			continue
+		}
+		out = append(out, c)
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
+
+/*
+Returns the width and height of the pictures of a H.264 SPS NAL unit
+*/
+func spsResolution(sps []byte) (int, int, error) {
+	if len(sps) < 4 {
+		return 0, 0, errors.New("sps too short")
+	}
+	b := &bitReader{data: unescapeRBSP(sps[1:])}
+	profile := b.bits(8)
+	b.bits(16) // constraint flags and level
+	b.ue()     // seq_parameter_set_id
+
+	chromaFormat := uint(1)
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat = b.ue()
+		if chromaFormat == 3 {
+			b.bit() // separate_colour_plane_flag
+		}
+		b.ue()  // bit_depth_luma_minus8
+		b.ue()  // bit_depth_chroma_minus8
+		b.bit() // qpprime_y_zero_transform_bypass_flag
+		if b.bit() == 1 {
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if b.bit() == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := 8, 8
+				for j := 0; j < size; j++ {
+					if next != 0 {
+						next = (last + b.se() + 256) % 256
+					}
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+
+	b.ue() // log2_max_frame_num_minus4
+	switch b.ue() {
+	case 0:
+		b.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		b.bit()
+		b.se()
+		b.se()
+		n := b.ue()
+		for i := uint(0); i < n && b.err == nil; i++ {
+			b.se()
+		}
+	}
+	b.ue()  // max_num_ref_frames
+	b.bit() // gaps_in_frame_num_value_allowed_flag
+
+	widthMbs := int(b.ue()) + 1
+	heightMapUnits := int(b.ue()) + 1
+	frameMbsOnly := int(b.bit())
+	if frameMbsOnly == 0 {
+		b.bit() // mb_adaptive_frame_field_flag
+	}
+	b.bit() // direct_8x8_inference_flag
+
+	width := widthMbs * 16
+	height := (2 - frameMbsOnly) * heightMapUnits * 16
+	if b.bit() == 1 {
+		left, right, top, bottom := int(b.ue()), int(b.ue()), int(b.ue()), int(b.ue())
+		cropX, cropY := 1, 2-frameMbsOnly
+		switch chromaFormat {
+		case 1:
+			cropX, cropY = 2, 2*(2-frameMbsOnly)
+		case 2:
+			cropX = 2
+		}
+		width -= (left + right) * cropX
+		height -= (top + bottom) * cropY
+	}
+	if b.err != nil {
+		return 0, 0, b.err
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid resolution %dx%d in sps", width, height)
+	}
+	return width, height, nil
+}
+
+var adtsSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// adtsFrame is an AAC frame of an ADTS stream
+type adtsFrame struct {
+	// config is the AudioSpecificConfig of the frame
+	config     []byte
+	sampleRate int
+	channels   int
+	// data is the raw AAC frame without ADTS header
+	data []byte
+}
+
+/*
+Splits ADTS data into its AAC frames
+*/
+func splitADTS(data []byte) ([]adtsFrame, error) {
+	var frames []adtsFrame
+	for len(data) > 0 {
+		if len(data) < 7 || data[0] != 0xff || data[1]&0xf0 != 0xf0 {
+			return frames, errors.New("invalid adts header")
+		}
+		headerLength := 7
+		if data[1]&0x01 == 0 {
+			// header with crc
+			headerLength = 9
+		}
+		profile := data[2] >> 6
+		rateIndex := data[2] >> 2 & 0x0f
+		channels := data[2]&0x01<<2 | data[3]>>6
+		length := int(data[3]&0x03)<<11 | int(data[4])<<3 | int(data[5]>>5)
+		if int(rateIndex) >= len(adtsSampleRates) || length < headerLength || length > len(data) {
+			return frames, errors.New("invalid adts header")
+		}
+
+		objectType := profile + 1
+		frames = append(frames, adtsFrame{
+			config:     []byte{objectType<<3 | rateIndex>>1, rateIndex<<7 | channels<<3},
+			sampleRate: adtsSampleRates[rateIndex],
+			channels:   int(channels),
+			data:       data[headerLength:length],
+		})
+		data = data[length:]
+	}
+	return frames, nil
+}
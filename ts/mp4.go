@@ -0,0 +1,517 @@
+package ts
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// movieTimescale is the timescale of the mvhd and the edit lists, milliseconds
+const movieTimescale = 1000
+
+// RemuxMP4 writes the H.264 video and AAC audio of the inputs into a MP4, with the same timestamp
+// fixes as Concat. Other streams like twitch's ID3 metadata are left out. Only the part of c is
+// written. The moov box is written at the end, after the samples.
+func RemuxMP4(w io.WriteSeeker, inputs []string, c Cut) error {
+	pl, err := planCut(inputs, c)
+	if err != nil {
+		return err
+	}
+
+	m, err := newMP4Writer(w)
+	if err != nil {
+		return err
+	}
+
+	// the PES of every pid that is still being read
+	pending := make(map[uint16]*pes)
+	flush := func(pid uint16) error {
+		pe := pending[pid]
+		delete(pending, pid)
+		if pe == nil {
+			return nil
+		}
+		return m.addPES(pl, pe)
+	}
+
+	err = newReader().read(inputs, func(p packet, info *packetInfo) error {
+		s := info.stream
+		if s == nil {
+			return nil
+		}
+		switch s.typ {
+		case streamTypeH264, streamTypeAAC:
+		case streamTypeHEVC:
+			return errors.New("ts: mp4 remuxing only supports H.264 video, use a transport stream for HEVC")
+		default:
+			return nil
+		}
+
+		pid := p.pid()
+		if p.unitStart() {
+			if err := flush(pid); err != nil {
+				return err
+			}
+			if info.pes && pl.keep(info) {
+				data := p.payload()[info.header.dataStart:]
+				pending[pid] = &pes{stream: s, pts: info.pts, dts: info.dts, data: append([]byte(nil), data...)}
+			}
+			return nil
+		}
+		if pe := pending[pid]; pe != nil {
+			pe.data = append(pe.data, p.payload()...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var pids []int
+	for pid := range pending {
+		pids = append(pids, int(pid))
+	}
+	sort.Ints(pids)
+	for _, pid := range pids {
+		if err := flush(uint16(pid)); err != nil {
+			return err
+		}
+	}
+	return m.finish(pl)
+}
+
+// pes is a complete PES of an elementary stream
+type pes struct {
+	stream *stream
+	pts    int64
+	dts    int64
+	data   []byte
+}
+
+// sample is a frame in the mdat
+type sample struct {
+	// dts in the timescale of the track
+	dts  int64
+	cts  int64
+	size uint32
+	key  bool
+}
+
+// chunk is a run of samples of a track that are stored after each other
+type chunk struct {
+	offset int64
+	count  int
+}
+
+type track struct {
+	id        int
+	video     bool
+	timescale int64
+	samples   []sample
+	chunks    []chunk
+
+	// start is the PTS of the first sample, in the 90kHz clock
+	start int64
+
+	sps, pps      []byte
+	width, height int
+
+	config     []byte
+	sampleRate int
+	channels   int
+}
+
+type mp4Writer struct {
+	w  io.WriteSeeker
+	bw *bufio.Writer
+	// pos is the position in the file
+	pos       int64
+	mdatStart int64
+
+	video, audio *track
+	// last is the track of the last sample, for the chunks
+	last *track
+}
+
+func newMP4Writer(w io.WriteSeeker) (*mp4Writer, error) {
+	m := &mp4Writer{w: w, bw: bufio.NewWriterSize(w, 256*1024)}
+	ftyp := mp4Box("ftyp", []byte("isom"), u32(0x200), []byte("isomiso2avc1mp41"))
+	if err := m.write(ftyp); err != nil {
+		return nil, err
+	}
+	m.mdatStart = m.pos
+	// the size of the mdat is a 64 bit largesize, it is filled in by finish
+	if err := m.write(u32(1), []byte("mdat"), u64(0)); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *mp4Writer) write(parts ...[]byte) error {
+	for _, b := range parts {
+		n, err := m.bw.Write(b)
+		m.pos += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Writes a sample of t into the mdat
+*/
+func (m *mp4Writer) addSample(t *track, s sample, data ...[]byte) error {
+	if m.last != t || len(t.chunks) == 0 {
+		t.chunks = append(t.chunks, chunk{offset: m.pos})
+	}
+	m.last = t
+	t.chunks[len(t.chunks)-1].count++
+
+	start := m.pos
+	if err := m.write(data...); err != nil {
+		return err
+	}
+	s.size = uint32(m.pos - start)
+	t.samples = append(t.samples, s)
+	return nil
+}
+
+func (m *mp4Writer) addPES(pl *plan, pe *pes) error {
+	if pe.stream.typ == streamTypeH264 {
+		return m.addVideo(pl, pe)
+	}
+	return m.addAudio(pl, pe)
+}
+
+func (m *mp4Writer) addVideo(pl *plan, pe *pes) error {
+	if m.video == nil {
+		m.video = &track{video: true, timescale: clockRate, start: pe.pts}
+	}
+	t := m.video
+
+	// parameter sets go into the avcC and access unit delimiters aren't needed in a MP4
+	var data [][]byte
+	key := false
+	for _, nal := range splitAnnexB(pe.data) {
+		if len(nal) == 0 {
+			continue
+		}
+		switch nal[0] & 0x1f {
+		case nalSPS:
+			if t.sps == nil {
+				width, height, err := spsResolution(nal)
+				if err != nil {
+					return err
+				}
+				t.sps = append([]byte(nil), nal...)
+				t.width, t.height = width, height
+			}
+			continue
+		case nalPPS:
+			if t.pps == nil {
+				t.pps = append([]byte(nil), nal...)
+			}
+			continue
+		case nalAUD:
+			continue
+		case nalIDR:
+			key = true
+		}
+		data = append(data, u32(uint32(len(nal))), nal)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return m.addSample(t, sample{dts: pe.dts - pl.startDTS, cts: pe.pts - pe.dts, key: key}, data...)
+}
+
+func (m *mp4Writer) addAudio(pl *plan, pe *pes) error {
+	frames, err := splitADTS(pe.data)
+	if err != nil {
+		return fmt.Errorf("ts: audio at %d: %v", pe.pts, err)
+	}
+	for i, f := range frames {
+		pts := pe.pts + int64(i)*1024*clockRate/int64(f.sampleRate)
+		if pl.end >= 0 && pts >= pl.end {
+			break
+		}
+		if m.audio == nil {
+			m.audio = &track{timescale: int64(f.sampleRate), start: pts, config: f.config, sampleRate: f.sampleRate, channels: f.channels}
+		}
+		t := m.audio
+		// every AAC frame has 1024 samples, gaps in the audio are closed
+		s := sample{dts: int64(len(t.samples)) * 1024, key: true}
+		if err := m.addSample(t, s, f.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Fills in the size of the mdat and writes the moov
+*/
+func (m *mp4Writer) finish(pl *plan) error {
+	var tracks []*track
+	if m.video != nil {
+		if m.video.sps == nil || m.video.pps == nil {
+			return errors.New("ts: no H.264 parameter sets in the video")
+		}
+		tracks = append(tracks, m.video)
+	}
+	if m.audio != nil {
+		tracks = append(tracks, m.audio)
+	}
+	if len(tracks) == 0 {
+		return errors.New("ts: no H.264 or AAC stream in the inputs")
+	}
+
+	if err := m.bw.Flush(); err != nil {
+		return err
+	}
+	end := m.pos
+	if _, err := m.w.Seek(m.mdatStart+8, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := m.w.Write(u64(uint64(end - m.mdatStart))); err != nil {
+		return err
+	}
+	if _, err := m.w.Seek(end, io.SeekStart); err != nil {
+		return err
+	}
+
+	movieDuration := int64(0)
+	var traks [][]byte
+	for i, t := range tracks {
+		t.id = i + 1
+		trak, duration := t.trak(pl)
+		traks = append(traks, trak)
+		if duration > movieDuration {
+			movieDuration = duration
+		}
+	}
+
+	mvhd := fullBox("mvhd", 0, 0,
+		u32(0), u32(0), u32(movieTimescale), u32(uint32(movieDuration)),
+		u32(0x00010000), u16(0x0100), make([]byte, 10), matrix(),
+		make([]byte, 24), u32(uint32(len(tracks)+1)))
+	moov := mp4Box("moov", append([][]byte{mvhd}, traks...)...)
+
+	m.bw.Reset(m.w)
+	if err := m.write(moov); err != nil {
+		return err
+	}
+	return m.bw.Flush()
+}
+
+/*
+Returns the durations of the samples, the last sample lasts as long as the one before it
+*/
+func (t *track) durations() []int64 {
+	durations := make([]int64, len(t.samples))
+	for i := range t.samples {
+		switch {
+		case i+1 < len(t.samples):
+			durations[i] = t.samples[i+1].dts - t.samples[i].dts
+		case !t.video:
+			durations[i] = 1024
+		case i > 0:
+			durations[i] = durations[i-1]
+		default:
+			durations[i] = t.timescale / 30
+		}
+		if durations[i] <= 0 {
+			durations[i] = 1
+		}
+	}
+	return durations
+}
+
+/*
+Returns the trak box of t and its duration in the movie timescale, including the delay of its start
+*/
+func (t *track) trak(pl *plan) ([]byte, int64) {
+	durations := t.durations()
+	mediaDuration := int64(0)
+	for _, d := range durations {
+		mediaDuration += d
+	}
+	duration := mediaDuration * movieTimescale / t.timescale
+
+	// the presentation starts at the keyframe the output starts at, a later start of the track
+	// is an empty edit before it
+	var edits [][]byte
+	delay := (t.start - pl.startPTS) * movieTimescale / clockRate
+	if delay > 0 {
+		edits = append(edits, u32(uint32(delay)), u32(0xffffffff), u32(0x00010000))
+	} else {
+		delay = 0
+	}
+	mediaTime := int64(0)
+	if len(t.samples) > 0 {
+		mediaTime = t.samples[0].cts
+	}
+	edits = append(edits, u32(uint32(duration)), u32(uint32(mediaTime)), u32(0x00010000))
+	elst := fullBox("elst", 0, 0, append([][]byte{u32(uint32(len(edits) / 3))}, edits...)...)
+
+	volume, width, height := uint16(0x0100), 0, 0
+	handler, name := "soun", "SoundHandler"
+	mediaHeader := fullBox("smhd", 0, 0, u32(0))
+	if t.video {
+		volume, width, height = 0, t.width, t.height
+		handler, name = "vide", "VideoHandler"
+		mediaHeader = fullBox("vmhd", 0, 1, make([]byte, 8))
+	}
+
+	tkhd := fullBox("tkhd", 0, 3,
+		u32(0), u32(0), u32(uint32(t.id)), u32(0), u32(uint32(delay+duration)),
+		make([]byte, 8), u16(0), u16(0), u16(volume), u16(0), matrix(),
+		u32(uint32(width)<<16), u32(uint32(height)<<16))
+	mdhd := fullBox("mdhd", 0, 0, u32(0), u32(0), u32(uint32(t.timescale)), u32(uint32(mediaDuration)), u16(0x55c4), u16(0))
+	hdlr := fullBox("hdlr", 0, 0, u32(0), []byte(handler), make([]byte, 12), []byte(name), []byte{0})
+	dinf := mp4Box("dinf", fullBox("dref", 0, 0, u32(1), fullBox("url ", 0, 1)))
+
+	minf := mp4Box("minf", mediaHeader, dinf, t.stbl(durations))
+	mdia := mp4Box("mdia", mdhd, hdlr, minf)
+	return mp4Box("trak", tkhd, mp4Box("edts", elst), mdia), delay + duration
+}
+
+func (t *track) stbl(durations []int64) []byte {
+	boxes := [][]byte{fullBox("stsd", 0, 0, u32(1), t.sampleEntry())}
+
+	var stts [][]byte
+	for i := 0; i < len(durations); {
+		j := i
+		for j < len(durations) && durations[j] == durations[i] {
+			j++
+		}
+		stts = append(stts, u32(uint32(j-i)), u32(uint32(durations[i])))
+		i = j
+	}
+	boxes = append(boxes, fullBox("stts", 0, 0, append([][]byte{u32(uint32(len(stts) / 2))}, stts...)...))
+
+	if t.video {
+		var ctts, stss [][]byte
+		hasOffsets := false
+		for i := 0; i < len(t.samples); {
+			j := i
+			for j < len(t.samples) && t.samples[j].cts == t.samples[i].cts {
+				j++
+			}
+			ctts = append(ctts, u32(uint32(j-i)), u32(uint32(t.samples[i].cts)))
+			hasOffsets = hasOffsets || t.samples[i].cts != 0
+			i = j
+		}
+		if hasOffsets {
+			boxes = append(boxes, fullBox("ctts", 0, 0, append([][]byte{u32(uint32(len(ctts) / 2))}, ctts...)...))
+		}
+		for i, s := range t.samples {
+			if s.key {
+				stss = append(stss, u32(uint32(i+1)))
+			}
+		}
+		boxes = append(boxes, fullBox("stss", 0, 0, append([][]byte{u32(uint32(len(stss)))}, stss...)...))
+	}
+
+	var stsc [][]byte
+	for i, c := range t.chunks {
+		if i == 0 || c.count != t.chunks[i-1].count {
+			stsc = append(stsc, u32(uint32(i+1)), u32(uint32(c.count)), u32(1))
+		}
+	}
+	boxes = append(boxes, fullBox("stsc", 0, 0, append([][]byte{u32(uint32(len(stsc) / 3))}, stsc...)...))
+
+	stsz := [][]byte{u32(0), u32(uint32(len(t.samples)))}
+	for _, s := range t.samples {
+		stsz = append(stsz, u32(s.size))
+	}
+	boxes = append(boxes, fullBox("stsz", 0, 0, stsz...))
+
+	co64 := [][]byte{u32(uint32(len(t.chunks)))}
+	for _, c := range t.chunks {
+		co64 = append(co64, u64(uint64(c.offset)))
+	}
+	boxes = append(boxes, fullBox("co64", 0, 0, co64...))
+
+	return mp4Box("stbl", boxes...)
+}
+
+func (t *track) sampleEntry() []byte {
+	if t.video {
+		avcC := mp4Box("avcC",
+			[]byte{1, t.sps[1], t.sps[2], t.sps[3], 0xff, 0xe1},
+			u16(uint16(len(t.sps))), t.sps,
+			[]byte{1}, u16(uint16(len(t.pps))), t.pps)
+		return mp4Box("avc1",
+			make([]byte, 6), u16(1), make([]byte, 16),
+			u16(uint16(t.width)), u16(uint16(t.height)),
+			u32(0x00480000), u32(0x00480000), u32(0), u16(1),
+			make([]byte, 32), u16(0x0018), u16(0xffff), avcC)
+	}
+
+	decoderSpecificInfo := descriptor(0x05, t.config)
+	decoderConfig := descriptor(0x04, []byte{0x40, 0x15, 0, 0, 0}, u32(0), u32(0), decoderSpecificInfo)
+	es := descriptor(0x03, u16(uint16(t.id)), []byte{0}, decoderConfig, descriptor(0x06, []byte{0x02}))
+	return mp4Box("mp4a",
+		make([]byte, 6), u16(1), make([]byte, 8),
+		u16(uint16(t.channels)), u16(16), u16(0), u16(0),
+		u32(uint32(t.sampleRate)<<16), fullBox("esds", 0, 0, es))
+}
+
+func mp4Box(typ string, parts ...[]byte) []byte {
+	size := 8
+	for _, p := range parts {
+		size += len(p)
+	}
+	var b bytes.Buffer
+	b.Grow(size)
+	b.Write(u32(uint32(size)))
+	b.WriteString(typ)
+	for _, p := range parts {
+		b.Write(p)
+	}
+	return b.Bytes()
+}
+
+func fullBox(typ string, version byte, flags uint32, parts ...[]byte) []byte {
+	header := u32(uint32(version)<<24 | flags&0xffffff)
+	return mp4Box(typ, append([][]byte{header}, parts...)...)
+}
+
+/*
+An MPEG-4 descriptor of the esds, the lengths of twitch's descriptors fit into a byte
+*/
+func descriptor(tag byte, parts ...[]byte) []byte {
+	var data []byte
+	for _, p := range parts {
+		data = append(data, p...)
+	}
+	return append([]byte{tag, byte(len(data))}, data...)
+}
+
+func matrix() []byte {
+	return bytes.Join([][]byte{u32(0x00010000), u32(0), u32(0), u32(0), u32(0x00010000), u32(0), u32(0), u32(0), u32(0x40000000)}, nil)
+}
+
+func u16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func u64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
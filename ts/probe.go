@@ -0,0 +1,269 @@
+package ts
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Info is the result of Probe.
+type Info struct {
+	Duration time.Duration
+	Streams  []Stream
+}
+
+// Stream is an elementary stream or a track of a probed file.
+type Stream struct {
+	// Type is video, audio or data.
+	Type string
+	// Codec is h264, hevc, aac or the stream type of the PMT in hex for other streams.
+	Codec string
+	// Width and Height of H.264 video, 0 if they couldn't be read.
+	Width  int
+	Height int
+}
+
+// Probe reads the duration and the streams of a transport stream or a MP4 like RemuxMP4 writes.
+// The duration of a transport stream is the time between its first and last timestamp, gaps
+// and jumps in the timestamps don't count.
+func Probe(path string) (*Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, fmt.Errorf("ts: %s is too short", path)
+	}
+	switch {
+	case header[0] == syncByte:
+		return probeTS(path)
+	case string(header[4:8]) == "ftyp":
+		return probeMP4(f)
+	}
+	return nil, fmt.Errorf("ts: %s is neither a transport stream nor a MP4", path)
+}
+
+// probedStream is what probeTS collects about a stream
+type probedStream struct {
+	Stream
+	pid   uint16
+	first int64
+	last  int64
+	seen  bool
+}
+
+func probeTS(path string) (*Info, error) {
+	r := newReader()
+	streams := make(map[uint16]*probedStream)
+
+	err := r.read([]string{path}, func(p packet, info *packetInfo) error {
+		if !info.pes {
+			return nil
+		}
+		s := streams[info.stream.pid]
+		if s == nil {
+			s = &probedStream{pid: info.stream.pid, Stream: streamOfType(info.stream.typ)}
+			streams[s.pid] = s
+		}
+		if !s.seen || info.pts < s.first {
+			s.first = info.pts
+		}
+		// the last frame lasts as long as the one before it
+		if end := info.pts + info.stream.delta; !s.seen || end > s.last {
+			s.last = end
+		}
+		s.seen = true
+
+		if info.stream.typ == streamTypeH264 && s.Width == 0 {
+			for _, nal := range splitAnnexB(p.payload()[info.header.dataStart:]) {
+				if len(nal) == 0 || nal[0]&0x1f != nalSPS {
+					continue
+				}
+				if width, height, err := spsResolution(nal); err == nil {
+					s.Width, s.Height = width, height
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(streams) == 0 {
+		return nil, fmt.Errorf("ts: no timestamps in %s", path)
+	}
+
+	var sorted []*probedStream
+	for _, s := range streams {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].pid < sorted[j].pid })
+
+	info := &Info{}
+	first, last := sorted[0].first, sorted[0].last
+	for _, s := range sorted {
+		info.Streams = append(info.Streams, s.Stream)
+		if s.first < first {
+			first = s.first
+		}
+		if s.last > last {
+			last = s.last
+		}
+	}
+	info.Duration = ticksDuration(last - first)
+	return info, nil
+}
+
+func streamOfType(typ byte) Stream {
+	switch typ {
+	case streamTypeH264:
+		return Stream{Type: "video", Codec: "h264"}
+	case streamTypeHEVC:
+		return Stream{Type: "video", Codec: "hevc"}
+	case streamTypeAAC:
+		return Stream{Type: "audio", Codec: "aac"}
+	}
+	return Stream{Type: "data", Codec: fmt.Sprintf("0x%02x", typ)}
+}
+
+func ticksDuration(ticks int64) time.Duration {
+	return time.Duration(ticks) * time.Second / clockRate
+}
+
+/*
+Reads the moov box of a MP4, the mdat before it is skipped
+*/
+func probeMP4(f *os.File) (*Info, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var moov []byte
+	for moov == nil {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(f, header[:8]); err != nil {
+			return nil, errors.New("ts: no moov box in the MP4")
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		headerSize := int64(8)
+		if size == 1 {
+			if _, err := io.ReadFull(f, header[8:]); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+		if size < headerSize {
+			return nil, fmt.Errorf("ts: box %q has size %d", header[4:8], size)
+		}
+
+		if string(header[4:8]) != "moov" {
+			if _, err := f.Seek(size-headerSize, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		moov = make([]byte, size-headerSize)
+		if _, err := io.ReadFull(f, moov); err != nil {
+			return nil, err
+		}
+	}
+
+	info := &Info{}
+	if mvhd := childBox(moov, "mvhd"); len(mvhd) >= 20 && mvhd[0] == 0 {
+		timescale := binary.BigEndian.Uint32(mvhd[12:])
+		duration := binary.BigEndian.Uint32(mvhd[16:])
+		if timescale > 0 {
+			info.Duration = time.Duration(duration) * time.Second / time.Duration(timescale)
+		}
+	} else if len(mvhd) >= 32 {
+		timescale := binary.BigEndian.Uint32(mvhd[20:])
+		duration := binary.BigEndian.Uint64(mvhd[24:])
+		if timescale > 0 {
+			info.Duration = time.Duration(duration) * time.Second / time.Duration(timescale)
+		}
+	}
+
+	eachBox(moov, func(typ string, trak []byte) {
+		if typ == "trak" {
+			info.Streams = append(info.Streams, trakStream(trak))
+		}
+	})
+	return info, nil
+}
+
+func trakStream(trak []byte) Stream {
+	var s Stream
+	switch hdlr := childBox(trak, "mdia", "hdlr"); {
+	case len(hdlr) < 12:
+		s.Type = "data"
+	case string(hdlr[8:12]) == "vide":
+		s.Type = "video"
+	case string(hdlr[8:12]) == "soun":
+		s.Type = "audio"
+	default:
+		s.Type = "data"
+	}
+
+	// the first sample entry after the version, flags and entry count of the stsd
+	if stsd := childBox(trak, "mdia", "minf", "stbl", "stsd"); len(stsd) >= 16 {
+		switch entry := string(stsd[12:16]); entry {
+		case "avc1", "avc3":
+			s.Codec = "h264"
+		case "hvc1", "hev1":
+			s.Codec = "hevc"
+		case "mp4a":
+			s.Codec = "aac"
+		default:
+			s.Codec = entry
+		}
+	}
+
+	// width and height are 16.16 fixed point numbers at the end of the tkhd
+	if tkhd := childBox(trak, "tkhd"); s.Type == "video" && len(tkhd) >= 84 {
+		s.Width = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) >> 16)
+		s.Height = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-4:]) >> 16)
+	}
+	return s
+}
+
+/*
+Calls fn with the type and the content of every box in data, stops at the first broken box
+*/
+func eachBox(data []byte, fn func(typ string, content []byte)) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		header := uint64(8)
+		if size == 1 && len(data) >= 16 {
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return
+		}
+		fn(string(data[4:8]), data[header:size])
+		data = data[size:]
+	}
+}
+
+/*
+Returns the content of the first box at path below data, nil if there is none
+*/
+func childBox(data []byte, path ...string) []byte {
+	var found []byte
+	eachBox(data, func(typ string, content []byte) {
+		if found == nil && typ == path[0] {
+			found = content
+		}
+	})
+	if found == nil || len(path) == 1 {
+		return found
+	}
+	return childBox(found, path[1:]...)
+}
@@ -0,0 +1,310 @@
+package ts
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// maxJump is the largest difference to the expected timestamp that isn't treated as discontinuity
+const maxJump = clockRate
+
+// outputStart is the first timestamp of the output, the space before it keeps the PCR positive
+const outputStart = clockRate * 14 / 10
+
+// errStop ends reading the inputs early
+var errStop = errors.New("stop")
+
+// stream is an elementary stream of the inputs
+type stream struct {
+	pid uint16
+	typ byte
+
+	seen bool
+	// last is the last DTS of the input, unwrapped
+	last int64
+	// lastOut is the last corrected DTS and delta the difference to the one before
+	lastOut int64
+	delta   int64
+
+	pcrSeen bool
+	lastPCR int64
+}
+
+func (s *stream) video() bool {
+	return s.typ == streamTypeH264 || s.typ == streamTypeHEVC
+}
+
+// packetInfo is what the reader found out about a packet, timestamps are corrected
+type packetInfo struct {
+	// stream is nil for packets that aren't part of an elementary stream of the PMT
+	stream *stream
+
+	// pes is set if the packet starts a PES with a PTS
+	pes    bool
+	header pesHeader
+	pts    int64
+	dts    int64
+	// key is set for the start of a video keyframe
+	key bool
+
+	hasPCR bool
+	pcr    int64
+}
+
+/*
+reader reads the packets of the inputs in order. It unwraps the timestamps and shifts them so there
+are no jumps between packets of a stream, the shift applies to all streams
+*/
+type reader struct {
+	pmt     map[uint16]bool
+	streams map[uint16]*stream
+	// offset is added to all unwrapped timestamps
+	offset int64
+}
+
+func newReader() *reader {
+	return &reader{pmt: make(map[uint16]bool), streams: make(map[uint16]*stream)}
+}
+
+/*
+Calls fn for every packet of inputs. Stops without error if fn returns errStop
+*/
+func (r *reader) read(inputs []string, fn func(p packet, info *packetInfo) error) error {
+	for _, input := range inputs {
+		err := r.readFile(input, fn)
+		if err == errStop {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *reader) readFile(input string, fn func(p packet, info *packetInfo) error) error {
+	f, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReaderSize(f, 64*1024)
+	p := make(packet, PacketSize)
+	for n := 0; ; n++ {
+		_, err := io.ReadFull(br, p)
+		// a partial packet at the end of a chunk is dropped, like players do
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if p[0] != syncByte {
+			return fmt.Errorf("%s: packet %d doesn't start with the sync byte", input, n)
+		}
+
+		info := r.inspect(p)
+		if err := fn(p, &info); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *reader) inspect(p packet) packetInfo {
+	var info packetInfo
+	pid := p.pid()
+	if pid == 0 {
+		if p.unitStart() {
+			for _, pmt := range parsePAT(p.payload()) {
+				r.pmt[pmt] = true
+			}
+		}
+		return info
+	}
+	if r.pmt[pid] {
+		if p.unitStart() {
+			for _, es := range parsePMT(p.payload()) {
+				if s, ok := r.streams[es.pid]; ok {
+					s.typ = es.typ
+				} else {
+					r.streams[es.pid] = &stream{pid: es.pid, typ: es.typ}
+				}
+			}
+		}
+		return info
+	}
+
+	s := r.streams[pid]
+	if s == nil {
+		return info
+	}
+	info.stream = s
+
+	if p.unitStart() {
+		payload := p.payload()
+		if h, ok := parsePESHeader(payload); ok && h.hasPTS {
+			dts := h.pts
+			if h.hasDTS {
+				dts = h.dts
+			}
+			info.pes = true
+			info.header = h
+			info.pts, info.dts = r.correct(s, h.pts, dts)
+			info.key = s.video() && (p.randomAccess() || startsKeyframe(s.typ, payload[h.dataStart:]))
+		}
+	}
+
+	if pcr, ok := p.pcr(); ok {
+		if s.pcrSeen {
+			pcr = unwrap(s.lastPCR, pcr)
+		} else if s.seen {
+			pcr = unwrap(s.last, pcr)
+		}
+		s.pcrSeen = true
+		s.lastPCR = pcr
+		info.hasPCR = true
+		info.pcr = pcr + r.offset
+	}
+	return info
+}
+
+/*
+Unwraps pts and dts and shifts them by the offset of the reader. If the dts jumps away from where
+the stream is expected to continue, the offset is changed so it continues there
+*/
+func (r *reader) correct(s *stream, pts int64, dts int64) (int64, int64) {
+	if s.seen {
+		dts = unwrap(s.last, dts)
+	}
+	pts = unwrap(dts, pts)
+	s.last = dts
+
+	out := dts + r.offset
+	if s.seen {
+		expected := s.lastOut + s.delta
+		if jump := out - expected; jump > maxJump || jump < -maxJump {
+			r.offset -= jump
+			out = expected
+		}
+		if d := out - s.lastOut; d > 0 {
+			s.delta = d
+		}
+	}
+	s.seen = true
+	s.lastOut = out
+	return pts + r.offset, out
+}
+
+/*
+Reports whether the elementary stream data at the start of a PES contains a keyframe, the
+IDR slice or parameter sets that come before one
+*/
+func startsKeyframe(typ byte, data []byte) bool {
+	for _, nal := range splitAnnexB(data) {
+		if len(nal) == 0 {
+			continue
+		}
+		switch typ {
+		case streamTypeH264:
+			if t := nal[0] & 0x1f; t == nalIDR || t == nalSPS {
+				return true
+			}
+		case streamTypeHEVC:
+			// IRAP pictures and the VPS before them
+			if t := nal[0] >> 1 & 0x3f; t >= 16 && t <= 23 || t == 32 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// plan is where the output starts and ends, in corrected timestamps
+type plan struct {
+	// startPTS and startDTS are the timestamps of the keyframe the output starts at
+	startPTS int64
+	startDTS int64
+	// end is the first timestamp that isn't written, -1 to write till the end
+	end int64
+}
+
+/*
+Finds the keyframe the output starts at and the end of c. The first timestamp of the video, or of
+any stream if there is no video, is the start of the inputs c is relative to
+*/
+func planCut(inputs []string, c Cut) (*plan, error) {
+	r := newReader()
+	var base int64
+	baseFound, keyFound := false, false
+	pl := &plan{end: -1}
+	var start int64
+
+	err := r.read(inputs, func(p packet, info *packetInfo) error {
+		if !info.pes {
+			return nil
+		}
+		hasVideo := false
+		for _, s := range r.streams {
+			hasVideo = hasVideo || s.video()
+		}
+		if hasVideo && !info.stream.video() {
+			return nil
+		}
+
+		if !baseFound {
+			baseFound = true
+			base = info.pts
+			start = base + clockTicks(c.Start)
+			if c.Duration > 0 {
+				pl.end = start + clockTicks(c.Duration)
+			}
+		}
+		if !hasVideo {
+			pl.startPTS, pl.startDTS = start, start
+			keyFound = true
+			return errStop
+		}
+		if !info.key {
+			return nil
+		}
+		if info.pts > start && keyFound {
+			return errStop
+		}
+		pl.startPTS, pl.startDTS = info.pts, info.dts
+		keyFound = true
+		if info.pts >= start {
+			return errStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !keyFound {
+		return nil, errors.New("ts: no keyframe found in the inputs")
+	}
+	return pl, nil
+}
+
+func clockTicks(d time.Duration) int64 {
+	return int64(math.Round(d.Seconds() * clockRate))
+}
+
+/*
+Reports whether the PES of info is written
+*/
+func (pl *plan) keep(info *packetInfo) bool {
+	if pl.end >= 0 && info.pts >= pl.end {
+		return false
+	}
+	if info.stream.video() {
+		return info.dts >= pl.startDTS
+	}
+	return info.pts >= pl.startPTS
+}
@@ -0,0 +1,287 @@
+// Package ts joins MPEG transport streams, like the chunks of twitch vods, without external tools.
+//
+// Concat writes the inputs as a single transport stream with continuous continuity counters and
+// timestamps. RemuxMP4 writes their H.264 and AAC streams into a MP4. Both close the gaps and jumps
+// in the timestamps between inputs, so missing chunks or restarted streams play without stalls.
+package ts
+
+import (
+	"time"
+)
+
+// PacketSize is the size of a transport stream packet.
+const PacketSize = 188
+
+const syncByte = 0x47
+
+// timestamps are 33 bit values of a 90kHz clock
+const (
+	clockRate     = 90000
+	timestampWrap = int64(1) << 33
+)
+
+// stream types of the PMT
+const (
+	streamTypeAAC  = 0x0f
+	streamTypeH264 = 0x1b
+	streamTypeHEVC = 0x24
+)
+
+// Cut is the part of the inputs that is written, relative to the first timestamp of the inputs.
+// The output starts at the last keyframe before Start. A Duration of 0 keeps everything after Start.
+type Cut struct {
+	Start    time.Duration
+	Duration time.Duration
+}
+
+// packet is a single transport stream packet of PacketSize bytes
+type packet []byte
+
+func (p packet) pid() uint16 {
+	return uint16(p[1]&0x1f)<<8 | uint16(p[2])
+}
+
+func (p packet) unitStart() bool {
+	return p[1]&0x40 != 0
+}
+
+func (p packet) hasAdaptation() bool {
+	return p[3]&0x20 != 0
+}
+
+func (p packet) hasPayload() bool {
+	return p[3]&0x10 != 0
+}
+
+func (p packet) setContinuity(cc byte) {
+	p[3] = p[3]&0xf0 | cc&0x0f
+}
+
+/*
+Returns the adaptation field without its length byte, nil if there is none or it is invalid
+*/
+func (p packet) adaptation() []byte {
+	if !p.hasAdaptation() {
+		return nil
+	}
+	n := int(p[4])
+	if n == 0 || 5+n > PacketSize {
+		return nil
+	}
+	return p[5 : 5+n]
+}
+
+func (p packet) payload() []byte {
+	if !p.hasPayload() {
+		return nil
+	}
+	start := 4
+	if p.hasAdaptation() {
+		start += 1 + int(p[4])
+	}
+	if start >= PacketSize {
+		return nil
+	}
+	return p[start:]
+}
+
+func (p packet) randomAccess() bool {
+	a := p.adaptation()
+	return len(a) > 0 && a[0]&0x40 != 0
+}
+
+/*
+Returns the 6 bytes of the PCR in the adaptation field, nil if there is none
+*/
+func (p packet) pcrField() []byte {
+	a := p.adaptation()
+	if len(a) < 7 || a[0]&0x10 == 0 {
+		return nil
+	}
+	return a[1:7]
+}
+
+/*
+Returns the 33 bit base of the PCR, the 90kHz part that is comparable with PTS and DTS
+*/
+func (p packet) pcr() (int64, bool) {
+	f := p.pcrField()
+	if f == nil {
+		return 0, false
+	}
+	return int64(f[0])<<25 | int64(f[1])<<17 | int64(f[2])<<9 | int64(f[3])<<1 | int64(f[4])>>7, true
+}
+
+/*
+Replaces the base of the PCR, the extension is kept
+*/
+func (p packet) setPCR(base int64) {
+	f := p.pcrField()
+	if f == nil {
+		return
+	}
+	base = wrap(base)
+	f[0] = byte(base >> 25)
+	f[1] = byte(base >> 17)
+	f[2] = byte(base >> 9)
+	f[3] = byte(base >> 1)
+	f[4] = byte(base<<7) | f[4]&0x7f
+}
+
+// pesHeader is the part of a PES header that is needed to fix timestamps
+type pesHeader struct {
+	hasPTS bool
+	hasDTS bool
+	pts    int64
+	dts    int64
+	// dataStart is the offset of the elementary stream data in the payload
+	dataStart int
+}
+
+/*
+Parses the PES header at the start of payload, ok is false if payload doesn't start a PES
+or the header isn't complete
+*/
+func parsePESHeader(payload []byte) (h pesHeader, ok bool) {
+	if len(payload) < 9 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return h, false
+	}
+	// stream ids without the optional header, like padding streams
+	switch payload[3] {
+	case 0xbc, 0xbe, 0xbf, 0xf0, 0xf1, 0xf2, 0xf8, 0xff:
+		h.dataStart = 6
+		return h, true
+	}
+	if payload[6]&0xc0 != 0x80 {
+		return h, false
+	}
+	h.dataStart = 9 + int(payload[8])
+	if h.dataStart > len(payload) {
+		return h, false
+	}
+	flags := payload[7] >> 6
+	if flags&0x2 != 0 {
+		if h.dataStart < 14 {
+			return h, false
+		}
+		h.hasPTS = true
+		h.pts = readTimestamp(payload[9:14])
+	}
+	if flags == 0x3 {
+		if h.dataStart < 19 {
+			return h, false
+		}
+		h.hasDTS = true
+		h.dts = readTimestamp(payload[14:19])
+	}
+	return h, true
+}
+
+/*
+Replaces the PTS and DTS of the PES header at the start of payload, parsed as h
+*/
+func setPESTimestamps(payload []byte, h pesHeader, pts int64, dts int64) {
+	if h.hasPTS {
+		writeTimestamp(payload[9:14], pts)
+	}
+	if h.hasDTS {
+		writeTimestamp(payload[14:19], dts)
+	}
+}
+
+func readTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+/*
+Writes ts into the 5 bytes of a PTS or DTS, the 4 bit prefix of b[0] is kept
+*/
+func writeTimestamp(b []byte, ts int64) {
+	ts = wrap(ts)
+	b[0] = b[0]&0xf0 | byte(ts>>29)&0x0e | 1
+	b[1] = byte(ts >> 22)
+	b[2] = byte(ts>>14)&0xfe | 1
+	b[3] = byte(ts >> 7)
+	b[4] = byte(ts<<1) | 1
+}
+
+/*
+Returns ts as 33 bit value
+*/
+func wrap(ts int64) int64 {
+	ts %= timestampWrap
+	if ts < 0 {
+		ts += timestampWrap
+	}
+	return ts
+}
+
+/*
+Returns the 33 bit timestamp ts as the value closest to ref, so timestamps keep increasing after
+they wrap around
+*/
+func unwrap(ref int64, ts int64) int64 {
+	d := wrap(ts - ref)
+	if d >= timestampWrap/2 {
+		d -= timestampWrap
+	}
+	return ref + d
+}
+
+/*
+Returns the section of a PSI packet payload, nil if it is incomplete
+*/
+func psiSection(payload []byte) []byte {
+	if len(payload) < 1 {
+		return nil
+	}
+	start := 1 + int(payload[0])
+	if start+3 > len(payload) {
+		return nil
+	}
+	s := payload[start:]
+	length := int(s[1]&0x0f)<<8 | int(s[2])
+	// the section without the CRC
+	if length < 9 || 3+length > len(s) {
+		return nil
+	}
+	return s[:3+length-4]
+}
+
+/*
+Returns the PMT pids of a PAT
+*/
+func parsePAT(payload []byte) []uint16 {
+	s := psiSection(payload)
+	if len(s) < 8 || s[0] != 0x00 {
+		return nil
+	}
+	var pids []uint16
+	for i := 8; i+4 <= len(s); i += 4 {
+		program := int(s[i])<<8 | int(s[i+1])
+		if program != 0 {
+			pids = append(pids, uint16(s[i+2]&0x1f)<<8|uint16(s[i+3]))
+		}
+	}
+	return pids
+}
+
+// elementaryStream is an entry of a PMT
+type elementaryStream struct {
+	pid uint16
+	typ byte
+}
+
+func parsePMT(payload []byte) []elementaryStream {
+	s := psiSection(payload)
+	if len(s) < 12 || s[0] != 0x02 {
+		return nil
+	}
+	i := 12 + (int(s[10]&0x0f)<<8 | int(s[11]))
+	var streams []elementaryStream
+	for i+5 <= len(s) {
+		streams = append(streams, elementaryStream{pid: uint16(s[i+1]&0x1f)<<8 | uint16(s[i+2]), typ: s[i]})
+		i += 5 + (int(s[i+3]&0x0f)<<8 | int(s[i+4]))
+	}
+	return streams
+}
@@ -0,0 +1,557 @@
+package ts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	videoPID    = 0x100
+	audioPID    = 0x101
+	pmtPID      = 0x1000
+	frameTicks  = 3000 // 30fps
+	aacTicks    = 1920 // 1024 samples at 48kHz
+	keyInterval = 5
+)
+
+// tsBuilder writes transport streams like the chunks of twitch, every builder starts its
+// continuity counters at 0 like separate chunks do
+type tsBuilder struct {
+	buf bytes.Buffer
+	cc  map[uint16]byte
+}
+
+func newTSBuilder() *tsBuilder {
+	b := &tsBuilder{cc: make(map[uint16]byte)}
+	b.psi(0, []byte{0x00, 0xb0, 13, 0, 1, 0xc1, 0, 0, 0, 1, 0xe0 | pmtPID>>8, pmtPID & 0xff, 0, 0, 0, 0})
+	b.psi(pmtPID, []byte{0x02, 0xb0, 23, 0, 1, 0xc1, 0, 0, 0xe0 | videoPID>>8, videoPID & 0xff, 0xf0, 0,
+		streamTypeH264, 0xe0 | videoPID>>8, videoPID & 0xff, 0xf0, 0,
+		streamTypeAAC, 0xe0 | audioPID>>8, audioPID & 0xff, 0xf0, 0,
+		0, 0, 0, 0})
+	return b
+}
+
+func (b *tsBuilder) psi(pid uint16, section []byte) {
+	b.packets(pid, append([]byte{0}, section...), nil, 0xff)
+}
+
+/*
+Splits payload into packets, the first packet gets the adaptation field af. The last packet is
+filled with stuffing bytes, in the payload for PSI and in the adaptation field for PES
+*/
+func (b *tsBuilder) packets(pid uint16, payload []byte, af []byte, payloadStuffing byte) {
+	first := true
+	for len(payload) > 0 {
+		var adapt []byte
+		if first && af != nil {
+			adapt = append(adapt, af...)
+		}
+		space := 184
+		if adapt != nil {
+			space -= 1 + len(adapt)
+		}
+		n := len(payload)
+		if n > space {
+			n = space
+		}
+		data := payload[:n]
+		payload = payload[n:]
+		if stuff := space - n; stuff > 0 {
+			if payloadStuffing != 0 {
+				data = append(append([]byte(nil), data...), bytes.Repeat([]byte{payloadStuffing}, stuff)...)
+			} else if adapt != nil {
+				adapt = append(adapt, bytes.Repeat([]byte{0xff}, stuff)...)
+			} else if stuff == 1 {
+				adapt = []byte{}
+			} else {
+				adapt = append([]byte{0}, bytes.Repeat([]byte{0xff}, stuff-2)...)
+			}
+		}
+
+		header := []byte{syncByte, byte(pid >> 8), byte(pid), 0x10 | b.cc[pid]}
+		if first {
+			header[1] |= 0x40
+		}
+		if adapt != nil {
+			header[3] |= 0x20
+			header = append(header, byte(len(adapt)))
+			header = append(header, adapt...)
+		}
+		b.buf.Write(header)
+		b.buf.Write(data)
+		b.cc[pid] = (b.cc[pid] + 1) & 0x0f
+		first = false
+	}
+}
+
+func (b *tsBuilder) pes(pid uint16, streamID byte, pts int64, dts int64, data []byte, key bool) {
+	header := []byte{0, 0, 1, streamID, 0, 0, 0x80, 0x80, 5, 0x21, 0, 0, 0, 0}
+	writeTimestamp(header[9:14], pts)
+	if pts != dts {
+		header[7], header[8], header[9] = 0xc0, 10, 0x31
+		writeTimestamp(header[9:14], pts)
+		header = append(header, 0x11, 0, 0, 0, 0)
+		writeTimestamp(header[14:19], dts)
+	}
+	if streamID != 0xe0 {
+		length := len(header) - 6 + len(data)
+		header[4], header[5] = byte(length>>8), byte(length)
+	}
+
+	var af []byte
+	if pid == videoPID {
+		// a PCR 100ms before the DTS
+		pcr := wrap(dts - 9000)
+		af = []byte{0x10, byte(pcr >> 25), byte(pcr >> 17), byte(pcr >> 9), byte(pcr >> 1), byte(pcr<<7) | 0x7e, 0}
+		if key {
+			af[0] |= 0x40
+		}
+	}
+	b.packets(pid, append(header, data...), af, 0)
+}
+
+// bitWriter writes the exp-Golomb coded fields of a SPS
+type bitWriter struct {
+	bits []byte
+}
+
+func (w *bitWriter) u(n int, v uint) {
+	for i := n - 1; i >= 0; i-- {
+		w.bits = append(w.bits, byte(v>>uint(i)&1))
+	}
+}
+
+func (w *bitWriter) ue(v uint) {
+	n := 0
+	for (v+1)>>uint(n) > 1 {
+		n++
+	}
+	w.u(n, 0)
+	w.u(n+1, v+1)
+}
+
+func (w *bitWriter) bytes() []byte {
+	bits := append(w.bits, 1)
+	for len(bits)%8 != 0 {
+		bits = append(bits, 0)
+	}
+	out := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		out[i/8] |= bit << uint(7-i%8)
+	}
+	return out
+}
+
+/*
+A high profile SPS of a 1920x1080 video, coded as 1920x1088 with cropping
+*/
+func testSPS() []byte {
+	w := &bitWriter{}
+	w.u(8, 100) // profile
+	w.u(8, 0)
+	w.u(8, 42) // level
+	w.ue(0)    // sps id
+	w.ue(1)    // chroma_format_idc
+	w.ue(0)
+	w.ue(0)
+	w.u(1, 0)
+	w.u(1, 0) // no scaling matrix
+	w.ue(0)   // log2_max_frame_num_minus4
+	w.ue(2)   // pic_order_cnt_type
+	w.ue(1)   // max_num_ref_frames
+	w.u(1, 0)
+	w.ue(1920/16 - 1)
+	w.ue(1088/16 - 1)
+	w.u(1, 1) // frame_mbs_only_flag
+	w.u(1, 1)
+	w.u(1, 1) // frame_cropping_flag
+	w.ue(0)
+	w.ue(0)
+	w.ue(0)
+	w.ue(4)
+	w.u(1, 0) // no vui
+
+	// emulation prevention
+	var out []byte
+	zeros := 0
+	for _, c := range append([]byte{0x67}, w.bytes()...) {
+		if zeros >= 2 && c <= 3 {
+			out = append(out, 3)
+			zeros = 0
+		}
+		out = append(out, c)
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
+
+func videoFrame(i int, key bool) []byte {
+	frame := []byte{0, 0, 0, 1, 0x09, 0xf0}
+	if key {
+		frame = append(frame, 0, 0, 0, 1)
+		frame = append(frame, testSPS()...)
+		frame = append(frame, 0, 0, 0, 1, 0x68, 0xce, 0x38, 0x80)
+		frame = append(frame, 0, 0, 1, 0x65, 0x88, byte(i))
+	} else {
+		frame = append(frame, 0, 0, 1, 0x41, 0x9a, byte(i))
+	}
+	return append(frame, bytes.Repeat([]byte{0xab}, 300)...)
+}
+
+func testADTSFrame(i int) []byte {
+	data := bytes.Repeat([]byte{byte(i)}, 20)
+	length := 7 + len(data)
+	// AAC LC, 48kHz, stereo
+	header := []byte{0xff, 0xf1, 0x4c, 0x80 | byte(length>>11), byte(length >> 3), byte(length<<5) | 0x1f, 0xfc}
+	return append(header, data...)
+}
+
+/*
+Writes a chunk with frames video frames starting at start and the audio of the same time, two AAC
+frames per PES. Every keyInterval-th frame is a keyframe, the PTS is one frame after the DTS
+*/
+func writeChunk(t *testing.T, dir string, name string, start int64, frames int) string {
+	b := newTSBuilder()
+	audio := 0
+	for i := 0; i < frames; i++ {
+		pts := start + int64(i)*frameTicks
+		b.pes(videoPID, 0xe0, pts, pts-frameTicks, videoFrame(i, i%keyInterval == 0), i%keyInterval == 0)
+		for ; start+int64(audio)*aacTicks < pts+frameTicks; audio += 2 {
+			audioPTS := start + int64(audio)*aacTicks
+			b.pes(audioPID, 0xc0, audioPTS, audioPTS, append(testADTSFrame(audio), testADTSFrame(audio+1)...), false)
+		}
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, b.buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+/*
+Writes three chunks of 10 frames, the second continues the first, the third comes after a gap of
+10 seconds and wraps around the 33 bit timestamps
+*/
+func testChunks(t *testing.T) ([]string, func()) {
+	dir, err := ioutil.TempDir("", "concat_ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := int64(900000)
+	inputs := []string{
+		writeChunk(t, dir, "0.ts", start, 10),
+		writeChunk(t, dir, "1.ts", start+10*frameTicks, 10),
+		writeChunk(t, dir, "2.ts", timestampWrap-4*frameTicks, 10),
+	}
+	return inputs, func() { os.RemoveAll(dir) }
+}
+
+type parsedPES struct {
+	pid      uint16
+	pts, dts int64
+}
+
+/*
+Reads the PES timestamps of a transport stream and checks its continuity counters and PCRs
+*/
+func parseOutput(t *testing.T, data []byte) []parsedPES {
+	if len(data)%PacketSize != 0 {
+		t.Fatalf("output size %d isn't a multiple of the packet size", len(data))
+	}
+	var out []parsedPES
+	continuity := make(map[uint16]byte)
+	lastPCR := int64(-1)
+	for i := 0; i < len(data); i += PacketSize {
+		p := packet(data[i : i+PacketSize])
+		pid := p.pid()
+		cc := p[3] & 0x0f
+		if last, ok := continuity[pid]; ok && p.hasPayload() && cc != (last+1)&0x0f {
+			t.Errorf("packet %d of pid %d has continuity counter %d after %d", i/PacketSize, pid, cc, last)
+		}
+		continuity[pid] = cc
+
+		if pcr, ok := p.pcr(); ok {
+			if pcr <= lastPCR {
+				t.Errorf("PCR %d after %d", pcr, lastPCR)
+			}
+			lastPCR = pcr
+		}
+		if pid != videoPID && pid != audioPID || !p.unitStart() {
+			continue
+		}
+		h, ok := parsePESHeader(p.payload())
+		if !ok {
+			t.Fatalf("packet %d doesn't start a PES", i/PacketSize)
+		}
+		dts := h.pts
+		if h.hasDTS {
+			dts = h.dts
+		}
+		out = append(out, parsedPES{pid: pid, pts: h.pts, dts: dts})
+	}
+	return out
+}
+
+func TestConcat(t *testing.T) {
+	inputs, cleanup := testChunks(t)
+	defer cleanup()
+
+	var buf bytes.Buffer
+	if err := Concat(&buf, inputs, Cut{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var video, audio []parsedPES
+	for _, p := range parseOutput(t, buf.Bytes()) {
+		if p.pid == videoPID {
+			video = append(video, p)
+		} else {
+			audio = append(audio, p)
+		}
+	}
+	if len(video) != 30 {
+		t.Fatalf("got %d video frames, want 30", len(video))
+	}
+	if video[0].dts != outputStart || video[0].pts != outputStart+frameTicks {
+		t.Errorf("output starts at pts %d dts %d", video[0].pts, video[0].dts)
+	}
+	for i := 1; i < len(video); i++ {
+		if video[i].pts-video[i-1].pts != frameTicks || video[i].dts-video[i-1].dts != frameTicks {
+			t.Errorf("video frame %d at %d follows %d", i, video[i].pts, video[i-1].pts)
+		}
+	}
+	for i := 1; i < len(audio); i++ {
+		if d := audio[i].pts - audio[i-1].pts; d <= 0 || d > 2*2*aacTicks {
+			t.Errorf("audio %d at %d follows %d", i, audio[i].pts, audio[i-1].pts)
+		}
+	}
+	if last := audio[len(audio)-1].pts; last < video[len(video)-1].pts-frameTicks || last > video[len(video)-1].pts+2*aacTicks {
+		t.Errorf("audio ends at %d, video at %d", last, video[len(video)-1].pts)
+	}
+}
+
+func TestConcatCut(t *testing.T) {
+	inputs, cleanup := testChunks(t)
+	defer cleanup()
+
+	// the keyframe before 200ms is frame 5, 500ms is frame 15
+	var buf bytes.Buffer
+	if err := Concat(&buf, inputs, Cut{Start: 200 * time.Millisecond, Duration: 300 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	var video, audio []parsedPES
+	for _, p := range parseOutput(t, buf.Bytes()) {
+		if p.pid == videoPID {
+			video = append(video, p)
+		} else {
+			audio = append(audio, p)
+		}
+	}
+	if len(video) != 10 || video[0].dts != outputStart {
+		t.Fatalf("got %d video frames starting at %+v, want 10 starting at %d", len(video), video, outputStart)
+	}
+	if len(audio) == 0 || audio[0].pts < video[0].pts || audio[len(audio)-1].pts >= video[0].pts+15*frameTicks {
+		t.Errorf("audio %+v isn't between the video frames", audio)
+	}
+
+	if err := Concat(&buf, inputs, Cut{Start: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// box is a parsed MP4 box
+type box struct {
+	typ      string
+	data     []byte
+	children []box
+}
+
+var containerBoxes = map[string]bool{"moov": true, "trak": true, "edts": true, "mdia": true, "minf": true, "dinf": true, "stbl": true}
+
+func parseBoxes(t *testing.T, data []byte) []box {
+	var boxes []box
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatalf("incomplete box header %x", data)
+		}
+		size := uint64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		header := uint64(8)
+		if size == 1 {
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			t.Fatalf("box %s has size %d, %d bytes left", typ, size, len(data))
+		}
+		b := box{typ: typ, data: data[header:size]}
+		if containerBoxes[typ] {
+			b.children = parseBoxes(t, b.data)
+		}
+		boxes = append(boxes, b)
+		data = data[size:]
+	}
+	return boxes
+}
+
+func findBox(boxes []box, path ...string) *box {
+	for i := range boxes {
+		if boxes[i].typ != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return &boxes[i]
+		}
+		if b := findBox(boxes[i].children, path[1:]...); b != nil {
+			return b
+		}
+	}
+	return nil
+}
+
+func TestRemuxMP4(t *testing.T) {
+	inputs, cleanup := testChunks(t)
+	defer cleanup()
+
+	f, err := ioutil.TempFile("", "concat_ts_*.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if err := RemuxMP4(f, inputs, Cut{}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boxes := parseBoxes(t, data)
+	if len(boxes) != 3 || boxes[0].typ != "ftyp" || boxes[1].typ != "mdat" || boxes[2].typ != "moov" {
+		t.Fatalf("unexpected top level boxes %v", boxes)
+	}
+	traks := findBox(boxes, "moov").children[1:]
+	if len(traks) != 2 {
+		t.Fatalf("got %d tracks, want 2", len(traks))
+	}
+
+	video := traks[0].children
+	tkhd := findBox(video, "tkhd").data
+	if w, h := binary.BigEndian.Uint32(tkhd[76:])>>16, binary.BigEndian.Uint32(tkhd[80:])>>16; w != 1920 || h != 1080 {
+		t.Errorf("video is %dx%d, want 1920x1080", w, h)
+	}
+	if n := binary.BigEndian.Uint32(findBox(video, "mdia", "minf", "stbl", "stsz").data[8:]); n != 30 {
+		t.Errorf("video has %d samples, want 30", n)
+	}
+	stss := findBox(video, "mdia", "minf", "stbl", "stss").data
+	if n := binary.BigEndian.Uint32(stss[4:]); n != 6 || binary.BigEndian.Uint32(stss[12:]) != 6 {
+		t.Errorf("unexpected keyframes %x", stss)
+	}
+	if elst := findBox(video, "edts", "elst").data; binary.BigEndian.Uint32(elst[12:]) != frameTicks {
+		t.Errorf("video edit list doesn't skip the composition offset: %x", elst)
+	}
+	if findBox(video, "mdia", "minf", "stbl", "stsd") == nil || !bytes.Contains(findBox(video, "mdia", "minf", "stbl", "stsd").data, testSPS()) {
+		t.Errorf("avcC doesn't contain the sps")
+	}
+
+	// the first sample is the IDR slice without parameter sets and delimiter
+	co64 := findBox(video, "mdia", "minf", "stbl", "co64").data
+	offset := binary.BigEndian.Uint64(co64[8:])
+	if nal := data[offset+4 : offset+7]; !bytes.Equal(nal, []byte{0x65, 0x88, 0}) || binary.BigEndian.Uint32(data[offset:]) != 3+300 {
+		t.Errorf("first sample starts with %x", data[offset:offset+8])
+	}
+
+	audio := traks[1].children
+	if n := binary.BigEndian.Uint32(findBox(audio, "mdia", "minf", "stbl", "stsz").data[8:]); n < 40 {
+		t.Errorf("audio has %d samples", n)
+	}
+	if !bytes.Contains(findBox(audio, "mdia", "minf", "stbl", "stsd").data, []byte{0x05, 2, 0x11, 0x90}) {
+		t.Errorf("esds doesn't contain the AudioSpecificConfig of AAC LC 48kHz stereo")
+	}
+}
+
+func TestSPSResolution(t *testing.T) {
+	w, h, err := spsResolution(testSPS())
+	if err != nil || w != 1920 || h != 1080 {
+		t.Errorf("got %dx%d, %v", w, h, err)
+	}
+	if _, _, err := spsResolution([]byte{0x67, 0x42}); err == nil {
+		t.Errorf("expected error for short sps")
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	tests := []struct{ ref, ts, want int64 }{
+		{1000, 4000, 4000},
+		{timestampWrap - 1000, 2000, timestampWrap + 2000},
+		{timestampWrap + 2000, timestampWrap - 1000, timestampWrap - 1000},
+		{5000, timestampWrap - 1000, -1000},
+	}
+	for _, tt := range tests {
+		if got := unwrap(tt.ref, tt.ts); got != tt.want {
+			t.Errorf("unwrap(%d, %d) = %d, want %d", tt.ref, tt.ts, got, tt.want)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	inputs, cleanup := testChunks(t)
+	defer cleanup()
+	dir := filepath.Dir(inputs[0])
+
+	var buf bytes.Buffer
+	if err := Concat(&buf, inputs, Cut{}); err != nil {
+		t.Fatal(err)
+	}
+	tsPath := filepath.Join(dir, "out.ts")
+	if err := ioutil.WriteFile(tsPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mp4Path := filepath.Join(dir, "out.mp4")
+	f, err := os.Create(mp4Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RemuxMP4(f, inputs, Cut{}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	want := []Stream{
+		{Type: "video", Codec: "h264", Width: 1920, Height: 1080},
+		{Type: "audio", Codec: "aac"},
+	}
+	for _, path := range []string{tsPath, mp4Path} {
+		info, err := Probe(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		// 30 frames at 30fps, the gap before the third chunk is closed
+		if info.Duration < 950*time.Millisecond || info.Duration > 1100*time.Millisecond {
+			t.Errorf("%s: duration %s, want 1s", path, info.Duration)
+		}
+		if len(info.Streams) != len(want) {
+			t.Fatalf("%s: streams %+v, want %+v", path, info.Streams, want)
+		}
+		for i := range want {
+			if info.Streams[i] != want[i] {
+				t.Errorf("%s: stream %d is %+v, want %+v", path, i, info.Streams[i], want[i])
+			}
+		}
+	}
+
+	if _, err := Probe(inputs[0] + ".missing"); err == nil {
+		t.Error("probing a missing file succeeded")
+	}
+}
@@ -0,0 +1,257 @@
+package ttf
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Face is a Font at a size in pixels. It caches the rendered glyphs and isn't safe for
+// concurrent use.
+type Face struct {
+	font   *Font
+	scale  float64
+	glyphs map[uint16]*glyph
+}
+
+// glyph is a rendered glyph, its mask is placed relative to the pen on the baseline
+type glyph struct {
+	mask    *image.Alpha
+	offset  image.Point
+	advance float64
+}
+
+// NewFace returns a Face of f where the em is size pixels high.
+func NewFace(f *Font, size float64) *Face {
+	return &Face{font: f, scale: size / float64(f.unitsPerEm), glyphs: make(map[uint16]*glyph)}
+}
+
+// Ascent is the height above the baseline in pixels.
+func (f *Face) Ascent() float64 {
+	return float64(f.font.ascent) * f.scale
+}
+
+// Descent is the depth below the baseline in pixels, a positive number.
+func (f *Face) Descent() float64 {
+	return -float64(f.font.descent) * f.scale
+}
+
+// LineHeight is the distance between the baselines of two lines in pixels.
+func (f *Face) LineHeight() float64 {
+	return float64(f.font.ascent-f.font.descent+f.font.lineGap) * f.scale
+}
+
+// Advance returns how far the pen moves for r in pixels.
+func (f *Face) Advance(r rune) float64 {
+	return float64(f.font.advance(f.font.Index(r))) * f.scale
+}
+
+// Measure returns the width of s in pixels.
+func (f *Face) Measure(s string) float64 {
+	width := 0.0
+	for _, r := range s {
+		width += f.Advance(r)
+	}
+	return width
+}
+
+// Draw draws s in color c with the pen starting at x on the baseline y, and returns the x after s.
+// Glyphs that can't be read are left out.
+func (f *Face) Draw(dst draw.Image, c color.Color, x float64, y float64, s string) float64 {
+	src := image.NewUniform(c)
+	for _, r := range s {
+		g := f.glyph(f.font.Index(r))
+		if g.mask != nil {
+			at := image.Pt(int(math.Round(x)), int(math.Round(y))).Add(g.offset)
+			draw.DrawMask(dst, g.mask.Bounds().Add(at), src, image.Point{}, g.mask, image.Point{}, draw.Over)
+		}
+		x += g.advance
+	}
+	return x
+}
+
+func (f *Face) glyph(index uint16) *glyph {
+	if g, ok := f.glyphs[index]; ok {
+		return g
+	}
+	g := &glyph{advance: float64(f.font.advance(index)) * f.scale}
+	if contours, err := f.font.contours(index, 0); err == nil && len(contours) > 0 {
+		g.mask, g.offset = f.rasterize(contours)
+	}
+	f.glyphs[index] = g
+	return g
+}
+
+/*
+Fills the contours with the nonzero rule. Returns the coverage and its position relative to the pen
+*/
+func (f *Face) rasterize(contours [][]point) (*image.Alpha, image.Point) {
+	// font units grow upwards, pixels downwards
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, contour := range contours {
+		for i, p := range contour {
+			p.x, p.y = p.x*f.scale, -p.y*f.scale
+			contour[i] = p
+			minX, maxX = math.Min(minX, p.x), math.Max(maxX, p.x)
+			minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+		}
+	}
+	offset := image.Pt(int(math.Floor(minX)), int(math.Floor(minY)))
+	width := int(math.Ceil(maxX)) - offset.X
+	height := int(math.Ceil(maxY)) - offset.Y
+	if width <= 0 || height <= 0 {
+		return nil, image.Point{}
+	}
+
+	r := newRasterizer(width, height)
+	for _, contour := range contours {
+		for i, p := range contour {
+			contour[i].x, contour[i].y = p.x-float64(offset.X), p.y-float64(offset.Y)
+		}
+		r.contour(contour)
+	}
+	return r.mask(), offset
+}
+
+// rasterizer accumulates the signed area the lines cover in every pixel, the sum of a row up to a
+// pixel is its coverage
+type rasterizer struct {
+	width, height int
+	acc           []float64
+}
+
+func newRasterizer(width, height int) *rasterizer {
+	// the extra column takes the area right of the last pixel
+	return &rasterizer{width: width, height: height, acc: make([]float64, (width+1)*height+1)}
+}
+
+/*
+Adds a closed contour of on and off curve points, off curve points are the controls of quadratic
+curves and two of them in a row have an implied on curve point between them
+*/
+func (r *rasterizer) contour(points []point) {
+	if len(points) < 2 {
+		return
+	}
+	mid := func(a, b point) point { return point{x: (a.x + b.x) / 2, y: (a.y + b.y) / 2, on: true} }
+
+	// start at an on curve point, or between two off curve points
+	start := points[0]
+	if !start.on {
+		last := points[len(points)-1]
+		if last.on {
+			start = last
+		} else {
+			start = mid(last, points[0])
+		}
+	}
+
+	pen := start
+	var control *point
+	for i := 0; i <= len(points); i++ {
+		p := start
+		if i < len(points) {
+			p = points[i]
+		}
+		switch {
+		case p.on && control == nil:
+			r.line(pen, p)
+			pen = p
+		case p.on:
+			r.quad(pen, *control, p)
+			pen, control = p, nil
+		case control == nil:
+			control = &points[i]
+		default:
+			m := mid(*control, p)
+			r.quad(pen, *control, m)
+			pen, control = m, &points[i]
+		}
+	}
+}
+
+/*
+Splits the quadratic curve from a to c with the control b into lines, more of them the more it bends
+*/
+func (r *rasterizer) quad(a, b, c point) {
+	dx, dy := a.x-2*b.x+c.x, a.y-2*b.y+c.y
+	n := 1 + int(math.Sqrt(math.Sqrt(3*(dx*dx+dy*dy))))
+	prev := a
+	for i := 1; i <= n; i++ {
+		t := float64(i) / float64(n)
+		u := 1 - t
+		p := point{x: u*u*a.x + 2*u*t*b.x + t*t*c.x, y: u*u*a.y + 2*u*t*b.y + t*t*c.y}
+		r.line(prev, p)
+		prev = p
+	}
+}
+
+/*
+Adds the area left of the line to the rows it crosses, positive for lines going down
+*/
+func (r *rasterizer) line(a, b point) {
+	if a.y == b.y {
+		return
+	}
+	dir := 1.0
+	if a.y > b.y {
+		dir, a, b = -1, b, a
+	}
+	dxdy := (b.x - a.x) / (b.y - a.y)
+	x := a.x
+	if a.y < 0 {
+		x -= a.y * dxdy
+	}
+	clampX := func(x float64) float64 { return math.Max(0, math.Min(float64(r.width), x)) }
+
+	for y := int(math.Max(0, a.y)); y < r.height && float64(y) < b.y; y++ {
+		row := y * (r.width + 1)
+		dy := math.Min(float64(y+1), b.y) - math.Max(float64(y), a.y)
+		next := x + dxdy*dy
+		d := dy * dir
+
+		x0, x1 := clampX(math.Min(x, next)), clampX(math.Max(x, next))
+		x0floor, x1ceil := math.Floor(x0), math.Ceil(x1)
+		x0i, x1i := int(x0floor), int(x1ceil)
+		if x1i <= x0i+1 {
+			// the line stays in one pixel, the area right of its middle goes into the next pixel
+			xm := (x0+x1)/2 - x0floor
+			r.acc[row+x0i] += d - d*xm
+			r.acc[row+x0i+1] += d * xm
+		} else {
+			s := 1 / (x1 - x0)
+			x0f := x0 - x0floor
+			a0 := 0.5 * s * (1 - x0f) * (1 - x0f)
+			x1f := x1 - x1ceil + 1
+			am := 0.5 * s * x1f * x1f
+			r.acc[row+x0i] += d * a0
+			if x1i == x0i+2 {
+				r.acc[row+x0i+1] += d * (1 - a0 - am)
+			} else {
+				a1 := s * (1.5 - x0f)
+				r.acc[row+x0i+1] += d * (a1 - a0)
+				for xi := x0i + 2; xi < x1i-1; xi++ {
+					r.acc[row+xi] += d * s
+				}
+				a2 := a1 + float64(x1i-x0i-3)*s
+				r.acc[row+x1i-1] += d * (1 - a2 - am)
+			}
+			r.acc[row+x1i] += d * am
+		}
+		x = next
+	}
+}
+
+func (r *rasterizer) mask() *image.Alpha {
+	mask := image.NewAlpha(image.Rect(0, 0, r.width, r.height))
+	for y := 0; y < r.height; y++ {
+		sum := 0.0
+		for x := 0; x < r.width; x++ {
+			sum += r.acc[y*(r.width+1)+x]
+			mask.Pix[y*mask.Stride+x] = uint8(math.Min(1, math.Abs(sum))*255 + 0.5)
+		}
+	}
+	return mask
+}
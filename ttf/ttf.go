@@ -0,0 +1,458 @@
+// Package ttf reads TrueType fonts and draws text with them, without external tools.
+//
+// It covers what drawing chat messages needs: glyph outlines of the glyf table, including
+// composite glyphs, advance widths and the vertical metrics. Hinting, kerning and the shaping
+// of complex scripts are left out, CFF outlines of OpenType fonts aren't supported.
+package ttf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// Font is a parsed TrueType font. It is safe for concurrent use, unlike its Faces.
+type Font struct {
+	unitsPerEm int
+	// ascent and descent in font units, descent is negative
+	ascent, descent, lineGap int
+
+	numGlyphs   int
+	numHMetrics int
+	longLoca    bool
+	hmtx        []byte
+	loca        []byte
+	glyf        []byte
+
+	// cmap is the unicode subtable of the cmap table in format 4 or 12
+	cmap       []byte
+	cmapFormat int
+}
+
+// Load reads the font file at path, a .ttf or the first font of a .ttc.
+func Load(path string) (*Font, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return f, nil
+}
+
+// Parse parses a TrueType font or the first font of a TrueType collection.
+func Parse(data []byte) (*Font, error) {
+	if len(data) < 12 {
+		return nil, errors.New("ttf: font is too short")
+	}
+	offset := 0
+	if string(data[:4]) == "ttcf" {
+		if len(data) < 16 {
+			return nil, errors.New("ttf: collection is too short")
+		}
+		offset = int(binary.BigEndian.Uint32(data[12:]))
+	}
+	if offset+12 > len(data) {
+		return nil, errors.New("ttf: font is too short")
+	}
+	switch string(data[offset : offset+4]) {
+	case "\x00\x01\x00\x00", "true":
+	case "OTTO":
+		return nil, errors.New("ttf: CFF outlines aren't supported, use a TrueType font")
+	default:
+		return nil, errors.New("ttf: not a TrueType font")
+	}
+
+	tables := make(map[string][]byte)
+	numTables := int(binary.BigEndian.Uint16(data[offset+4:]))
+	for i := 0; i < numTables; i++ {
+		record := offset + 12 + 16*i
+		if record+16 > len(data) {
+			return nil, errors.New("ttf: table directory is too short")
+		}
+		start := int(binary.BigEndian.Uint32(data[record+8:]))
+		length := int(binary.BigEndian.Uint32(data[record+12:]))
+		if start < 0 || length < 0 || start+length > len(data) {
+			return nil, fmt.Errorf("ttf: table %q is out of bounds", data[record:record+4])
+		}
+		tables[string(data[record:record+4])] = data[start : start+length]
+	}
+	for _, tag := range []string{"head", "hhea", "maxp", "hmtx", "loca", "glyf", "cmap"} {
+		if tables[tag] == nil {
+			return nil, fmt.Errorf("ttf: no %s table", tag)
+		}
+	}
+
+	f := &Font{hmtx: tables["hmtx"], loca: tables["loca"], glyf: tables["glyf"]}
+	head, hhea, maxp := tables["head"], tables["hhea"], tables["maxp"]
+	if len(head) < 54 || len(hhea) < 36 || len(maxp) < 6 {
+		return nil, errors.New("ttf: head, hhea or maxp table is too short")
+	}
+	f.unitsPerEm = int(binary.BigEndian.Uint16(head[18:]))
+	f.longLoca = binary.BigEndian.Uint16(head[50:]) != 0
+	f.ascent = int(int16(binary.BigEndian.Uint16(hhea[4:])))
+	f.descent = int(int16(binary.BigEndian.Uint16(hhea[6:])))
+	f.lineGap = int(int16(binary.BigEndian.Uint16(hhea[8:])))
+	f.numHMetrics = int(binary.BigEndian.Uint16(hhea[34:]))
+	f.numGlyphs = int(binary.BigEndian.Uint16(maxp[4:]))
+	if f.unitsPerEm == 0 || f.numHMetrics == 0 || len(f.hmtx) < 4*f.numHMetrics {
+		return nil, errors.New("ttf: invalid metrics")
+	}
+
+	if err := f.parseCmap(tables["cmap"]); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+/*
+Picks the unicode subtable of the cmap, full unicode tables are preferred over the ones of the BMP
+*/
+func (f *Font) parseCmap(cmap []byte) error {
+	if len(cmap) < 4 {
+		return errors.New("ttf: cmap table is too short")
+	}
+	// the ranks of the platform and encoding ids, higher is better
+	rank := func(platform, encoding uint16) int {
+		switch {
+		case platform == 3 && encoding == 10, platform == 0 && (encoding == 4 || encoding == 6):
+			return 2
+		case platform == 3 && encoding == 1, platform == 0:
+			return 1
+		}
+		return 0
+	}
+	best := 0
+	numTables := int(binary.BigEndian.Uint16(cmap[2:]))
+	for i := 0; i < numTables; i++ {
+		record := 4 + 8*i
+		if record+8 > len(cmap) {
+			break
+		}
+		r := rank(binary.BigEndian.Uint16(cmap[record:]), binary.BigEndian.Uint16(cmap[record+2:]))
+		offset := int(binary.BigEndian.Uint32(cmap[record+4:]))
+		if r <= best || offset+4 > len(cmap) {
+			continue
+		}
+		format := int(binary.BigEndian.Uint16(cmap[offset:]))
+		if format != 4 && format != 12 {
+			continue
+		}
+		best, f.cmap, f.cmapFormat = r, cmap[offset:], format
+	}
+	if f.cmap == nil {
+		return errors.New("ttf: no unicode cmap in format 4 or 12")
+	}
+	return nil
+}
+
+// Index returns the glyph of r, 0 if the font has none.
+func (f *Font) Index(r rune) uint16 {
+	c := uint32(r)
+	switch f.cmapFormat {
+	case 4:
+		if c > 0xffff || len(f.cmap) < 14 {
+			return 0
+		}
+		segCount := int(binary.BigEndian.Uint16(f.cmap[6:])) / 2
+		ends := 14
+		starts := ends + 2*segCount + 2
+		deltas := starts + 2*segCount
+		rangeOffsets := deltas + 2*segCount
+		if rangeOffsets+2*segCount > len(f.cmap) {
+			return 0
+		}
+		i := sort.Search(segCount, func(i int) bool {
+			return uint32(binary.BigEndian.Uint16(f.cmap[ends+2*i:])) >= c
+		})
+		if i == segCount || uint32(binary.BigEndian.Uint16(f.cmap[starts+2*i:])) > c {
+			return 0
+		}
+		start := uint32(binary.BigEndian.Uint16(f.cmap[starts+2*i:]))
+		delta := binary.BigEndian.Uint16(f.cmap[deltas+2*i:])
+		rangeOffset := int(binary.BigEndian.Uint16(f.cmap[rangeOffsets+2*i:]))
+		if rangeOffset == 0 {
+			return uint16(c) + delta
+		}
+		// the offset is relative to its own position in the table
+		addr := rangeOffsets + 2*i + rangeOffset + 2*int(c-start)
+		if addr+2 > len(f.cmap) {
+			return 0
+		}
+		if g := binary.BigEndian.Uint16(f.cmap[addr:]); g != 0 {
+			return g + delta
+		}
+	case 12:
+		if len(f.cmap) < 16 {
+			return 0
+		}
+		groups := int(binary.BigEndian.Uint32(f.cmap[12:]))
+		if 16+12*groups > len(f.cmap) {
+			return 0
+		}
+		i := sort.Search(groups, func(i int) bool {
+			return binary.BigEndian.Uint32(f.cmap[16+12*i+4:]) >= c
+		})
+		if i == groups {
+			return 0
+		}
+		group := f.cmap[16+12*i:]
+		start := binary.BigEndian.Uint32(group)
+		if start > c {
+			return 0
+		}
+		return uint16(binary.BigEndian.Uint32(group[8:]) + c - start)
+	}
+	return 0
+}
+
+/*
+Returns the advance width of glyph g in font units
+*/
+func (f *Font) advance(g uint16) int {
+	i := int(g)
+	if i >= f.numHMetrics {
+		// the glyphs after the last long metric share its advance
+		i = f.numHMetrics - 1
+	}
+	return int(binary.BigEndian.Uint16(f.hmtx[4*i:]))
+}
+
+// point is a point of a glyph outline in font units, y grows upwards
+type point struct {
+	x, y float64
+	on   bool
+}
+
+/*
+Returns the data of glyph g in the glyf table, nil for glyphs without outline like the space
+*/
+func (f *Font) glyphData(g uint16) ([]byte, error) {
+	if int(g) >= f.numGlyphs {
+		return nil, fmt.Errorf("ttf: glyph %d out of range", g)
+	}
+	var start, end int
+	if f.longLoca {
+		if 4*int(g)+8 > len(f.loca) {
+			return nil, errors.New("ttf: loca table is too short")
+		}
+		start = int(binary.BigEndian.Uint32(f.loca[4*int(g):]))
+		end = int(binary.BigEndian.Uint32(f.loca[4*int(g)+4:]))
+	} else {
+		if 2*int(g)+4 > len(f.loca) {
+			return nil, errors.New("ttf: loca table is too short")
+		}
+		start = 2 * int(binary.BigEndian.Uint16(f.loca[2*int(g):]))
+		end = 2 * int(binary.BigEndian.Uint16(f.loca[2*int(g)+2:]))
+	}
+	if start == end {
+		return nil, nil
+	}
+	if start > end || end > len(f.glyf) || end-start < 10 {
+		return nil, fmt.Errorf("ttf: glyph %d is out of bounds", g)
+	}
+	return f.glyf[start:end], nil
+}
+
+/*
+Returns the contours of glyph g. Composite glyphs are resolved up to a depth of 8
+*/
+func (f *Font) contours(g uint16, depth int) ([][]point, error) {
+	if depth > 8 {
+		return nil, errors.New("ttf: composite glyphs are nested too deep")
+	}
+	data, err := f.glyphData(g)
+	if data == nil || err != nil {
+		return nil, err
+	}
+	numContours := int(int16(binary.BigEndian.Uint16(data)))
+	if numContours < 0 {
+		return f.compositeContours(data[10:], depth)
+	}
+	return simpleContours(data[10:], numContours)
+}
+
+// flags of the points of simple glyphs
+const (
+	flagOnCurve         = 0x01
+	flagXShort          = 0x02
+	flagYShort          = 0x04
+	flagRepeat          = 0x08
+	flagXSameOrPositive = 0x10
+	flagYSameOrPositive = 0x20
+)
+
+func simpleContours(data []byte, numContours int) ([][]point, error) {
+	errShort := errors.New("ttf: glyph is too short")
+	if len(data) < 2*numContours+2 {
+		return nil, errShort
+	}
+	ends := make([]int, numContours)
+	for i := range ends {
+		ends[i] = int(binary.BigEndian.Uint16(data[2*i:]))
+	}
+	numPoints := 0
+	if numContours > 0 {
+		numPoints = ends[numContours-1] + 1
+	}
+	pos := 2 * numContours
+	pos += 2 + int(binary.BigEndian.Uint16(data[pos:])) // the instructions are skipped
+
+	flags := make([]byte, 0, numPoints)
+	for len(flags) < numPoints {
+		if pos >= len(data) {
+			return nil, errShort
+		}
+		flag := data[pos]
+		pos++
+		flags = append(flags, flag)
+		if flag&flagRepeat != 0 {
+			if pos >= len(data) {
+				return nil, errShort
+			}
+			for n := data[pos]; n > 0 && len(flags) < numPoints; n-- {
+				flags = append(flags, flag)
+			}
+			pos++
+		}
+	}
+
+	// the coordinates are deltas to the previous point, short ones have their sign in the flags
+	coords := func(short, same byte) ([]float64, error) {
+		values := make([]float64, numPoints)
+		v := 0
+		for i, flag := range flags {
+			switch {
+			case flag&short != 0:
+				if pos >= len(data) {
+					return nil, errShort
+				}
+				if flag&same != 0 {
+					v += int(data[pos])
+				} else {
+					v -= int(data[pos])
+				}
+				pos++
+			case flag&same == 0:
+				if pos+2 > len(data) {
+					return nil, errShort
+				}
+				v += int(int16(binary.BigEndian.Uint16(data[pos:])))
+				pos += 2
+			}
+			values[i] = float64(v)
+		}
+		return values, nil
+	}
+	xs, err := coords(flagXShort, flagXSameOrPositive)
+	if err != nil {
+		return nil, err
+	}
+	ys, err := coords(flagYShort, flagYSameOrPositive)
+	if err != nil {
+		return nil, err
+	}
+
+	contours := make([][]point, 0, numContours)
+	start := 0
+	for _, end := range ends {
+		if end < start || end >= numPoints {
+			return nil, errors.New("ttf: invalid contour end")
+		}
+		contour := make([]point, 0, end-start+1)
+		for i := start; i <= end; i++ {
+			contour = append(contour, point{x: xs[i], y: ys[i], on: flags[i]&flagOnCurve != 0})
+		}
+		contours = append(contours, contour)
+		start = end + 1
+	}
+	return contours, nil
+}
+
+// flags of the components of composite glyphs
+const (
+	flagArgsAreWords   = 0x0001
+	flagArgsAreXY      = 0x0002
+	flagScale          = 0x0008
+	flagMoreComponents = 0x0020
+	flagXYScale        = 0x0040
+	flagTwoByTwo       = 0x0080
+)
+
+/*
+Resolves the components of a composite glyph. Components placed by matching points aren't moved
+*/
+func (f *Font) compositeContours(data []byte, depth int) ([][]point, error) {
+	errShort := errors.New("ttf: composite glyph is too short")
+	var contours [][]point
+	for {
+		if len(data) < 4 {
+			return nil, errShort
+		}
+		flags := binary.BigEndian.Uint16(data)
+		g := binary.BigEndian.Uint16(data[2:])
+		data = data[4:]
+
+		var dx, dy float64
+		if flags&flagArgsAreWords != 0 {
+			if len(data) < 4 {
+				return nil, errShort
+			}
+			dx, dy = float64(int16(binary.BigEndian.Uint16(data))), float64(int16(binary.BigEndian.Uint16(data[2:])))
+			data = data[4:]
+		} else {
+			if len(data) < 2 {
+				return nil, errShort
+			}
+			dx, dy = float64(int8(data[0])), float64(int8(data[1]))
+			data = data[2:]
+		}
+		if flags&flagArgsAreXY == 0 {
+			dx, dy = 0, 0
+		}
+
+		// the transform is in 2.14 fixed point numbers
+		f2dot14 := func(i int) float64 { return float64(int16(binary.BigEndian.Uint16(data[2*i:]))) / 16384 }
+		a, b, c, d := 1.0, 0.0, 0.0, 1.0
+		switch {
+		case flags&flagScale != 0:
+			if len(data) < 2 {
+				return nil, errShort
+			}
+			a = f2dot14(0)
+			d = a
+			data = data[2:]
+		case flags&flagXYScale != 0:
+			if len(data) < 4 {
+				return nil, errShort
+			}
+			a, d = f2dot14(0), f2dot14(1)
+			data = data[4:]
+		case flags&flagTwoByTwo != 0:
+			if len(data) < 8 {
+				return nil, errShort
+			}
+			a, b, c, d = f2dot14(0), f2dot14(1), f2dot14(2), f2dot14(3)
+			data = data[8:]
+		}
+
+		component, err := f.contours(g, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		for _, contour := range component {
+			for i, p := range contour {
+				contour[i].x = a*p.x + c*p.y + dx
+				contour[i].y = b*p.x + d*p.y + dy
+			}
+			contours = append(contours, contour)
+		}
+		if flags&flagMoreComponents == 0 {
+			return contours, nil
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package ttf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// testGlyph is a glyph of the test font, either a simple glyph of one contour or a component
+// of another glyph that is moved and scaled
+type testGlyph struct {
+	points    []point
+	component uint16
+	dx, dy    int8
+	scale     float64
+	advance   uint16
+}
+
+// the glyphs of the test font: nothing, a square, a circle of quadratic curves, the square
+// at half its size and a space
+var testGlyphs = []testGlyph{
+	{advance: 500},
+	{points: []point{{0, 0, true}, {500, 0, true}, {500, 700, true}, {0, 700, true}}, advance: 600},
+	{points: []point{{250, 0, true}, {500, 0, false}, {500, 250, true}, {500, 500, false}, {250, 500, true}, {0, 500, false}, {0, 250, true}, {0, 0, false}}, advance: 550},
+	{component: 1, dx: 100, scale: 0.5, advance: 400},
+	{advance: 250},
+}
+
+func be16(v int) []byte {
+	return []byte{byte(v >> 8), byte(v)}
+}
+
+func encodeGlyph(g testGlyph) []byte {
+	var buf bytes.Buffer
+	if g.scale != 0 {
+		buf.Write(be16(-1))
+		buf.Write(make([]byte, 8))
+		buf.Write(be16(flagArgsAreXY | flagScale))
+		buf.Write(be16(int(g.component)))
+		buf.Write([]byte{byte(g.dx), byte(g.dy)})
+		buf.Write(be16(int(g.scale * 16384)))
+		return buf.Bytes()
+	}
+	if len(g.points) == 0 {
+		return nil
+	}
+	buf.Write(be16(1))
+	buf.Write(make([]byte, 8))
+	buf.Write(be16(len(g.points) - 1))
+	buf.Write(be16(0))
+
+	// short deltas where they fit, repeated flags are written once
+	var flags []byte
+	var xs, ys bytes.Buffer
+	coord := func(out *bytes.Buffer, delta int, short, same byte) byte {
+		switch {
+		case delta == 0:
+			return same
+		case delta > -256 && delta < 256:
+			if delta > 0 {
+				out.WriteByte(byte(delta))
+				return short | same
+			}
+			out.WriteByte(byte(-delta))
+			return short
+		}
+		out.Write(be16(delta))
+		return 0
+	}
+	var last point
+	for _, p := range g.points {
+		flag := byte(0)
+		if p.on {
+			flag |= flagOnCurve
+		}
+		flag |= coord(&xs, int(p.x-last.x), flagXShort, flagXSameOrPositive)
+		flag |= coord(&ys, int(p.y-last.y), flagYShort, flagYSameOrPositive)
+		flags = append(flags, flag)
+		last = p
+	}
+	for i := 0; i < len(flags); {
+		n := 1
+		for i+n < len(flags) && flags[i+n] == flags[i] {
+			n++
+		}
+		if n > 1 {
+			buf.Write([]byte{flags[i] | flagRepeat, byte(n - 1)})
+		} else {
+			buf.WriteByte(flags[i])
+		}
+		i += n
+	}
+	buf.Write(xs.Bytes())
+	buf.Write(ys.Bytes())
+	if buf.Len()%2 == 1 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+/*
+Builds a TrueType font of testGlyphs that maps A, B and C to the first three glyphs with outlines
+and the space to the last one
+*/
+func testFont() []byte {
+	head := make([]byte, 54)
+	copy(head[18:], be16(1000))
+	hhea := make([]byte, 36)
+	copy(hhea[4:], be16(800))
+	copy(hhea[6:], be16(-200))
+	copy(hhea[34:], be16(len(testGlyphs)))
+	maxp := make([]byte, 6)
+	copy(maxp[4:], be16(len(testGlyphs)))
+
+	var hmtx, loca, glyf bytes.Buffer
+	for _, g := range testGlyphs {
+		hmtx.Write(be16(int(g.advance)))
+		hmtx.Write(be16(0))
+		loca.Write(be16(glyf.Len() / 2))
+		glyf.Write(encodeGlyph(g))
+	}
+	loca.Write(be16(glyf.Len() / 2))
+
+	// segments for the space, A to C and the end
+	starts := []int{0x20, 0x41, 0xffff}
+	ends := []int{0x20, 0x43, 0xffff}
+	deltas := []int{4 - 0x20, 1 - 0x41, 1}
+	var cmap bytes.Buffer
+	cmap.Write(be16(0))
+	cmap.Write(be16(1))
+	cmap.Write(be16(3))
+	cmap.Write(be16(1))
+	cmap.Write([]byte{0, 0, 0, 12})
+	cmap.Write(be16(4))
+	cmap.Write(be16(16 + 8*len(starts)))
+	cmap.Write(be16(0))
+	cmap.Write(be16(2 * len(starts)))
+	cmap.Write(make([]byte, 6))
+	for _, e := range ends {
+		cmap.Write(be16(e))
+	}
+	cmap.Write(be16(0))
+	for _, s := range starts {
+		cmap.Write(be16(s))
+	}
+	for _, d := range deltas {
+		cmap.Write(be16(d))
+	}
+	cmap.Write(make([]byte, 2*len(starts)))
+
+	tables := map[string][]byte{
+		"head": head, "hhea": hhea, "maxp": maxp, "hmtx": hmtx.Bytes(),
+		"loca": loca.Bytes(), "glyf": glyf.Bytes(), "cmap": cmap.Bytes(),
+	}
+	var tags []string
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	var font bytes.Buffer
+	font.Write([]byte{0, 1, 0, 0})
+	font.Write(be16(len(tables)))
+	font.Write(make([]byte, 6))
+	offset := 12 + 16*len(tables)
+	for _, tag := range tags {
+		font.WriteString(tag)
+		font.Write(make([]byte, 4))
+		binary.Write(&font, binary.BigEndian, uint32(offset))
+		binary.Write(&font, binary.BigEndian, uint32(len(tables[tag])))
+		offset += (len(tables[tag]) + 3) &^ 3
+	}
+	for _, tag := range tags {
+		font.Write(tables[tag])
+		font.Write(make([]byte, (4-len(tables[tag])%4)%4))
+	}
+	return font.Bytes()
+}
+
+// testdata/test.ttf is testFont for the tests of other packages
+func TestTestdata(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "test.ttf"))
+	if err != nil || !bytes.Equal(data, testFont()) {
+		t.Errorf("testdata/test.ttf isn't testFont, write it again: %v", err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	f, err := Parse(testFont())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r, want := range map[rune]uint16{'A': 1, 'B': 2, 'C': 3, ' ': 4, 'D': 0, '@': 0, '😀': 0} {
+		if got := f.Index(r); got != want {
+			t.Errorf("Index(%q) = %d, want %d", r, got, want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("OTTO\x00\x00\x00\x00\x00\x00\x00\x00"), []byte("not a font at all")} {
+		if _, err := Parse(data); err == nil {
+			t.Errorf("expected error for %q", data)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	f, err := Parse(testFont())
+	if err != nil {
+		t.Fatal(err)
+	}
+	face := NewFace(f, 100)
+	if face.Ascent() != 80 || face.Descent() != 20 || face.LineHeight() != 100 {
+		t.Errorf("got ascent %v, descent %v and line height %v", face.Ascent(), face.Descent(), face.LineHeight())
+	}
+	if got := face.Measure("AB C"); got != 60+55+25+40 {
+		t.Errorf("Measure = %v", got)
+	}
+}
+
+func TestDraw(t *testing.T) {
+	f, err := Parse(testFont())
+	if err != nil {
+		t.Fatal(err)
+	}
+	face := NewFace(f, 100)
+	img := image.NewRGBA(image.Rect(0, 0, 200, 120))
+	x := face.Draw(img, color.White, 10, 100, "ABC")
+	if x != 10+60+55+40 {
+		t.Errorf("Draw returned %v", x)
+	}
+
+	alpha := func(x, y int) uint8 { return img.RGBAAt(x, y).A }
+	tests := []struct {
+		x, y  int
+		inked bool
+	}{
+		// the square covers 10 to 60 and 30 to 100
+		{10, 30, true}, {59, 99, true}, {35, 65, true},
+		{9, 65, false}, {60, 65, false}, {35, 29, false}, {35, 100, false},
+		// the circle starts at 70 and is 50 wide, its corners are empty
+		{95, 75, true}, {71, 99, false}, {119, 51, false},
+		// the small square covers 135 to 160 and 65 to 100
+		{136, 66, true}, {159, 99, true}, {131, 80, false}, {150, 60, false},
+	}
+	for _, tt := range tests {
+		if a := alpha(tt.x, tt.y); tt.inked && a != 255 || !tt.inked && a > 64 {
+			t.Errorf("alpha at %d,%d is %d, inked %v", tt.x, tt.y, a, tt.inked)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package concat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ArneVogel/concat/hls"
+)
+
+func (d *Downloader) get(ctx context.Context, link string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := d.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return body, &statusError{url: link, statusCode: resp.StatusCode, body: string(body)}
+	}
+
+	return body, nil
+}
+
+// statusError is returned for responses from twitch that aren't 200 OK.
+type statusError struct {
+	url        string
+	statusCode int
+	body       string
+
+	// retryAfter is the delay asked for by the Retry-After header, 0 if there is none
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: status %d: %s", e.url, e.statusCode, strings.TrimSpace(e.body))
+}
+
+func isStatus(err error, statusCode int) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.statusCode == statusCode
+}
+
+func (d *Downloader) tokenAPILink(vodID string) string {
+	return fmt.Sprintf("https://api.twitch.tv/api/vods/%v/access_token?&client_id=%v", vodID, d.clientID())
+}
+
+func (d *Downloader) channelTokenAPILink(channel string) string {
+	return fmt.Sprintf("https://api.twitch.tv/api/channels/%v/access_token?&client_id=%v", strings.ToLower(channel), d.clientID())
+}
+
+func (d *Downloader) usherBaseURL() string {
+	if d.UsherBaseURL != "" {
+		return strings.TrimSuffix(d.UsherBaseURL, "/")
+	}
+	return DefaultUsherBaseURL
+}
+
+func (d *Downloader) usherChannelLink(channel string, token AccessToken) string {
+	query := url.Values{}
+	query.Set("sig", token.Signature)
+	query.Set("token", token.Value)
+	query.Set("allow_source", "true")
+	query.Set("allow_audio_only", "true")
+	query.Set("fast_bread", "true")
+	query.Set("p", strconv.Itoa(rand.Intn(1000000)))
+	return fmt.Sprintf("%s/api/channel/hls/%s.m3u8?%s", d.usherBaseURL(), url.PathEscape(strings.ToLower(channel)), query.Encode())
+}
+
+func (d *Downloader) usherAPILink(vodID string, token AccessToken) string {
+	query := url.Values{}
+	query.Set("nauthsig", token.Signature)
+	query.Set("nauth", token.Value)
+	query.Set("allow_source", "true")
+	query.Set("allow_audio_only", "true")
+	return fmt.Sprintf("%s/vod/%v?%s", d.usherBaseURL(), vodID, query.Encode())
+}
+
+/*
+Returns the signature and token from a tokenAPILink
+signature and token are needed for accessing the usher api
+*/
+func (d *Downloader) accessTokenAPI(ctx context.Context, tokenAPILink string) (string, string, error) {
+	d.printDebugf("\ntokenAPILink: %s\n", tokenAPILink)
+
+	body, err := d.get(ctx, tokenAPILink)
+	if err != nil {
+		return "", "", err
+	}
+
+	// See https://blog.golang.org/json-and-go "Decoding arbitrary data"
+	var data interface{}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return "", "", err
+	}
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return "", "", fmt.Errorf("unexpected token api response: %s", body)
+	}
+	sig := fmt.Sprintf("%v", m["sig"])
+	token := fmt.Sprintf("%v", m["token"])
+	return sig, token, nil
+}
+
+/*
+Downloads the master playlist at usherLink and returns its renditions
+*/
+func (d *Downloader) usherQualities(ctx context.Context, usherLink string) ([]Quality, error) {
+	body, err := d.get(ctx, usherLink)
+	if err != nil {
+		return nil, err
+	}
+
+	d.printDebugf("\nUsher API response:\n%s\n", body)
+
+	master, err := hls.ParseMaster(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var qualities []Quality
+	for _, v := range master.Variants {
+		qualities = append(qualities, Quality{
+			GroupID:    v.Video,
+			Name:       master.Name(v),
+			Resolution: v.Resolution.String(),
+			Width:      v.Resolution.Width,
+			Height:     v.Resolution.Height,
+			Bandwidth:  v.Bandwidth,
+			FrameRate:  v.FrameRate,
+			Codecs:     v.Codecs,
+			URL:        resolveURI(usherLink, v.URI),
+		})
+	}
+	return qualities, nil
+}
+
+func (d *Downloader) getM3U8List(ctx context.Context, m3u8Link string) (string, error) {
+	body, err := d.get(ctx, m3u8Link)
+	if err != nil {
+		return "", err
+	}
+
+	return string(body), nil
+}
+
+// QualityOption is a quality a vod can be downloaded in.
+type QualityOption struct {
+	// Resolution is the name twitch shows for the quality, like 1080p60 or Audio Only.
+	Resolution string
+	// Quality is the value for Options.Quality.
+	Quality string
+}
+
+// QualityOptions returns the available quality options of a vod.
+func (d *Downloader) QualityOptions(ctx context.Context, vodID string) ([]QualityOption, error) {
+	qualities, err := d.Qualities(ctx, vodID)
+	if err != nil {
+		return nil, err
+	}
+
+	var options []QualityOption
+	for _, q := range qualities {
+		options = append(options, QualityOption{Resolution: q.Name, Quality: q.GroupID})
+	}
+	return options, nil
+}
+
+// Quality is a rendition of a vod as listed in its master playlist.
+type Quality struct {
+	// GroupID is the VIDEO group of the rendition, the value for Options.Quality.
+	GroupID string `json:"group_id"`
+	// Name is the name twitch shows for the quality, like 1080p60 or Audio Only.
+	Name string `json:"name"`
+	// Resolution like 1920x1080, empty for audio only.
+	Resolution string  `json:"resolution,omitempty"`
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	Bandwidth  int     `json:"bandwidth"`
+	FrameRate  float64 `json:"frame_rate,omitempty"`
+	Codecs     string  `json:"codecs,omitempty"`
+	// URL of the media playlist of the rendition.
+	URL string `json:"url"`
+}
+
+// Qualities returns the renditions of a vod in the order of its master playlist, best quality first.
+func (d *Downloader) Qualities(ctx context.Context, vodID string) ([]Quality, error) {
+	d.print("Contacting Twitch Server")
+
+	token, err := d.tokenProvider().VODToken(ctx, vodID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get access token: %v", err)
+	}
+
+	qualities, err := d.usherQualities(ctx, d.usherAPILink(vodID, token))
+	if err != nil {
+		return nil, fmt.Errorf("could not download quality options: %v", err)
+	}
+	return qualities, nil
+}
+
+/*
+Resolves a possibly relative uri from a playlist against the link of the playlist
+*/
+func resolveURI(playlistLink string, uri string) string {
+	base, err := url.Parse(playlistLink)
+	if err != nil {
+		return uri
+	}
+	ref, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	return base.ResolveReference(ref).String()
+}
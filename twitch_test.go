@@ -0,0 +1,122 @@
+package concat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// the vod of twitch.tv/reckful used before the old token api was retired
+const vodString string = "187938112"
+const vodInt int = 187938112
+
+const usherResponse string = `#EXTM3U
+#EXT-X-TWITCH-INFO:ORIGIN="s3",B="false",REGION="EU",USER-IP="127.0.0.1",SERVING-ID="f0a9",CLUSTER="cloudfront_vod",USER-COUNTRY="DE",MANIFEST-CLUSTER="cloudfront_vod"
+#EXT-X-MEDIA:TYPE=VIDEO,GROUP-ID="chunked",NAME="1080p60",AUTOSELECT=YES,DEFAULT=YES
+#EXT-X-STREAM-INF:PROGRAM-ID=1,BANDWIDTH=6211302,CODECS="avc1.64002A,mp4a.40.2",RESOLUTION="1920x1080",FRAME-RATE=60.000,VIDEO="chunked"
+%[1]s/903cba256ea3055674be_reckful_26660278144_734937575/chunked/index-dvr.m3u8
+#EXT-X-MEDIA:TYPE=VIDEO,GROUP-ID="720p30",NAME="720p",AUTOSELECT=YES,DEFAULT=YES
+#EXT-X-STREAM-INF:PROGRAM-ID=1,BANDWIDTH=1733316,CODECS="avc1.4D401F,mp4a.40.2",RESOLUTION="1280x720",FRAME-RATE=30.000,VIDEO="720p30"
+%[1]s/903cba256ea3055674be_reckful_26660278144_734937575/720p30/index-dvr.m3u8
+`
+
+func TestTokenAPILink(t *testing.T) {
+	exampleSig := "7ce3d0ca2c65dd66c7da72c43f4ce72cfcd98a72"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != fmt.Sprintf("/api/vods/%v/access_token", vodInt) {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"token":"{\"vod_id\":%v}","sig":"%s","mobile_restricted":false}`, vodInt, exampleSig)
+	}))
+	defer server.Close()
+
+	tokenAPILink := fmt.Sprintf("%s/api/vods/%v/access_token?&client_id=aokchnui2n8q38g0vezl9hq6htzy4c", server.URL, vodInt)
+	d := &Downloader{}
+	sig, token, err := d.accessTokenAPI(context.Background(), tokenAPILink)
+	if err != nil || sig != exampleSig || token != fmt.Sprintf(`{"vod_id":%v}`, vodInt) {
+		t.Errorf("Error in accessTokenAPI, got sig: %s, token: %s, err: %v", sig, token, err)
+	}
+}
+
+func TestAccessUsherAPI(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/vod/"+vodString || r.URL.Query().Get("nauthsig") != "sig" || r.URL.Query().Get("nauth") != `{"vod_id":187938112}` {
+			http.Error(w, "bad request", http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, usherResponse, server.URL)
+	}))
+	defer server.Close()
+
+	d := &Downloader{UsherBaseURL: server.URL}
+	usherAPILink := d.usherAPILink(vodString, AccessToken{Signature: "sig", Value: `{"vod_id":187938112}`})
+	qualities, err := d.usherQualities(context.Background(), usherAPILink)
+
+	var m3u8Link string
+	if len(qualities) > 0 && qualities[0].GroupID == "chunked" {
+		m3u8Link = qualities[0].URL
+	}
+
+	if err != nil || !strings.HasPrefix(m3u8Link, edgecastLinkBegin[:4]) {
+		t.Fatalf("Error in AccessUsherAPI, got m3u8Link: %q, err: %v", m3u8Link, err)
+	}
+
+	baseURL := edgecastBaseURL(m3u8Link)
+
+	baseURLEnd := "903cba256ea3055674be_reckful_26660278144_734937575/chunked/"
+	m3u8LinkEnd := "/903cba256ea3055674be_reckful_26660278144_734937575/chunked/index-dvr.m3u8"
+	if !strings.HasSuffix(baseURL, baseURLEnd) || !strings.HasSuffix(m3u8Link, m3u8LinkEnd) {
+		t.Errorf("Error in AccessUsherAPI, got baseUrl: %s, m3u8Link: %s", baseURL, m3u8Link)
+	}
+	if len(qualities) != 2 {
+		t.Errorf("Expected 2 qualities, got %v", qualities)
+	}
+}
+
+func TestQualities(t *testing.T) {
+	gqlServer := newGQLServer(t, playbackAccessTokenHandler(t))
+	defer gqlServer.Close()
+
+	var usherServer *httptest.Server
+	usherServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, usherResponse, usherServer.URL)
+	}))
+	defer usherServer.Close()
+
+	d := &Downloader{GQLEndpoint: gqlServer.URL, UsherBaseURL: usherServer.URL}
+	qualities, err := d.Qualities(context.Background(), vodString)
+	if err != nil || len(qualities) != 2 {
+		t.Fatalf("Qualities: got %v, %v", qualities, err)
+	}
+
+	want := Quality{
+		GroupID:    "720p30",
+		Name:       "720p",
+		Resolution: "1280x720",
+		Width:      1280,
+		Height:     720,
+		Bandwidth:  1733316,
+		FrameRate:  30,
+		Codecs:     "avc1.4D401F,mp4a.40.2",
+		URL:        usherServer.URL + "/903cba256ea3055674be_reckful_26660278144_734937575/720p30/index-dvr.m3u8",
+	}
+	if qualities[1] != want {
+		t.Errorf("Qualities: got %+v, want %+v", qualities[1], want)
+	}
+
+	out, err := json.Marshal(qualities[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{`"group_id":"720p30"`, `"name":"720p"`, `"resolution":"1280x720"`, `"bandwidth":1733316`, `"frame_rate":30`, `"codecs":`, `"url":`} {
+		if !strings.Contains(string(out), key) {
+			t.Errorf("json %s doesn't contain %s", out, key)
+		}
+	}
+}